@@ -4,6 +4,21 @@ type DmaError struct {
 	Message string `json:"error"`
 }
 
+// Function Error implements the error interface, so venue errors returned in a
+// DMA response can be handled like any other error.
+func (e DmaError) Error() string {
+	return e.Message
+}
+
+// Function FirstDmaError returns the first venue error in errs, or nil if there
+// are none.
+func FirstDmaError(errs []DmaError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs[0]
+}
+
 type PlaceDmaOrderRequest struct {
 	UserId      string            `json:"user_id"`
 	VenueId     string            `json:"venue"`
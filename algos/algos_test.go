@@ -0,0 +1,220 @@
+package algos
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+)
+
+const uid = "algos@example.com"
+
+var t0 = time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+// testEngine replays one snapshot per second with the given best bids and offers
+// on Gemini.
+func testEngine(quotes ...[2]string) *backtest.Engine {
+	var snaps []backtest.Snapshot
+	for i, q := range quotes {
+		snaps = append(snaps, backtest.Snapshot{
+			Time:      t0.Add(time.Duration(i) * time.Second),
+			Asset:     routefire.Btc,
+			BaseAsset: routefire.Usd,
+			Book: routefire.DmaOrderBook{
+				Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: q[0], Amount: "10"}},
+				Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: q[1], Amount: "10"}},
+			},
+		})
+	}
+	bt := backtest.New(snaps, backtest.Config{})
+	bt.Step()
+	return bt
+}
+
+func repeat(q [2]string, n int) [][2]string {
+	out := make([][2]string, n)
+	for i := range out {
+		out[i] = q
+	}
+	return out
+}
+
+func TestTWAP(t *testing.T) {
+	bt := testEngine(repeat([2]string{"99", "100"}, 6)...)
+	s, err := NewTWAP(bt, bt, uid, Params{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy,
+		Quantity: 4, Duration: 4 * time.Second, Slices: 4, Interval: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Step()
+	if p := s.Progress(); p.Filled != 0 || p.Working != 1 || p.Scheduled != 1 {
+		t.Errorf("first slice should place 1, got %+v", p)
+	}
+	p := s.Run()
+	if p.Status != routefire.StatusFilled || p.Filled != 4 || p.Children != 4 || p.AvgPrice != 100 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	// The last child is placed in the last slice and seen filled at the next step.
+	if !bt.Now().Equal(t0.Add(4 * time.Second)) {
+		t.Errorf("order should complete at the end of the schedule, not %s", bt.Now())
+	}
+}
+
+func TestChildQuantitiesRounded(t *testing.T) {
+	bt := testEngine(repeat([2]string{"99", "100"}, 5)...)
+	s, err := NewTWAP(bt, bt, uid, Params{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy,
+		Quantity: 1, Duration: 3 * time.Second, Slices: 3, Interval: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := s.Run()
+	if p.Status != routefire.StatusFilled || p.Children != 3 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	approx(t, "filled", p.Filled, 1)
+	for _, o := range bt.Exchange().Orders() {
+		if o.Quantity != routefire.RoundQuantity(routefire.Btc, o.Quantity) {
+			t.Errorf("child quantity %v is not rounded to btc's precision", o.Quantity)
+		}
+	}
+}
+
+func TestVWAPProfileAndLimit(t *testing.T) {
+	// The offer is through the limit for two seconds, then comes in.
+	bt := testEngine([2]string{"99", "102"}, [2]string{"100", "103"}, [2]string{"99", "100"}, [2]string{"99", "100"})
+	s, err := NewVWAP(bt, bt, uid, Params{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy,
+		Quantity: 4, Limit: 101, Duration: 4 * time.Second, Interval: time.Second,
+	}, []float64{3, 0, 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Step()
+	if p := s.Progress(); p.Working != 3 || p.Filled != 0 {
+		t.Errorf("expected 3 resting at the limit, got %+v", p)
+	}
+	bt.Wait(time.Second)
+	s.Step()
+	if p := s.Progress(); p.Children != 1 {
+		t.Errorf("a child at the limit should not be replaced, got %+v", p)
+	}
+	p := s.Run()
+	if p.Status != routefire.StatusFilled || p.Filled != 4 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	// 3 filled resting at the limit, 1 taken at the offer.
+	approx(t, "avg price", p.AvgPrice, (3*101+100)/4.0)
+
+	if _, err := NewVWAP(bt, bt, uid, Params{Side: routefire.SideBuy, Quantity: 1, Duration: time.Second}, []float64{0, 0}); err != ErrInvalidParams {
+		t.Errorf("expected ErrInvalidParams, got %v", err)
+	}
+}
+
+func TestScheduleExpires(t *testing.T) {
+	bt := testEngine(repeat([2]string{"99", "105"}, 4)...)
+	s, _ := NewTWAP(bt, bt, uid, Params{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy,
+		Quantity: 2, Limit: 101, Duration: 2 * time.Second, Slices: 2, Interval: time.Second,
+	})
+	p := s.Run()
+	if p.Status != routefire.StatusExpired || p.Filled != 0 || p.Working != 0 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	for _, o := range bt.Exchange().Orders() {
+		if o.IsOpen() {
+			t.Errorf("child %s left open", o.ID)
+		}
+	}
+}
+
+func TestIceberg(t *testing.T) {
+	// The bid trades through the first clip, then stays through the limit.
+	bt := testEngine(append([][2]string{{"99", "100"}}, repeat([2]string{"102", "103"}, 4)...)...)
+	o, err := NewIceberg(bt, bt, uid, IcebergParams{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideSell, Venue: routefire.Gemini,
+		Quantity: 2.5, Clip: 1, Price: 101,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Step()
+	if p := o.Progress(); p.Working != 1 || p.Filled != 0 {
+		t.Errorf("only the clip should be shown, got %+v", p)
+	}
+	p := o.Run()
+	if p.Status != routefire.StatusFilled || p.Filled != 2.5 || p.Children != 3 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+}
+
+func TestPeggedHysteresis(t *testing.T) {
+	bt := testEngine([2]string{"99", "105"}, [2]string{"99.1", "105"}, [2]string{"100", "105"}, [2]string{"101", "105"}, [2]string{"101", "105"})
+	o, err := NewPegged(bt, bt, uid, PegParams{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy, Venue: routefire.Gemini,
+		Quantity: 1, Peg: PegPrimary, Offset: 0.5, Limit: 100, Tolerance: 0.2, MinReplace: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Step()
+	for bt.Wait(time.Second) {
+		o.Step()
+	}
+	// The move to 99.1 is within tolerance and the move to 101 comes too soon
+	// after the replacement at 100; it is placed, capped at the limit, a second
+	// later.
+	if n := o.Replacements(); n != 2 {
+		t.Errorf("expected 2 replacements, got %d", n)
+	}
+	orders := bt.Exchange().Orders()
+	if last := orders[len(orders)-1]; last.Price != 100 || !last.IsOpen() {
+		t.Errorf("expected the order resting at the limit, got %+v", last)
+	}
+	if err := o.Cancel(); err != nil || o.Progress().Status != routefire.StatusCancelled {
+		t.Errorf("unexpected cancel %v %+v", err, o.Progress())
+	}
+}
+
+func TestSubPrecisionQuantities(t *testing.T) {
+	bt := testEngine(append([][2]string{{"99", "100"}}, repeat([2]string{"102", "103"}, 4)...)...)
+	p := IcebergParams{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideSell, Venue: routefire.Gemini,
+		Quantity: 1, Clip: 1e-9, Price: 101,
+	}
+	if _, err := NewIceberg(bt, bt, uid, p); err != ErrZeroQuantity {
+		t.Errorf("expected ErrZeroQuantity for the clip, got %v", err)
+	}
+	if _, err := NewPegged(bt, bt, uid, PegParams{
+		Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy, Venue: routefire.Gemini,
+		Quantity: 1e-9, Peg: PegMid,
+	}); err != ErrZeroQuantity {
+		t.Errorf("expected ErrZeroQuantity for the quantity, got %v", err)
+	}
+
+	// A remainder below the asset's precision cannot be placed and counts as
+	// filled.
+	p.Quantity, p.Clip = 1+1e-9, 1
+	o, err := NewIceberg(bt, bt, uid, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr := o.Run(); pr.Status != routefire.StatusFilled || pr.Children != 1 || pr.Err != nil {
+		t.Errorf("unexpected progress %+v", pr)
+	}
+	if !bt.Now().Before(t0.Add(4 * time.Second)) {
+		t.Errorf("order should complete before the clock stops, not %s", bt.Now())
+	}
+}
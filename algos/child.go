@@ -0,0 +1,195 @@
+package algos
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
+)
+
+const epsilon = 1e-9
+
+var ErrZeroQuantity = errors.New("algos: quantity rounds to zero")
+
+// childOrder is a working DMA child order.
+type childOrder struct {
+	venue    string
+	id       string
+	quantity float64
+	price    float64
+	filled   float64
+}
+
+func (c *childOrder) remaining() float64 {
+	return c.quantity - c.filled
+}
+
+// worker places and tracks the child orders of one parent order, one child at
+// a time. Fills are valued at the child's limit price, since DMA order status
+// does not report execution prices.
+type worker struct {
+	api       routefire.DMA
+	userId    string
+	asset     string
+	baseAsset string
+	side      string
+
+	child    *childOrder
+	filled   float64
+	notional float64
+	children int
+}
+
+func newWorker(api routefire.DMA, userId, asset, baseAsset, side string) worker {
+	return worker{api: api, userId: userId, asset: asset, baseAsset: baseAsset, side: strings.ToUpper(side)}
+}
+
+func (w *worker) avgPrice() float64 {
+	if w.filled <= 0 {
+		return 0
+	}
+	return w.notional / w.filled
+}
+
+func (w *worker) working() float64 {
+	if w.child == nil {
+		return 0
+	}
+	return w.child.remaining()
+}
+
+func (w *worker) book() (*routefire.DmaOrderBook, error) {
+	ob, err := w.api.GetConsolidatedOrderBookDMA(w.userId, w.asset, w.baseAsset)
+	if err == nil {
+		err = routefire.FirstDmaError(ob.Errors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ob.Data, nil
+}
+
+// round rounds a child quantity down to the asset's precision.
+func (w *worker) round(qty float64) float64 {
+	return routefire.RoundQuantity(w.asset, qty)
+}
+
+// complete reports whether what remains of a parent order of quantity total
+// rounds to zero, so that no further child can be placed.
+func (w *worker) complete(total float64) bool {
+	return w.round(total-w.filled) <= 0
+}
+
+// place submits a new child order, rounded down to the asset's precision. A
+// quantity that rounds to zero is an error. There must be no working child.
+func (w *worker) place(venue string, qty, price float64) error {
+	if qty = w.round(qty); qty <= 0 {
+		return ErrZeroQuantity
+	}
+	resp, err := w.api.SubmitOrderDMA(w.userId, venue, w.asset, w.baseAsset, w.side,
+		routefire.FormatQuantity(w.asset, qty), routefire.FormatFloat(price), nil)
+	if err == nil {
+		err = routefire.FirstDmaError(resp.Errors)
+	}
+	if err != nil {
+		return err
+	}
+	w.child = &childOrder{venue: venue, id: resp.VenueOrderId, quantity: qty, price: price}
+	w.children++
+	return nil
+}
+
+// poll books new fills of the working child, and forgets the child once it is
+// no longer working.
+func (w *worker) poll() error {
+	c := w.child
+	if c == nil {
+		return nil
+	}
+	st, err := w.api.OrderStatusDMA(w.userId, c.venue, c.id)
+	if err == nil {
+		err = routefire.FirstDmaError(st.Errors)
+	}
+	if err != nil {
+		return err
+	}
+	if filled, err := strconv.ParseFloat(st.FilledAmount, 64); err == nil && filled > c.filled {
+		w.filled += filled - c.filled
+		w.notional += (filled - c.filled) * c.price
+		c.filled = filled
+	}
+	if routefire.IsOpenStatus(st.Status) && c.remaining() > epsilon {
+		return nil
+	}
+	w.child = nil
+	return nil
+}
+
+// cancel cancels the working child, then polls it to book any fills that raced
+// the cancel. If the cancel fails and the child is still working, it is kept.
+func (w *worker) cancel() error {
+	c := w.child
+	if c == nil {
+		return nil
+	}
+	resp, err := w.api.CancelOrderDMA(w.userId, c.venue, c.id)
+	if err == nil {
+		err = routefire.FirstDmaError(resp.Errors)
+	}
+	if perr := w.poll(); perr != nil && err == nil {
+		err = perr
+	}
+	if err != nil && w.child != nil {
+		return err
+	}
+	w.child = nil
+	return nil
+}
+
+// touch returns the best price on the far side of the book, i.e. the best
+// offer for a buy, and its venue. If venue is set, only its entries count;
+// otherwise venues are ranked by net-of-fee price.
+func touch(ob *routefire.DmaOrderBook, side, venue string, sched *fees.Schedule) (float64, string, error) {
+	if venue != "" {
+		filtered := &routefire.DmaOrderBook{}
+		for _, e := range ob.Bids {
+			if strings.EqualFold(e.Venue, venue) {
+				filtered.Bids = append(filtered.Bids, e)
+			}
+		}
+		for _, e := range ob.Offers {
+			if strings.EqualFold(e.Venue, venue) {
+				filtered.Offers = append(filtered.Offers, e)
+			}
+		}
+		ob = filtered
+	}
+	var e routefire.DmaOrderBookEntry
+	var err error
+	if side == routefire.SideBuy {
+		e, _, err = sched.BestOffer(ob)
+	} else {
+		e, _, err = sched.BestBid(ob)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	px, _, err := e.Floats()
+	return px, strings.ToUpper(e.Venue), err
+}
+
+// capPrice limits a price to what a limit allows.
+func capPrice(side string, price, limit float64) float64 {
+	if limit <= 0 {
+		return price
+	}
+	if side == routefire.SideBuy && price > limit {
+		return limit
+	}
+	if side == routefire.SideSell && price < limit {
+		return limit
+	}
+	return price
+}
@@ -0,0 +1,127 @@
+package algos
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+// Type IcebergParams describes an iceberg order: a limit order of which only
+// a clip is shown at a time.
+type IcebergParams struct {
+	Asset     string
+	BaseAsset string
+	Side      string
+	Venue     string
+	Quantity  float64 // Total quantity
+	Clip      float64 // Quantity shown at a time
+	Price     float64 // Limit price
+	// Interval is the time between steps in Run. Defaults to one second.
+	Interval time.Duration
+}
+
+// Type Iceberg works an iceberg order: it shows one clip at the limit price
+// and, once the clip has filled, replenishes it with the next, until the total
+// quantity has filled.
+type Iceberg struct {
+	w     worker
+	p     IcebergParams
+	clock strategy.Clock
+
+	lock   sync.Mutex
+	status string
+	err    error
+}
+
+// Function NewIceberg creates an iceberg order.
+func NewIceberg(api routefire.DMA, clock strategy.Clock, userId string, p IcebergParams) (*Iceberg, error) {
+	p.Side = strings.ToUpper(p.Side)
+	if p.Quantity <= 0 || p.Clip <= 0 || p.Price <= 0 || p.Venue == "" ||
+		(p.Side != routefire.SideBuy && p.Side != routefire.SideSell) {
+		return nil, ErrInvalidParams
+	}
+	if routefire.RoundQuantity(p.Asset, p.Quantity) <= 0 || routefire.RoundQuantity(p.Asset, p.Clip) <= 0 {
+		return nil, ErrZeroQuantity
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Second
+	}
+	return &Iceberg{
+		w:      newWorker(api, userId, p.Asset, p.BaseAsset, p.Side),
+		p:      p,
+		clock:  clock,
+		status: routefire.StatusOpen,
+	}, nil
+}
+
+// Function Progress returns the current state of the order.
+func (o *Iceberg) Progress() Progress {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return Progress{
+		Status:   o.status,
+		Quantity: o.p.Quantity,
+		Filled:   o.w.filled,
+		Working:  o.w.working(),
+		AvgPrice: o.w.avgPrice(),
+		Children: o.w.children,
+		Err:      o.err,
+	}
+}
+
+// Function Step polls the shown clip and replenishes it once it has filled.
+func (o *Iceberg) Step() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.status != routefire.StatusOpen && o.status != routefire.StatusPartiallyFilled {
+		return nil
+	}
+	o.err = o.step()
+	return o.err
+}
+
+func (o *Iceberg) step() error {
+	if err := o.w.poll(); err != nil {
+		return err
+	}
+	remaining := o.p.Quantity - o.w.filled
+	switch {
+	case o.w.complete(o.p.Quantity):
+		o.status = routefire.StatusFilled
+		return nil
+	case o.w.filled > 0:
+		o.status = routefire.StatusPartiallyFilled
+	}
+	if o.w.child != nil {
+		return nil
+	}
+	return o.w.place(o.p.Venue, math.Min(o.p.Clip, remaining), o.p.Price)
+}
+
+// Function Run steps the order every Interval until it fills or the clock
+// stops, in which case the shown clip is cancelled.
+func (o *Iceberg) Run() Progress {
+	return run(o, o.clock, o.p.Interval)
+}
+
+// Function Cancel cancels the shown clip and stops the order.
+func (o *Iceberg) Cancel() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.status != routefire.StatusOpen && o.status != routefire.StatusPartiallyFilled {
+		return nil
+	}
+	if err := o.w.cancel(); err != nil {
+		o.err = err
+		return err
+	}
+	o.status = routefire.StatusCancelled
+	if o.w.complete(o.p.Quantity) {
+		o.status = routefire.StatusFilled
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package algos
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+// Peg references, the consolidated price a pegged order tracks.
+const (
+	PegPrimary = "primary" // Best price on the order's own side: the best bid for a buy
+	PegMid     = "mid"     // Consolidated mid price
+	PegMarket  = "market"  // Best price on the far side: the best offer for a buy
+)
+
+// Type PegParams describes a pegged order.
+type PegParams struct {
+	Asset     string
+	BaseAsset string
+	Side      string
+	Venue     string
+	Quantity  float64
+	// Peg is the reference price tracked: PegPrimary, PegMid or PegMarket.
+	Peg string
+	// Offset is how far from the reference the order is priced, away from the
+	// market: below the reference for a buy, above it for a sell. A negative
+	// offset prices the order more aggressively.
+	Offset float64
+	// Limit is the worst price at which the order may be placed; zero for none.
+	Limit float64
+	// Tolerance is how far the pegged price may move before the order is
+	// replaced.
+	Tolerance float64
+	// MinReplace is the least time between replacements.
+	MinReplace time.Duration
+	// Interval is the time between steps in Run. Defaults to one second.
+	Interval time.Duration
+}
+
+// Type Pegged works a pegged order: a limit order whose price tracks a
+// reference price in the consolidated book, by cancelling and replacing it.
+// To keep message traffic down, the order is only replaced when the pegged
+// price has moved by more than Tolerance, and at most once per MinReplace.
+type Pegged struct {
+	w     worker
+	p     PegParams
+	clock strategy.Clock
+
+	lock     sync.Mutex
+	placed   time.Time
+	replaced int
+	status   string
+	err      error
+}
+
+// Function NewPegged creates a pegged order.
+func NewPegged(api routefire.DMA, clock strategy.Clock, userId string, p PegParams) (*Pegged, error) {
+	p.Side = strings.ToUpper(p.Side)
+	if p.Quantity <= 0 || p.Venue == "" || p.Limit < 0 || p.Tolerance < 0 ||
+		(p.Side != routefire.SideBuy && p.Side != routefire.SideSell) {
+		return nil, ErrInvalidParams
+	}
+	switch p.Peg {
+	case PegPrimary, PegMid, PegMarket:
+	default:
+		return nil, ErrInvalidParams
+	}
+	if routefire.RoundQuantity(p.Asset, p.Quantity) <= 0 {
+		return nil, ErrZeroQuantity
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Second
+	}
+	return &Pegged{
+		w:      newWorker(api, userId, p.Asset, p.BaseAsset, p.Side),
+		p:      p,
+		clock:  clock,
+		status: routefire.StatusOpen,
+	}, nil
+}
+
+// Function Progress returns the current state of the order.
+func (o *Pegged) Progress() Progress {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return Progress{
+		Status:   o.status,
+		Quantity: o.p.Quantity,
+		Filled:   o.w.filled,
+		Working:  o.w.working(),
+		AvgPrice: o.w.avgPrice(),
+		Children: o.w.children,
+		Err:      o.err,
+	}
+}
+
+// Function Replacements returns the number of times the order has been
+// cancelled and replaced.
+func (o *Pegged) Replacements() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.replaced
+}
+
+// Function Step polls the working order and replaces it if the pegged price
+// has moved.
+func (o *Pegged) Step() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.status != routefire.StatusOpen && o.status != routefire.StatusPartiallyFilled {
+		return nil
+	}
+	o.err = o.step(o.clock.Now())
+	return o.err
+}
+
+func (o *Pegged) step(now time.Time) error {
+	if err := o.w.poll(); err != nil {
+		return err
+	}
+	remaining := o.p.Quantity - o.w.filled
+	switch {
+	case o.w.complete(o.p.Quantity):
+		o.status = routefire.StatusFilled
+		return nil
+	case o.w.filled > 0:
+		o.status = routefire.StatusPartiallyFilled
+	}
+
+	ob, err := o.w.book()
+	if err != nil {
+		return err
+	}
+	price, err := o.price(ob)
+	if err != nil {
+		return err
+	}
+	if c := o.w.child; c != nil {
+		if math.Abs(c.price-price) <= o.p.Tolerance+epsilon || now.Sub(o.placed) < o.p.MinReplace {
+			return nil
+		}
+		if err := o.w.cancel(); err != nil {
+			return err
+		}
+		o.replaced++
+		if remaining = o.p.Quantity - o.w.filled; o.w.complete(o.p.Quantity) {
+			o.status = routefire.StatusFilled
+			return nil
+		}
+	}
+	if err := o.w.place(o.p.Venue, remaining, price); err != nil {
+		return err
+	}
+	o.placed = now
+	return nil
+}
+
+// price returns the pegged price for a book.
+func (o *Pegged) price(ob *routefire.DmaOrderBook) (float64, error) {
+	var ref float64
+	var err error
+	switch o.p.Peg {
+	case PegMid:
+		ref, err = ob.MidPrice()
+	default:
+		var e routefire.DmaOrderBookEntry
+		if (o.p.Peg == PegPrimary) == (o.p.Side == routefire.SideBuy) {
+			e, err = ob.BestBid()
+		} else {
+			e, err = ob.BestOffer()
+		}
+		if err == nil {
+			ref, _, err = e.Floats()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if o.p.Side == routefire.SideBuy {
+		ref -= o.p.Offset
+	} else {
+		ref += o.p.Offset
+	}
+	return capPrice(o.p.Side, ref, o.p.Limit), nil
+}
+
+// Function Run steps the order every Interval until it fills or the clock
+// stops, in which case the working order is cancelled.
+func (o *Pegged) Run() Progress {
+	return run(o, o.clock, o.p.Interval)
+}
+
+// Function Cancel cancels the working order and stops the pegged order.
+func (o *Pegged) Cancel() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.status != routefire.StatusOpen && o.status != routefire.StatusPartiallyFilled {
+		return nil
+	}
+	if err := o.w.cancel(); err != nil {
+		o.err = err
+		return err
+	}
+	o.status = routefire.StatusCancelled
+	if o.w.complete(o.p.Quantity) {
+		o.status = routefire.StatusFilled
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// Package algos provides client-side execution algorithms built on the DMA
+// API, for accounts without access to Routefire's server-side algorithms. Each
+// algorithm works a parent order through one DMA child order at a time,
+// cancelling and replacing it as the market moves.
+//
+// Algorithms are driven by a strategy.Clock, so the same code runs live, with
+// strategy.RealClock, or in a backtest.
+package algos
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+var ErrInvalidParams = errors.New("algos: invalid parameters")
+
+// Type Algo is an order worked by a client-side algorithm.
+type Algo interface {
+	// Step polls the working child order and acts on the market.
+	Step() error
+	// Run steps the order until it finishes or its clock stops.
+	Run() Progress
+	// Cancel cancels the working child order and stops the order.
+	Cancel() error
+	Progress() Progress
+}
+
+var (
+	_ Algo = (*Scheduled)(nil)
+	_ Algo = (*Iceberg)(nil)
+	_ Algo = (*Pegged)(nil)
+)
+
+// run steps an algorithm every interval until it finishes or the clock stops,
+// in which case it is cancelled.
+func run(a Algo, clock strategy.Clock, interval time.Duration) Progress {
+	for {
+		a.Step()
+		if p := a.Progress(); p.Done() {
+			return p
+		}
+		if !clock.Wait(interval) {
+			a.Cancel()
+			return a.Progress()
+		}
+	}
+}
+
+// Type Params describes a parent order to be worked over a schedule.
+type Params struct {
+	Asset     string
+	BaseAsset string
+	Side      string
+	Quantity  float64
+	// Limit is the worst price at which to trade; zero for no limit. When the
+	// market is through the limit, the child order rests at the limit.
+	Limit float64
+	// Start is when the schedule starts; zero for the first step.
+	Start time.Time
+	// Duration is the length of the schedule.
+	Duration time.Duration
+	// Slices is the number of equal time slices in the schedule. Defaults to
+	// 10 for TWAP, or the length of the volume profile for VWAP.
+	Slices int
+	// Venue, if set, is the only venue traded. Otherwise each child goes to
+	// the venue with the best price net of Fees.
+	Venue string
+	Fees  *fees.Schedule
+	// Interval is the time between steps in Run. Defaults to a fifth of a slice.
+	Interval time.Duration
+}
+
+// Type Progress reports the state of an algorithmic order.
+type Progress struct {
+	Status    string // One of the routefire.Status constants
+	Quantity  float64
+	Filled    float64
+	Scheduled float64 // Quantity due by now, for scheduled orders
+	Working   float64 // Quantity of the working child order
+	AvgPrice  float64 // Average child price, weighted by fills
+	Slice     int     // Current slice, from 0, for scheduled orders
+	Slices    int
+	Children  int // Child orders placed
+	Err       error
+}
+
+// Function Remaining returns the quantity still to be filled.
+func (p Progress) Remaining() float64 {
+	return p.Quantity - p.Filled
+}
+
+// Function Done reports whether the order has finished.
+func (p Progress) Done() bool {
+	switch p.Status {
+	case routefire.StatusOpen, routefire.StatusPartiallyFilled:
+		return false
+	}
+	return true
+}
+
+// Type Scheduled works a parent order over a schedule: by the end of each
+// slice, the cumulative fraction of the order given by the profile for that
+// slice is due. Each step, the child order is priced at the far touch, capped
+// at the limit, and sized to bring fills up to the schedule; it is replaced
+// when its price goes stale. At the end of the schedule, any working child is
+// cancelled and the order expires.
+type Scheduled struct {
+	w          worker
+	p          Params
+	clock      strategy.Clock
+	cumulative []float64
+
+	lock   sync.Mutex
+	start  time.Time
+	slice  int
+	status string
+	err    error
+}
+
+// Function NewTWAP creates a time-weighted schedule: equal quantities per slice.
+func NewTWAP(api routefire.DMA, clock strategy.Clock, userId string, p Params) (*Scheduled, error) {
+	if p.Slices <= 0 {
+		p.Slices = 10
+	}
+	profile := make([]float64, p.Slices)
+	for i := range profile {
+		profile[i] = 1
+	}
+	return NewVWAP(api, clock, userId, p, profile)
+}
+
+// Function NewVWAP creates a volume-weighted schedule. Profile holds the
+// expected volume in each slice, e.g. from historical volume by time of day;
+// the order is spread across slices in proportion to it.
+func NewVWAP(api routefire.DMA, clock strategy.Clock, userId string, p Params, profile []float64) (*Scheduled, error) {
+	p.Side = strings.ToUpper(p.Side)
+	if p.Slices <= 0 {
+		p.Slices = len(profile)
+	}
+	if p.Quantity <= 0 || p.Duration <= 0 || p.Slices != len(profile) || p.Limit < 0 ||
+		(p.Side != routefire.SideBuy && p.Side != routefire.SideSell) {
+		return nil, ErrInvalidParams
+	}
+	total := 0.0
+	for _, v := range profile {
+		if v < 0 {
+			return nil, ErrInvalidParams
+		}
+		total += v
+	}
+	if total <= 0 {
+		return nil, ErrInvalidParams
+	}
+	if routefire.RoundQuantity(p.Asset, p.Quantity) <= 0 {
+		return nil, ErrZeroQuantity
+	}
+	cumulative := make([]float64, len(profile))
+	sum := 0.0
+	for i, v := range profile {
+		sum += v
+		cumulative[i] = sum / total
+	}
+	if p.Interval <= 0 {
+		p.Interval = p.Duration / time.Duration(p.Slices*5)
+	}
+	return &Scheduled{
+		w:          newWorker(api, userId, p.Asset, p.BaseAsset, p.Side),
+		p:          p,
+		clock:      clock,
+		cumulative: cumulative,
+		start:      p.Start,
+		status:     routefire.StatusOpen,
+	}, nil
+}
+
+// Function Progress returns the current state of the order.
+func (s *Scheduled) Progress() Progress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.progress()
+}
+
+func (s *Scheduled) progress() Progress {
+	return Progress{
+		Status:    s.status,
+		Quantity:  s.p.Quantity,
+		Filled:    s.w.filled,
+		Scheduled: s.p.Quantity * s.cumulative[s.slice],
+		Working:   s.w.working(),
+		AvgPrice:  s.w.avgPrice(),
+		Slice:     s.slice,
+		Slices:    s.p.Slices,
+		Children:  s.w.children,
+		Err:       s.err,
+	}
+}
+
+// Function Step polls the working child and brings the order up to schedule.
+// Errors are also recorded in Progress.
+func (s *Scheduled) Step() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.progress().Done() {
+		return nil
+	}
+	s.err = s.step(s.clock.Now())
+	return s.err
+}
+
+func (s *Scheduled) step(now time.Time) error {
+	if s.start.IsZero() {
+		s.start = now
+	}
+	if now.Before(s.start) {
+		return nil
+	}
+	if err := s.w.poll(); err != nil {
+		return err
+	}
+	if s.w.complete(s.p.Quantity) {
+		s.finish(routefire.StatusFilled)
+		return nil
+	}
+	elapsed := now.Sub(s.start)
+	if elapsed >= s.p.Duration {
+		if err := s.w.cancel(); err != nil {
+			return err
+		}
+		s.finish(routefire.StatusExpired)
+		return nil
+	}
+	s.slice = int(elapsed * time.Duration(s.p.Slices) / s.p.Duration)
+	s.status = routefire.StatusOpen
+	if s.w.filled > 0 {
+		s.status = routefire.StatusPartiallyFilled
+	}
+
+	ob, err := s.w.book()
+	if err != nil {
+		return err
+	}
+	price, venue, err := touch(ob, s.p.Side, s.p.Venue, s.p.Fees)
+	if err != nil {
+		return err
+	}
+	price = capPrice(s.p.Side, price, s.p.Limit)
+
+	due := s.p.Quantity*s.cumulative[s.slice] - s.w.filled
+	if c := s.w.child; c != nil {
+		if c.price == price && c.venue == venue && c.remaining() >= s.w.round(due)-epsilon {
+			return nil
+		}
+		// Stale price, or behind schedule: replace the child.
+		if err := s.w.cancel(); err != nil {
+			return err
+		}
+		due = s.p.Quantity*s.cumulative[s.slice] - s.w.filled
+	}
+	if s.w.round(due) <= 0 {
+		return nil
+	}
+	return s.w.place(venue, due, price)
+}
+
+// finish ends the order, as filled if it is, whatever the reason.
+func (s *Scheduled) finish(status string) {
+	if s.w.complete(s.p.Quantity) {
+		status = routefire.StatusFilled
+	}
+	s.status = status
+}
+
+// Function Run steps the order every Interval until it finishes or the clock
+// stops, in which case the working child is cancelled.
+func (s *Scheduled) Run() Progress {
+	return run(s, s.clock, s.p.Interval)
+}
+
+// Function Cancel cancels the working child and stops the order.
+func (s *Scheduled) Cancel() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.progress().Done() {
+		return nil
+	}
+	if err := s.w.cancel(); err != nil {
+		s.err = err
+		return err
+	}
+	s.finish(routefire.StatusCancelled)
+	return nil
+}
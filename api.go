@@ -0,0 +1,46 @@
+package routefire
+
+import "errors"
+
+// Type DMA is the direct market access (DMA) API. It is implemented by Client,
+// and by the paper trading and backtesting clients, so that trading code can be
+// written once against this interface.
+type DMA interface {
+	SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*PlaceDmaOrderResponse, error)
+	OrderStatusDMA(userId, venue, venueOrdId string) (*DmaOrderStatusResponse, error)
+	CancelOrderDMA(userId, venue, venueOrdId string) (*CancelDmaOrderResponse, error)
+	GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*DmaOrderBookResponse, error)
+	BalanceDMA(userId, venue, assetId string) (*DmaBalanceResponse, error)
+}
+
+// Type API is the complete Routefire API implemented by Client: the DMA API plus
+// algorithmic orders and market data.
+type API interface {
+	DMA
+	SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*SubmitOrderResponse, error)
+	GetOrderStatus(userId string, orderId string) (*OrderStatusResponse, error)
+	CancelOrder(userId string, orderId string) (*OrderStatusResponse, error)
+	GetBalances(uid, asset string) (map[string]string, error)
+	GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*InquiryResponse, error)
+	GetConsolidatedOrderBook(uid, buyAsset, sellAsset string) (*OrderBookResponse, error)
+}
+
+// ErrNoAlgoAPI is returned for algorithm orders and calls made through a
+// wrapper created over a DMA-only API, such as a backtest engine.
+var ErrNoAlgoAPI = errors.New("routefire: algorithm orders need a routefire.API, not a DMA-only API")
+
+// Function IsOpenStatus reports whether an order with the given status, DMA or
+// algorithm, may still fill. An empty status, as reported before a venue has
+// acknowledged an order, counts as open.
+func IsOpenStatus(status string) bool {
+	switch status {
+	case StatusOpen, StatusPartiallyFilled, "":
+		return true
+	}
+	return false
+}
+
+var (
+	_ DMA = (*Client)(nil)
+	_ API = (*Client)(nil)
+)
@@ -0,0 +1,394 @@
+// Package arb detects arbitrage opportunities in consolidated order books. A
+// CrossScanner finds crossed and locked markets between venues of one pair;
+// a TriangleScanner finds profitable cycles through three pairs. Both size
+// opportunities from book depth, net of taker fees.
+package arb
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+const epsilon = 1e-12
+
+var ErrNoPairs = errors.New("arb: no pairs configured")
+
+// Type Pair is a market: Asset priced in BaseAsset.
+type Pair struct {
+	Asset     string
+	BaseAsset string
+}
+
+// Function String formats the pair as asset/base.
+func (p Pair) String() string {
+	return p.Asset + "/" + p.BaseAsset
+}
+
+// Type Opportunity is a crossed or locked market between two venues: buying
+// Quantity on BuyVenue and selling it on SellVenue. BuyPrice and SellPrice are
+// the worst prices taken, to be used as the legs' limits. Cost, Proceeds and
+// Profit are in the base asset, net of taker fees.
+type Opportunity struct {
+	Pair      Pair
+	BuyVenue  string
+	SellVenue string
+	BestOffer float64 // At BuyVenue
+	BestBid   float64 // At SellVenue
+	Locked    bool    // BestBid equals BestOffer
+	BuyPrice  float64
+	SellPrice float64
+	Quantity  float64
+	Cost      float64
+	Proceeds  float64
+	Profit    float64
+
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Scans     int // Consecutive scans the market has been crossed or locked
+}
+
+// Function Duration returns how long the opportunity has persisted.
+func (o *Opportunity) Duration() time.Duration {
+	return o.LastSeen.Sub(o.FirstSeen)
+}
+
+// Function ProfitBps returns the profit in basis points of the cost.
+func (o *Opportunity) ProfitBps() float64 {
+	if o.Cost <= 0 {
+		return 0
+	}
+	return o.Profit / o.Cost * 10000
+}
+
+func (o *Opportunity) key() string {
+	return o.Pair.String() + ":" + o.BuyVenue + ">" + o.SellVenue
+}
+
+// Type CrossScanner scans consolidated books for crossed and locked markets
+// between venues.
+type CrossScanner struct {
+	api    routefire.DMA
+	userId string
+	pairs  []Pair
+
+	lock   sync.Mutex
+	active map[string]*Opportunity
+	closed []*Opportunity
+
+	// Fees are the taker fees charged on both legs. Nil is fee-free.
+	Fees *fees.Schedule
+	// MinProfit is the net profit, in the base asset, an opportunity must
+	// exceed to be handed to OnOpportunity.
+	MinProfit float64
+	// MaxQuantity, if positive, caps each opportunity's quantity.
+	MaxQuantity float64
+	// CheckBalances caps each opportunity at what the venues' balances can
+	// fund, from BalanceDMA: the base asset at the buy venue and the asset at
+	// the sell venue.
+	CheckBalances bool
+	// KeepClosed is how many closed opportunities are kept. Defaults to 1000.
+	KeepClosed int
+	// OnOpportunity, if set, is called on every scan for each opportunity
+	// whose profit exceeds MinProfit. It may call Execute.
+	OnOpportunity func(*Opportunity)
+	// OnClose, if set, is called when a crossed or locked market uncrosses.
+	OnClose func(*Opportunity)
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Function NewCrossScanner creates a scanner over the given pairs.
+func NewCrossScanner(api routefire.DMA, userId string, pairs ...Pair) *CrossScanner {
+	return &CrossScanner{
+		api:        api,
+		userId:     userId,
+		pairs:      pairs,
+		active:     map[string]*Opportunity{},
+		KeepClosed: 1000,
+		Now:        time.Now,
+	}
+}
+
+// Function Scan fetches the book of every pair and returns the crossed and
+// locked markets, most profitable first. Markets that have uncrossed since
+// the last scan are closed. It returns the first error encountered fetching
+// books; the other pairs are still scanned.
+func (s *CrossScanner) Scan() ([]*Opportunity, error) {
+	if len(s.pairs) == 0 {
+		return nil, ErrNoPairs
+	}
+	now := s.Now()
+	var found []*Opportunity
+	var firstErr error
+	for _, p := range s.pairs {
+		ob, err := s.api.GetConsolidatedOrderBookDMA(s.userId, p.Asset, p.BaseAsset)
+		if err == nil {
+			err = routefire.FirstDmaError(ob.Errors)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		found = append(found, s.detect(p, &ob.Data)...)
+	}
+
+	out := s.track(found, now)
+	if s.OnOpportunity != nil {
+		for _, o := range out {
+			if o.Profit > s.MinProfit {
+				s.OnOpportunity(o)
+			}
+		}
+	}
+	return out, firstErr
+}
+
+// Function Run scans every interval until the clock stops.
+func (s *CrossScanner) Run(clock strategy.Clock, interval time.Duration) {
+	for {
+		s.Scan()
+		if !clock.Wait(interval) {
+			return
+		}
+	}
+}
+
+// Function Active returns the markets crossed or locked at the last scan.
+func (s *CrossScanner) Active() []*Opportunity {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	out := make([]*Opportunity, 0, len(s.active))
+	for _, o := range s.active {
+		out = append(out, o)
+	}
+	sortOpportunities(out)
+	return out
+}
+
+// Function Closed returns the opportunities that have closed, oldest first.
+// Their Duration is how long they persisted.
+func (s *CrossScanner) Closed() []*Opportunity {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*Opportunity(nil), s.closed...)
+}
+
+// track merges the opportunities found by a scan with those already active,
+// keeping when each was first seen, and closes those no longer found.
+func (s *CrossScanner) track(found []*Opportunity, now time.Time) []*Opportunity {
+	s.lock.Lock()
+	seen := map[string]bool{}
+	out := make([]*Opportunity, 0, len(found))
+	for _, o := range found {
+		k := o.key()
+		seen[k] = true
+		o.FirstSeen, o.LastSeen, o.Scans = now, now, 1
+		if prev, ok := s.active[k]; ok {
+			o.FirstSeen, o.Scans = prev.FirstSeen, prev.Scans+1
+		}
+		s.active[k] = o
+		out = append(out, o)
+	}
+	var closed []*Opportunity
+	for k, o := range s.active {
+		if !seen[k] {
+			delete(s.active, k)
+			closed = append(closed, o)
+		}
+	}
+	sortOpportunities(closed)
+	s.closed = append(s.closed, closed...)
+	if n := len(s.closed) - s.KeepClosed; s.KeepClosed > 0 && n > 0 {
+		s.closed = append([]*Opportunity(nil), s.closed[n:]...)
+	}
+	s.lock.Unlock()
+
+	if s.OnClose != nil {
+		for _, o := range closed {
+			s.OnClose(o)
+		}
+	}
+	sortOpportunities(out)
+	return out
+}
+
+func sortOpportunities(os []*Opportunity) {
+	sort.SliceStable(os, func(i, j int) bool {
+		if os[i].Profit != os[j].Profit {
+			return os[i].Profit > os[j].Profit
+		}
+		return os[i].key() < os[j].key()
+	})
+}
+
+// venueLevel is a book level at one venue.
+type venueLevel struct {
+	price float64
+	qty   float64
+}
+
+// byVenue splits the side of a book an order takes from into levels per venue,
+// best first.
+func byVenue(ob *routefire.DmaOrderBook, side string) map[string][]venueLevel {
+	out := map[string][]venueLevel{}
+	for _, e := range ob.SweepLevels(side) {
+		px, qty, err := e.Floats()
+		if err != nil || qty <= 0 {
+			continue
+		}
+		v := strings.ToUpper(e.Venue)
+		out[v] = append(out[v], venueLevel{px, qty})
+	}
+	return out
+}
+
+// detect finds the crossed and locked markets in a book, for every pair of
+// venues where one's best offer is at or below the other's best bid.
+func (s *CrossScanner) detect(p Pair, ob *routefire.DmaOrderBook) []*Opportunity {
+	offers := byVenue(ob, routefire.SideBuy)
+	bids := byVenue(ob, routefire.SideSell)
+	var out []*Opportunity
+	for buyVenue, asks := range offers {
+		for sellVenue, bs := range bids {
+			if buyVenue == sellVenue || asks[0].price > bs[0].price {
+				continue
+			}
+			o := &Opportunity{
+				Pair:      p,
+				BuyVenue:  buyVenue,
+				SellVenue: sellVenue,
+				BestOffer: asks[0].price,
+				BestBid:   bs[0].price,
+				Locked:    asks[0].price == bs[0].price,
+			}
+			maxQty, maxCost := s.MaxQuantity, 0.0
+			if s.CheckBalances {
+				base, asset, err := s.balances(p, buyVenue, sellVenue)
+				if err == nil {
+					maxCost = base
+					if maxQty <= 0 || asset < maxQty {
+						maxQty = asset
+					}
+				}
+				if err != nil || maxCost <= epsilon || maxQty <= epsilon {
+					out = append(out, o)
+					continue
+				}
+			}
+			s.match(o, asks, bs, maxQty, maxCost)
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// match walks the buy venue's offers and the sell venue's bids together while
+// selling is worth more than buying after fees, within maxQty and a maxCost in
+// the base asset, where positive.
+func (s *CrossScanner) match(o *Opportunity, asks, bids []venueLevel, maxQty, maxCost float64) {
+	asks = append([]venueLevel(nil), asks...)
+	bids = append([]venueLevel(nil), bids...)
+	i, j := 0, 0
+	for i < len(asks) && j < len(bids) {
+		buyNet := s.Fees.NetPrice(o.BuyVenue, routefire.SideBuy, asks[i].price)
+		sellNet := s.Fees.NetPrice(o.SellVenue, routefire.SideSell, bids[j].price)
+		if sellNet <= buyNet {
+			return
+		}
+		qty := asks[i].qty
+		if bids[j].qty < qty {
+			qty = bids[j].qty
+		}
+		if maxQty > 0 && o.Quantity+qty > maxQty {
+			qty = maxQty - o.Quantity
+		}
+		if maxCost > 0 && o.Cost+qty*buyNet > maxCost {
+			qty = (maxCost - o.Cost) / buyNet
+		}
+		if qty <= epsilon {
+			return
+		}
+		o.Quantity += qty
+		o.Cost += qty * buyNet
+		o.Proceeds += qty * sellNet
+		o.Profit = o.Proceeds - o.Cost
+		o.BuyPrice, o.SellPrice = asks[i].price, bids[j].price
+		asks[i].qty -= qty
+		bids[j].qty -= qty
+		if asks[i].qty <= epsilon {
+			i++
+		}
+		if bids[j].qty <= epsilon {
+			j++
+		}
+	}
+}
+
+// balances returns the base asset balance at the buy venue and the asset
+// balance at the sell venue.
+func (s *CrossScanner) balances(p Pair, buyVenue, sellVenue string) (base, asset float64, err error) {
+	if base, err = balance(s.api, s.userId, buyVenue, p.BaseAsset); err != nil {
+		return 0, 0, err
+	}
+	asset, err = balance(s.api, s.userId, sellVenue, p.Asset)
+	return base, asset, err
+}
+
+func balance(api routefire.DMA, userId, venue, asset string) (float64, error) {
+	b, err := api.BalanceDMA(userId, venue, asset)
+	if err == nil {
+		err = routefire.FirstDmaError(b.Errors)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if b.Amount == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(b.Amount, 64)
+}
+
+// Function Execute submits both legs of an opportunity concurrently as DMA
+// limit orders at BuyPrice and SellPrice, for Quantity rounded down to the
+// asset's precision. If either leg fails, the other is left working and the
+// first error is returned.
+func (s *CrossScanner) Execute(o *Opportunity) (buy, sell *routefire.PlaceDmaOrderResponse, err error) {
+	if routefire.RoundQuantity(o.Pair.Asset, o.Quantity) <= 0 {
+		return nil, nil, errors.New("arb: opportunity has no executable quantity")
+	}
+	qty := routefire.FormatQuantity(o.Pair.Asset, o.Quantity)
+	var buyErr, sellErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		buy, buyErr = submit(s.api, s.userId, o.BuyVenue, o.Pair.Asset, o.Pair.BaseAsset, routefire.SideBuy, qty, o.BuyPrice)
+	}()
+	go func() {
+		defer wg.Done()
+		sell, sellErr = submit(s.api, s.userId, o.SellVenue, o.Pair.Asset, o.Pair.BaseAsset, routefire.SideSell, qty, o.SellPrice)
+	}()
+	wg.Wait()
+	if buyErr != nil {
+		return buy, sell, buyErr
+	}
+	return buy, sell, sellErr
+}
+
+func submit(api routefire.DMA, userId, venue, asset, baseAsset, side, qty string, price float64) (*routefire.PlaceDmaOrderResponse, error) {
+	resp, err := api.SubmitOrderDMA(userId, venue, asset, baseAsset, side, qty, routefire.FormatFloat(price), nil)
+	if err == nil {
+		err = routefire.FirstDmaError(resp.Errors)
+	}
+	return resp, err
+}
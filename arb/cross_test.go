@@ -0,0 +1,151 @@
+package arb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/fees"
+)
+
+const uid = "arb@example.com"
+
+var btcUsd = Pair{routefire.Btc, routefire.Usd}
+
+var testFees = &fees.Schedule{Venues: map[string][]fees.Tier{
+	routefire.Gemini: {{Taker: 0.002}},
+	routefire.Kraken: {{Taker: 0.002}},
+}}
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+// crossedBook has Gemini's best offer at 100 and Kraken's best bid at
+// krakenBid.
+func crossedBook(i int, krakenBid string) backtest.Snapshot {
+	return backtest.Snapshot{
+		Time:      time.Date(2019, 6, 1, 12, 0, i, 0, time.UTC),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids: []routefire.DmaOrderBookEntry{
+				{Venue: routefire.Gemini, Price: "99", Amount: "5"},
+				{Venue: routefire.Kraken, Price: "99.8", Amount: "1"},
+				{Venue: routefire.Kraken, Price: krakenBid, Amount: "2"},
+			},
+			Offers: []routefire.DmaOrderBookEntry{
+				{Venue: routefire.Gemini, Price: "100", Amount: "1.5"},
+				{Venue: routefire.Kraken, Price: "102", Amount: "5"},
+				{Venue: routefire.Gemini, Price: "100.6", Amount: "5"},
+			},
+		},
+	}
+}
+
+func TestCrossedMarkets(t *testing.T) {
+	bt := backtest.New([]backtest.Snapshot{crossedBook(0, "101"), crossedBook(1, "101"), crossedBook(2, "99.5")}, backtest.Config{})
+	bt.Step()
+	s := NewCrossScanner(bt, uid, btcUsd)
+	s.Fees = testFees
+	s.Now = bt.Now
+	var handled, closed []*Opportunity
+	s.OnOpportunity = func(o *Opportunity) { handled = append(handled, o) }
+	s.OnClose = func(o *Opportunity) { closed = append(closed, o) }
+
+	ops, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].BuyVenue != routefire.Gemini || ops[0].SellVenue != routefire.Kraken {
+		t.Fatalf("expected Gemini to Kraken, got %+v", ops)
+	}
+	o := ops[0]
+	// 1.5 bought at 100 and sold at 101 takes 1.5 of Kraken's 2; the next
+	// 0.5 at 100.6 against 101 is not worth the fees.
+	approx(t, "quantity", o.Quantity, 1.5)
+	approx(t, "profit", o.Profit, 1.5*(101*0.998-100*1.002))
+	if o.BuyPrice != 100 || o.SellPrice != 101 || o.Locked {
+		t.Errorf("unexpected prices %+v", o)
+	}
+
+	bt.Wait(time.Second)
+	s.Scan()
+	bt.Wait(time.Second)
+	if ops, _ := s.Scan(); len(ops) != 0 {
+		t.Errorf("the market should have uncrossed, got %+v", ops)
+	}
+	if len(handled) != 2 || len(closed) != 1 || closed[0].Duration() != time.Second || closed[0].Scans != 2 {
+		t.Errorf("expected one opportunity lasting a second, got %+v", closed)
+	}
+	if len(s.Active()) != 0 || len(s.Closed()) != 1 {
+		t.Errorf("unexpected tracking state")
+	}
+}
+
+func TestCrossBalancesAndExecute(t *testing.T) {
+	bt := backtest.New([]backtest.Snapshot{crossedBook(0, "101")}, backtest.Config{Balances: map[string]map[string]float64{
+		routefire.Gemini: {routefire.Usd: 100.2 * 0.5},
+		routefire.Kraken: {routefire.Btc: 5},
+	}})
+	bt.Step()
+	s := NewCrossScanner(bt, uid, btcUsd)
+	s.Fees = testFees
+	s.CheckBalances = true
+	ops, err := s.Scan()
+	if err != nil || len(ops) != 1 {
+		t.Fatalf("expected one opportunity, got %+v %v", ops, err)
+	}
+	// Gemini's usd funds half a btc after fees.
+	approx(t, "quantity", ops[0].Quantity, 0.5)
+
+	buy, sell, err := s.Execute(ops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leg := range []*routefire.PlaceDmaOrderResponse{buy, sell} {
+		st, _ := bt.OrderStatusDMA(uid, leg.VenueId, leg.VenueOrderId)
+		if st.Status != routefire.StatusFilled {
+			t.Errorf("expected %s leg to fill, got %+v", leg.VenueId, st)
+		}
+	}
+}
+
+func TestExecuteRoundsQuantity(t *testing.T) {
+	bt := backtest.New([]backtest.Snapshot{crossedBook(0, "101")}, backtest.Config{})
+	bt.Step()
+	s := NewCrossScanner(bt, uid, btcUsd)
+	ops, err := s.Scan()
+	if err != nil || len(ops) != 1 {
+		t.Fatalf("expected one opportunity, got %+v %v", ops, err)
+	}
+	ops[0].Quantity = 1.0 / 3
+	buy, sell, err := s.Execute(ops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leg := range []*routefire.PlaceDmaOrderResponse{buy, sell} {
+		if o, _ := bt.Exchange().Order(leg.VenueId, leg.VenueOrderId); o.Quantity != 0.33333333 {
+			t.Errorf("expected %s leg for 0.33333333, got %v", leg.VenueId, o.Quantity)
+		}
+	}
+
+	ops[0].Quantity = 1e-9
+	if _, _, err := s.Execute(ops[0]); err == nil {
+		t.Errorf("a quantity below btc's precision should not be executed")
+	}
+}
+
+func TestLockedMarket(t *testing.T) {
+	bt := backtest.New([]backtest.Snapshot{crossedBook(0, "100")}, backtest.Config{})
+	bt.Step()
+	ops, _ := NewCrossScanner(bt, uid, btcUsd).Scan()
+	if len(ops) != 1 || !ops[0].Locked || ops[0].Quantity != 0 || ops[0].Profit != 0 {
+		t.Errorf("expected a locked market with nothing to take, got %+v", ops)
+	}
+}
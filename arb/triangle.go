@@ -0,0 +1,389 @@
+package arb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
+	"github.com/routefire/go-routefire/router"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+// Type Leg is one conversion of a cycle: From is converted into To by trading
+// Quantity of Pair.Asset on Side. Price is the worst book price taken, to be
+// used as the leg's limit. Amounts are net of taker fees.
+type Leg struct {
+	Pair      Pair
+	Side      string
+	From      string
+	To        string
+	AmountIn  float64
+	AmountOut float64
+	Quantity  float64
+	Price     float64
+}
+
+// Type Cycle is a triangular arbitrage: Start of Assets[0] is converted through
+// Assets[1] and Assets[2] back into End of Assets[0]. Rate is the marginal
+// rate of the cycle at the top of the books; a cycle is profitable when it is
+// above 1.
+type Cycle struct {
+	Assets    [3]string
+	Legs      [3]Leg
+	Rate      float64
+	Start     float64
+	End       float64
+	Profit    float64 // End less Start, in Assets[0]
+	ProfitBps float64
+	Time      time.Time
+}
+
+// Function String formats the cycle as a path of assets.
+func (c *Cycle) String() string {
+	return strings.Join([]string{c.Assets[0], c.Assets[1], c.Assets[2], c.Assets[0]}, "->")
+}
+
+// edge is a conversion from one asset to another through a pair's book.
+type edge struct {
+	pair   Pair
+	side   string
+	from   string
+	to     string
+	levels []level
+}
+
+// level is a book level with the taker fee of its venue.
+type level struct {
+	price float64
+	qty   float64
+	net   float64 // Price after the taker fee
+}
+
+// convert takes amount of e.from through the levels and returns the amount of
+// e.to received, the quantity of the pair's asset traded and the worst price
+// taken. Input beyond the book's depth is not converted.
+func (e *edge) convert(amount float64) (out, qty, worst float64) {
+	for _, l := range e.levels {
+		if amount <= epsilon {
+			break
+		}
+		take := l.qty
+		if e.side == routefire.SideBuy {
+			// Spend base asset at the net price for units of the asset.
+			if amount < take*l.net {
+				take = amount / l.net
+			}
+			amount -= take * l.net
+			out += take
+		} else {
+			if amount < take {
+				take = amount
+			}
+			amount -= take
+			out += take * l.net
+		}
+		qty += take
+		worst = l.price
+	}
+	return out, qty, worst
+}
+
+// capacity is the most of e.from the levels can convert.
+func (e *edge) capacity() float64 {
+	total := 0.0
+	for _, l := range e.levels {
+		if e.side == routefire.SideBuy {
+			total += l.qty * l.net
+		} else {
+			total += l.qty
+		}
+	}
+	return total
+}
+
+// rate is the conversion rate at the top of the book.
+func (e *edge) rate() float64 {
+	if len(e.levels) == 0 {
+		return 0
+	}
+	if e.side == routefire.SideBuy {
+		return 1 / e.levels[0].net
+	}
+	return e.levels[0].net
+}
+
+// Type TriangleScanner evaluates every triangular cycle through its pairs'
+// consolidated books, sizing each from book depth net of taker fees.
+type TriangleScanner struct {
+	api    routefire.DMA
+	userId string
+	pairs  []Pair
+
+	// Fees are the taker fees charged on each leg. Nil is fee-free.
+	Fees *fees.Schedule
+	// MinProfitBps is the profit, in basis points of the starting amount, a
+	// cycle must exceed to be reported.
+	MinProfitBps float64
+	// MaxStart, by asset, caps the starting amount of cycles from that asset.
+	MaxStart map[string]float64
+	// StartAssets, if set, are the assets cycles start from, with every
+	// rotation of a cycle through them reported. Otherwise each cycle is
+	// reported once, starting from its alphabetically first asset.
+	StartAssets []string
+	// OnOpportunity, if set, is called for each cycle found by a scan. It may
+	// call Execute.
+	OnOpportunity func(*Cycle)
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+	// LegTimeout is how long Execute waits for a leg to finish before
+	// cancelling what remains of it. Defaults to ten seconds.
+	LegTimeout time.Duration
+	// LegInterval is the time between status requests while waiting.
+	// Defaults to one second.
+	LegInterval time.Duration
+	// Clock paces the wait. If nil the system clock is used.
+	Clock strategy.Clock
+}
+
+// Function NewTriangleScanner creates a scanner over the given pairs.
+func NewTriangleScanner(api routefire.DMA, userId string, pairs ...Pair) *TriangleScanner {
+	return &TriangleScanner{api: api, userId: userId, pairs: pairs, Now: time.Now,
+		LegTimeout: 10 * time.Second, LegInterval: time.Second}
+}
+
+// Function Scan fetches the book of every pair concurrently and returns the
+// cycles above MinProfitBps, most profitable first. Pairs whose books cannot
+// be fetched are left out, and the first error is returned.
+func (s *TriangleScanner) Scan() ([]*Cycle, error) {
+	if len(s.pairs) == 0 {
+		return nil, ErrNoPairs
+	}
+	graph, err := s.graph()
+	now := s.Now()
+
+	starts := map[string]bool{}
+	for _, a := range s.StartAssets {
+		starts[strings.ToLower(a)] = true
+	}
+	var out []*Cycle
+	for a, fromA := range graph {
+		if len(starts) > 0 && !starts[a] {
+			continue
+		}
+		for b, ab := range fromA {
+			for c, bc := range graph[b] {
+				ca, ok := graph[c][a]
+				if !ok || c == a || c == b {
+					continue
+				}
+				if len(starts) == 0 && (b < a || c < a) {
+					continue
+				}
+				cycle := s.evaluate([3]*edge{ab, bc, ca})
+				if cycle != nil && cycle.ProfitBps > s.MinProfitBps {
+					cycle.Time = now
+					out = append(out, cycle)
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ProfitBps != out[j].ProfitBps {
+			return out[i].ProfitBps > out[j].ProfitBps
+		}
+		return out[i].String() < out[j].String()
+	})
+	if s.OnOpportunity != nil {
+		for _, c := range out {
+			s.OnOpportunity(c)
+		}
+	}
+	return out, err
+}
+
+// Function Run scans every interval until the clock stops.
+func (s *TriangleScanner) Run(clock strategy.Clock, interval time.Duration) {
+	for {
+		s.Scan()
+		if !clock.Wait(interval) {
+			return
+		}
+	}
+}
+
+// graph fetches the books and builds the conversions between assets: buying a
+// pair's asset with its base from the offers, and selling it from the bids.
+func (s *TriangleScanner) graph() (map[string]map[string]*edge, error) {
+	books := make([]*routefire.DmaOrderBook, len(s.pairs))
+	errs := make([]error, len(s.pairs))
+	var wg sync.WaitGroup
+	for i, p := range s.pairs {
+		wg.Add(1)
+		go func(i int, p Pair) {
+			defer wg.Done()
+			ob, err := s.api.GetConsolidatedOrderBookDMA(s.userId, p.Asset, p.BaseAsset)
+			if err == nil {
+				err = routefire.FirstDmaError(ob.Errors)
+			}
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			books[i] = &ob.Data
+		}(i, p)
+	}
+	wg.Wait()
+
+	graph := map[string]map[string]*edge{}
+	add := func(e *edge) {
+		if len(e.levels) == 0 {
+			return
+		}
+		if graph[e.from] == nil {
+			graph[e.from] = map[string]*edge{}
+		}
+		graph[e.from][e.to] = e
+	}
+	var firstErr error
+	for i, p := range s.pairs {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		asset, base := strings.ToLower(p.Asset), strings.ToLower(p.BaseAsset)
+		add(&edge{pair: p, side: routefire.SideBuy, from: base, to: asset, levels: s.levels(books[i], routefire.SideBuy)})
+		add(&edge{pair: p, side: routefire.SideSell, from: asset, to: base, levels: s.levels(books[i], routefire.SideSell)})
+	}
+	return graph, firstErr
+}
+
+// levels returns the levels an order on side takes from, best net price first.
+func (s *TriangleScanner) levels(ob *routefire.DmaOrderBook, side string) []level {
+	var out []level
+	for _, e := range ob.SweepLevels(side) {
+		px, qty, err := e.Floats()
+		if err != nil || px <= 0 || qty <= 0 {
+			continue
+		}
+		out = append(out, level{px, qty, s.Fees.NetPrice(e.Venue, side, px)})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if side == routefire.SideSell {
+			return out[i].net > out[j].net
+		}
+		return out[i].net < out[j].net
+	})
+	return out
+}
+
+// run converts start through the edges, returning the amount received.
+func run(edges [3]*edge, start float64) float64 {
+	amount := start
+	for _, e := range edges {
+		amount, _, _ = e.convert(amount)
+	}
+	return amount
+}
+
+// evaluate sizes a cycle. The profit of a cycle is a concave function of its
+// starting amount, since each leg's rate worsens with size, so its maximum is
+// found by ternary search up to the first leg's depth or MaxStart. It returns
+// nil for cycles that lose money even at the top of the books.
+func (s *TriangleScanner) evaluate(edges [3]*edge) *Cycle {
+	rate := edges[0].rate() * edges[1].rate() * edges[2].rate()
+	if rate <= 1 {
+		return nil
+	}
+	from := edges[0].from
+	hi := edges[0].capacity()
+	if max, ok := s.MaxStart[from]; ok && max > 0 && max < hi {
+		hi = max
+	}
+	lo := 0.0
+	for i := 0; i < 100 && hi-lo > epsilon*(1+hi); i++ {
+		m1 := lo + (hi-lo)/3
+		m2 := hi - (hi-lo)/3
+		if run(edges, m1)-m1 < run(edges, m2)-m2 {
+			lo = m1
+		} else {
+			hi = m2
+		}
+	}
+	// The maximum stays within [lo, hi]; starting from lo never takes a level
+	// past it.
+	start := lo
+	if start <= epsilon {
+		return nil
+	}
+
+	c := &Cycle{Rate: rate, Start: start}
+	amount := start
+	for i, e := range edges {
+		out, qty, worst := e.convert(amount)
+		c.Assets[i] = e.from
+		c.Legs[i] = Leg{Pair: e.pair, Side: e.side, From: e.from, To: e.to, AmountIn: amount, AmountOut: out, Quantity: qty, Price: worst}
+		amount = out
+	}
+	c.End = amount
+	c.Profit = c.End - c.Start
+	c.ProfitBps = c.Profit / c.Start * 10000
+	return c
+}
+
+// Function Execute trades the legs of a cycle in turn, each routed across
+// venues as DMA orders limited at the leg's worst price. Each leg is waited for
+// up to LegTimeout, and what remains of it is then cancelled; the next leg
+// trades what the previous one filled, scaled from the plan. It stops at the
+// first leg that fails or fills nothing, and returns the routed orders placed.
+func (s *TriangleScanner) Execute(c *Cycle, configure func(*router.Router)) ([]*router.Parent, error) {
+	r := router.New(s.api, s.userId)
+	if configure != nil {
+		configure(r)
+	}
+	var parents []*router.Parent
+	scale := 1.0
+	for _, leg := range c.Legs {
+		parent, err := r.Route(leg.Pair.Asset, leg.Pair.BaseAsset, leg.Side, leg.Quantity*scale, leg.Price)
+		if err != nil {
+			return parents, err
+		}
+		parents = append(parents, parent)
+		st, err := s.settle(parent)
+		if err != nil {
+			return parents, err
+		}
+		if st.Filled <= epsilon {
+			return parents, errors.New("arb: " + leg.From + "->" + leg.To + " leg of " + c.String() + " did not fill")
+		}
+		scale = st.Filled / leg.Quantity
+	}
+	return parents, nil
+}
+
+// settle waits, up to LegTimeout, for a leg to stop working, then cancels any
+// remainder and returns the leg's final status.
+func (s *TriangleScanner) settle(parent *router.Parent) (router.Status, error) {
+	clock := s.Clock
+	if clock == nil {
+		clock = strategy.RealClock(nil)
+	}
+	deadline := clock.Now().Add(s.LegTimeout)
+	st := parent.Refresh()
+	for st.Open > 0 && clock.Now().Before(deadline) && clock.Wait(s.LegInterval) {
+		st = parent.Refresh()
+	}
+	if st.Open == 0 {
+		return st, nil
+	}
+	if err := parent.Cancel(); err != nil {
+		return parent.Refresh(), err
+	}
+	// Pick up fills that raced the cancels.
+	return parent.Refresh(), nil
+}
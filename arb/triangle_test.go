@@ -0,0 +1,223 @@
+package arb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/fees"
+)
+
+func book(asset, base string, bids, offers []routefire.DmaOrderBookEntry) backtest.Snapshot {
+	return backtest.Snapshot{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     asset,
+		BaseAsset: base,
+		Book:      routefire.DmaOrderBook{Bids: bids, Offers: offers},
+	}
+}
+
+func entry(venue, price, amount string) routefire.DmaOrderBookEntry {
+	return routefire.DmaOrderBookEntry{Venue: venue, Price: price, Amount: amount}
+}
+
+// triangleEngine prices eth at 200 usd through btc, but bids 205 for 5 eth
+// directly.
+func triangleEngine() *backtest.Engine {
+	bt := backtest.New([]backtest.Snapshot{
+		book(routefire.Btc, routefire.Usd,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "9990", "10")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "10000", "10")}),
+		book(routefire.Eth, routefire.Btc,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "0.0199", "100")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "0.02", "100")}),
+		book(routefire.Eth, routefire.Usd,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "195", "10"), entry(routefire.Kraken, "205", "5")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "206", "10")}),
+	}, backtest.Config{})
+	bt.Step()
+	bt.Step()
+	bt.Step()
+	return bt
+}
+
+var trianglePairs = []Pair{
+	{routefire.Btc, routefire.Usd},
+	{routefire.Eth, routefire.Btc},
+	{routefire.Eth, routefire.Usd},
+}
+
+func TestTriangleDepthSizing(t *testing.T) {
+	bt := triangleEngine()
+	s := NewTriangleScanner(bt, uid, trianglePairs...)
+	s.StartAssets = []string{routefire.Usd}
+	cycles, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cycles) != 1 || cycles[0].String() != "usd->btc->eth->usd" {
+		t.Fatalf("expected one cycle, got %v", cycles)
+	}
+	c := cycles[0]
+	// Only the 5 eth bid at 205 is profitable: start with 1000 usd.
+	approx(t, "start", c.Start, 1000)
+	approx(t, "profit", c.Profit, 25)
+	approx(t, "rate", c.Rate, 1.025)
+	if l := c.Legs[2]; l.Side != routefire.SideSell || l.Price != 205 || l.Quantity < 5-1e-6 {
+		t.Errorf("unexpected last leg %+v", l)
+	}
+
+	s.MaxStart = map[string]float64{routefire.Usd: 500}
+	cycles, _ = s.Scan()
+	approx(t, "capped profit", cycles[0].Profit, 12.5)
+
+	parents, err := s.Execute(cycles[0], nil)
+	if err != nil || len(parents) != 3 {
+		t.Fatalf("expected three legs, got %d: %v", len(parents), err)
+	}
+	st := parents[2].Status()
+	if st.Status != routefire.StatusFilled {
+		t.Errorf("unexpected last leg %+v", st)
+	}
+	approx(t, "last leg price", st.AvgPrice, 205)
+	// Each leg is rounded down to its asset's precision.
+	if st.Filled > 2.5 || st.Filled < 2.5-1e-5 {
+		t.Errorf("last leg filled: got %f, want 2.5", st.Filled)
+	}
+}
+
+// slowVenue reports each order unfilled for its first lag status requests, and
+// orders of the held asset as never filling.
+type slowVenue struct {
+	*backtest.Engine
+	lag  int
+	hold string
+
+	lock      sync.Mutex
+	polls     map[string]int
+	held      map[string]bool
+	cancelled map[string]bool
+}
+
+func newSlowVenue(bt *backtest.Engine, lag int, hold string) *slowVenue {
+	return &slowVenue{Engine: bt, lag: lag, hold: hold,
+		polls: map[string]int{}, held: map[string]bool{}, cancelled: map[string]bool{}}
+}
+
+func (v *slowVenue) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	resp, err := v.Engine.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	if err == nil && asset == v.hold {
+		v.lock.Lock()
+		v.held[venue+":"+resp.VenueOrderId] = true
+		v.lock.Unlock()
+	}
+	return resp, err
+}
+
+func (v *slowVenue) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	key := venue + ":" + venueOrdId
+	v.lock.Lock()
+	n := v.polls[key]
+	v.polls[key]++
+	held, cancelled := v.held[key], v.cancelled[key]
+	v.lock.Unlock()
+	switch {
+	case cancelled:
+		return &routefire.DmaOrderStatusResponse{Status: routefire.StatusCancelled, FilledAmount: "0"}, nil
+	case held || n < v.lag:
+		return &routefire.DmaOrderStatusResponse{Status: routefire.StatusOpen, FilledAmount: "0"}, nil
+	}
+	return v.Engine.OrderStatusDMA(userId, venue, venueOrdId)
+}
+
+func (v *slowVenue) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	key := venue + ":" + venueOrdId
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !v.held[key] {
+		return v.Engine.CancelOrderDMA(userId, venue, venueOrdId)
+	}
+	v.cancelled[key] = true
+	return &routefire.CancelDmaOrderResponse{VenueId: venue, VenueOrderId: venueOrdId}, nil
+}
+
+// stepClock advances by the time waited.
+type stepClock struct {
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time { return c.now }
+
+func (c *stepClock) Wait(d time.Duration) bool {
+	c.now = c.now.Add(d)
+	return true
+}
+
+func TestTriangleWaitsForLegs(t *testing.T) {
+	bt := triangleEngine()
+	s := NewTriangleScanner(newSlowVenue(bt, 3, ""), uid, trianglePairs...)
+	s.StartAssets = []string{routefire.Usd}
+	s.MaxStart = map[string]float64{routefire.Usd: 500}
+	clock := &stepClock{now: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)}
+	s.Clock = clock
+	cycles, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := s.Execute(cycles[0], nil)
+	if err != nil || len(parents) != 3 {
+		t.Fatalf("expected three legs, got %d: %v", len(parents), err)
+	}
+	if st := parents[2].Status(); st.Status != routefire.StatusFilled {
+		t.Errorf("unexpected last leg %+v", st)
+	}
+	if waited := clock.now.Sub(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)); waited != 9*time.Second {
+		t.Errorf("expected each leg to be waited for, waited %s", waited)
+	}
+}
+
+func TestTriangleCancelsStuckLeg(t *testing.T) {
+	bt := triangleEngine()
+	v := newSlowVenue(bt, 0, routefire.Eth)
+	s := NewTriangleScanner(v, uid, trianglePairs...)
+	s.StartAssets = []string{routefire.Usd}
+	s.MaxStart = map[string]float64{routefire.Usd: 500}
+	s.Clock = &stepClock{now: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)}
+	cycles, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := s.Execute(cycles[0], nil)
+	if err == nil || len(parents) != 2 {
+		t.Fatalf("expected the cycle to stop at the second leg, got %d: %v", len(parents), err)
+	}
+	for _, c := range parents[1].Children {
+		if !v.cancelled[c.Venue+":"+c.OrderId] {
+			t.Errorf("the stuck leg should be cancelled, got %+v", c)
+		}
+	}
+	if st := parents[1].Status(); st.Open != 0 {
+		t.Errorf("no child of the stuck leg should be working, got %+v", st)
+	}
+}
+
+func TestTriangleFeesAndRotations(t *testing.T) {
+	bt := triangleEngine()
+	s := NewTriangleScanner(bt, uid, trianglePairs...)
+	cycles, _ := s.Scan()
+	// Reported once, from btc.
+	if len(cycles) != 1 || cycles[0].String() != "btc->eth->usd->btc" {
+		t.Fatalf("expected one rotation, got %v", cycles)
+	}
+
+	// A 1% fee on each leg outweighs the 2.5% edge.
+	s.Fees = &fees.Schedule{Venues: map[string][]fees.Tier{
+		routefire.Gemini: {{Taker: 0.01}},
+		routefire.Kraken: {{Taker: 0.01}},
+	}}
+	if cycles, _ := s.Scan(); len(cycles) != 0 {
+		t.Errorf("expected no cycle after fees, got %v", cycles)
+	}
+}
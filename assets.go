@@ -0,0 +1,95 @@
+package routefire
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Function IsFiat reports whether an asset is a fiat currency.
+func IsFiat(asset string) bool {
+	switch strings.ToLower(asset) {
+	case Usd, Eur, Gbp:
+		return true
+	}
+	return false
+}
+
+// Stablecoins are the usd-pegged stablecoins.
+var Stablecoins = []string{Usdt, Usdc, Tusd, Gusd, Dai, Pax}
+
+// Function IsStablecoin reports whether an asset is a usd-pegged stablecoin.
+func IsStablecoin(asset string) bool {
+	asset = strings.ToLower(asset)
+	for _, s := range Stablecoins {
+		if asset == s {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteRank orders assets by how commonly they are used as the quote (base)
+// asset of a market: fiat, then stablecoins, then btc, then eth.
+func quoteRank(asset string) int {
+	switch {
+	case IsFiat(asset):
+		return 4
+	case IsStablecoin(asset):
+		return 3
+	case strings.ToLower(asset) == Btc:
+		return 2
+	case strings.ToLower(asset) == Eth:
+		return 1
+	}
+	return 0
+}
+
+// Function AlgoOrderPair maps the buy and sell assets of a Routefire (algorithm)
+// order onto a market and side: buying btc with usd is a BUY on btc/usd, while
+// buying usd with btc is a SELL on btc/usd.
+func AlgoOrderPair(buyAsset, sellAsset string) (asset, baseAsset, side string) {
+	if quoteRank(buyAsset) > quoteRank(sellAsset) {
+		return strings.ToLower(sellAsset), strings.ToLower(buyAsset), SideSell
+	}
+	return strings.ToLower(buyAsset), strings.ToLower(sellAsset), SideBuy
+}
+
+// AssetPrecision is the number of decimal places quantities of each asset are
+// submitted with by the notional order helpers. Venues' increments vary; these
+// are commonly accepted, and entries may be changed or added for an account's
+// venues. Assets not listed use DefaultPrecision.
+var AssetPrecision = map[string]int{
+	Usd: 2, Eur: 2, Gbp: 2,
+	Usdt: 6, Usdc: 6, Tusd: 6, Gusd: 2, Dai: 6, Pax: 6,
+	Btc: 8, Bch: 8, Eth: 8, Ltc: 8, Xrp: 6, Xlm: 7, Zrx: 8,
+}
+
+// DefaultPrecision is the precision of assets missing from AssetPrecision.
+const DefaultPrecision = 8
+
+// Function Precision returns the number of decimal places of asset quantities.
+func Precision(asset string) int {
+	if p, ok := AssetPrecision[strings.ToLower(asset)]; ok {
+		return p
+	}
+	return DefaultPrecision
+}
+
+// Function RoundQuantity rounds a quantity of asset down to its precision, so
+// that an order never exceeds the amount it was sized for.
+func RoundQuantity(asset string, quantity float64) float64 {
+	scale := math.Pow10(Precision(asset))
+	// Allow for binary representation error, so 0.29 is not rounded to 0.28.
+	return math.Floor(quantity*scale+1e-6) / scale
+}
+
+// Function FormatQuantity rounds a quantity of asset down to its precision and
+// formats it for submission.
+func FormatQuantity(asset string, quantity float64) string {
+	s := strconv.FormatFloat(RoundQuantity(asset, quantity), 'f', Precision(asset), 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
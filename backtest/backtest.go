@@ -0,0 +1,243 @@
+// Package backtest replays recorded consolidated order books through a
+// simulated clock and matches orders against them. Engine implements
+// routefire.DMA, so code written against the live Client runs unchanged.
+package backtest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+// Type Config holds backtest settings.
+type Config struct {
+	// Latency is the delay between submitting an order and it reaching the venue.
+	Latency time.Duration
+	// Fees is the fee model applied to fills, e.g. sim.FlatFees.
+	Fees sim.FeeModel
+	// Balances holds initial balances by venue then asset. If set, orders that
+	// cannot be funded from the venue's balance are rejected.
+	Balances map[string]map[string]float64
+	// QuoteAsset is the asset equity is measured in. Defaults to usd.
+	QuoteAsset string
+}
+
+// Type EquityPoint is the total value of all balances at a point in time.
+type EquityPoint struct {
+	Time   time.Time
+	Equity float64
+}
+
+// Type Report summarizes a backtest.
+type Report struct {
+	Start       time.Time
+	End         time.Time
+	Orders      []sim.Order
+	Trades      []sim.Fill
+	Positions   map[string]float64            // Net quantity bought less sold, per asset
+	Balances    map[string]map[string]float64 // Final balances by venue then asset
+	Fees        float64
+	Equity      []EquityPoint
+	StartEquity float64
+	EndEquity   float64
+}
+
+// Function PnL returns the change in equity over the backtest.
+func (r *Report) PnL() float64 {
+	return r.EndEquity - r.StartEquity
+}
+
+// Type Engine replays snapshots and simulates order matching against them.
+type Engine struct {
+	exch   *sim.Exchange
+	snaps  []Snapshot
+	quote  string
+	lock   sync.Mutex
+	next   int
+	now    time.Time
+	mids   map[string]float64
+	equity []EquityPoint
+}
+
+// Function New creates a backtest engine over the given snapshots.
+func New(snaps []Snapshot, cfg Config) *Engine {
+	sorted := append([]Snapshot(nil), snaps...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	x := sim.NewExchange()
+	x.Latency = cfg.Latency
+	x.Fees = cfg.Fees
+	if cfg.Balances != nil {
+		x.EnforceBalances = true
+		for venue, m := range cfg.Balances {
+			for asset, amt := range m {
+				x.SetBalance(venue, asset, amt)
+			}
+		}
+	}
+
+	quote := cfg.QuoteAsset
+	if quote == "" {
+		quote = routefire.Usd
+	}
+	e := &Engine{exch: x, snaps: sorted, quote: strings.ToLower(quote), mids: map[string]float64{}}
+	if len(sorted) > 0 {
+		e.now = sorted[0].Time
+	}
+	return e
+}
+
+// Function Exchange returns the simulated exchange backing the engine.
+func (e *Engine) Exchange() *sim.Exchange {
+	return e.exch
+}
+
+// Function Now returns the current simulated time.
+func (e *Engine) Now() time.Time {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.now
+}
+
+// Function Done reports whether every snapshot has been replayed.
+func (e *Engine) Done() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.next >= len(e.snaps)
+}
+
+// Function Step replays the next snapshot, advancing the clock to its time.
+// It returns false once every snapshot has been replayed.
+func (e *Engine) Step() (Snapshot, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.next >= len(e.snaps) {
+		return Snapshot{}, false
+	}
+	s := e.snaps[e.next]
+	e.next++
+	e.apply(s)
+	return s, true
+}
+
+// Function Wait advances the clock by d, replaying every snapshot up to the new
+// time. It returns false, without advancing, once every snapshot has been
+// replayed, so it can drive a polling loop: `for bt.Wait(interval) { ... }`.
+func (e *Engine) Wait(d time.Duration) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.next >= len(e.snaps) {
+		return false
+	}
+	target := e.now.Add(d)
+	for e.next < len(e.snaps) && !e.snaps[e.next].Time.After(target) {
+		e.apply(e.snaps[e.next])
+		e.next++
+	}
+	e.now = target
+	e.exch.Advance(target)
+	return true
+}
+
+// Function Run replays every remaining snapshot, calling fn after each one.
+func (e *Engine) Run(fn func(Snapshot)) {
+	for {
+		s, ok := e.Step()
+		if !ok {
+			return
+		}
+		if fn != nil {
+			fn(s)
+		}
+	}
+}
+
+func (e *Engine) apply(s Snapshot) {
+	if s.Time.After(e.now) {
+		e.now = s.Time
+	}
+	book := s.Book
+	e.exch.UpdateBook(e.now, s.Asset, s.BaseAsset, &book)
+	if strings.ToLower(s.BaseAsset) == e.quote {
+		if mid, err := book.MidPrice(); err == nil {
+			e.mids[strings.ToLower(s.Asset)] = mid
+		}
+	}
+	e.equity = append(e.equity, EquityPoint{Time: e.now, Equity: e.value(e.exch.Balances())})
+}
+
+// value returns the total value of balances in the quote asset. Assets with no
+// book against the quote asset yet are not counted.
+func (e *Engine) value(balances map[string]map[string]float64) float64 {
+	total := 0.0
+	for _, m := range balances {
+		for asset, amt := range m {
+			if asset == e.quote {
+				total += amt
+			} else {
+				total += amt * e.mids[asset]
+			}
+		}
+	}
+	return total
+}
+
+// Function Report summarizes the backtest so far.
+func (e *Engine) Report() *Report {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	r := &Report{
+		End:       e.now,
+		Orders:    e.exch.Orders(),
+		Trades:    e.exch.Fills(),
+		Positions: map[string]float64{},
+		Balances:  e.exch.Balances(),
+		Equity:    append([]EquityPoint(nil), e.equity...),
+	}
+	if len(e.snaps) > 0 {
+		r.Start = e.snaps[0].Time
+	}
+	for _, f := range r.Trades {
+		if f.Side == routefire.SideBuy {
+			r.Positions[f.Asset] += f.Quantity
+		} else {
+			r.Positions[f.Asset] -= f.Quantity
+		}
+		r.Fees += f.Fee
+	}
+	if len(r.Equity) > 0 {
+		r.StartEquity = r.Equity[0].Equity
+		r.EndEquity = r.Equity[len(r.Equity)-1].Equity
+	}
+	return r
+}
+
+//
+//  routefire.DMA
+//
+
+func (e *Engine) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	return e.exch.SubmitDMA(e.Now(), venue, asset, baseAsset, side, quantity, price), nil
+}
+
+func (e *Engine) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	return e.exch.StatusDMA(venue, venueOrdId), nil
+}
+
+func (e *Engine) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	return e.exch.CancelDMA(venue, venueOrdId), nil
+}
+
+func (e *Engine) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	return e.exch.BookDMA(asset, baseAsset), nil
+}
+
+func (e *Engine) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	return e.exch.BalanceDMA(venue, assetId), nil
+}
+
+var _ routefire.DMA = (*Engine)(nil)
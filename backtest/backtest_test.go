@@ -0,0 +1,161 @@
+package backtest
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+const uid = "backtest@example.com"
+
+var t0 = time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func entry(venue, px, qty string) routefire.DmaOrderBookEntry {
+	return routefire.DmaOrderBookEntry{Venue: venue, Price: px, Amount: qty}
+}
+
+func snap(offset time.Duration, bids, offers []routefire.DmaOrderBookEntry) Snapshot {
+	return Snapshot{
+		Time:      t0.Add(offset),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book:      routefire.DmaOrderBook{Bids: bids, Offers: offers},
+	}
+}
+
+func testSnapshots() []Snapshot {
+	return []Snapshot{
+		snap(0,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "98", "1"), entry(routefire.Gemini, "99", "1")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "100", "1"), entry(routefire.Kraken, "100.5", "5"), entry(routefire.Gemini, "101", "2")}),
+		snap(time.Second,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "105", "0.5")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "106", "3")}),
+		snap(2*time.Second,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "107", "3")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "108", "3")}),
+	}
+}
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+func TestTakerSweepAndRestingFill(t *testing.T) {
+	bt := New(testSnapshots(), Config{
+		Fees:     sim.FlatFees{routefire.Gemini: 0.001},
+		Balances: map[string]map[string]float64{routefire.Gemini: {routefire.Usd: 1000}},
+	})
+	bt.Step()
+
+	// Sweeps both Gemini offers at or under 101; the Kraken level is ignored.
+	buy, _ := bt.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "2", "101", nil)
+	if len(buy.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", buy.Errors)
+	}
+	st, _ := bt.OrderStatusDMA(uid, routefire.Gemini, buy.VenueOrderId)
+	if st.Status != routefire.StatusFilled || st.FilledAmount != "2" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	ob, _ := bt.GetConsolidatedOrderBookDMA(uid, routefire.Btc, routefire.Usd)
+	if offers := ob.Data.Offers; len(offers) != 2 || offers[1].Price != "101" || offers[1].Amount != "1" {
+		t.Errorf("consumed liquidity should be removed from the book, got %+v", offers)
+	}
+
+	// Rests above the bid, then partially fills as a maker when bids trade through it.
+	sell, _ := bt.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideSell, "2", "104", nil)
+	bt.Step()
+	st, _ = bt.OrderStatusDMA(uid, routefire.Gemini, sell.VenueOrderId)
+	if st.Status != routefire.StatusPartiallyFilled || st.FilledAmount != "0.5" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	bt.Step()
+
+	r := bt.Report()
+	if len(r.Trades) != 4 {
+		t.Fatalf("expected 4 fills, got %d", len(r.Trades))
+	}
+	if r.Trades[2].Price != 104 || !r.Trades[2].Maker {
+		t.Errorf("resting fill should execute at the limit as maker, got %+v", r.Trades[2])
+	}
+	approx(t, "position", r.Positions[routefire.Btc], 0)
+	approx(t, "fees", r.Fees, 0.001*(100+101+104*2))
+	approx(t, "usd", r.Balances[routefire.Gemini][routefire.Usd], 1000-201+208-r.Fees)
+	approx(t, "pnl", r.PnL(), 7-r.Fees)
+	if len(r.Equity) != 3 {
+		t.Errorf("expected an equity point per snapshot, got %d", len(r.Equity))
+	}
+}
+
+func TestBalanceEnforcement(t *testing.T) {
+	bt := New(testSnapshots(), Config{
+		Balances: map[string]map[string]float64{routefire.Gemini: {routefire.Usd: 150}},
+	})
+	bt.Step()
+	resp, _ := bt.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "2", "101", nil)
+	if len(resp.Errors) == 0 {
+		t.Fatalf("order exceeding balance should be rejected")
+	}
+	bal, _ := bt.BalanceDMA(uid, routefire.Gemini, routefire.Usd)
+	if bal.Amount != "150" {
+		t.Errorf("balance should be unchanged, got %s", bal.Amount)
+	}
+}
+
+func TestLatencyAndClock(t *testing.T) {
+	bt := New(testSnapshots(), Config{Latency: 1500 * time.Millisecond})
+	if !bt.Wait(0) {
+		t.Fatalf("expected data")
+	}
+	resp, _ := bt.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "106", nil)
+
+	// The order reaches the venue after the second snapshot, so it takes that
+	// snapshot's offer at 106 rather than the first snapshot's offer at 100.
+	bt.Wait(time.Second)
+	if st, _ := bt.OrderStatusDMA(uid, routefire.Gemini, resp.VenueOrderId); st.Status != routefire.StatusOpen {
+		t.Fatalf("order should not be active yet, got %+v", st)
+	}
+	bt.Wait(time.Second)
+	r := bt.Report()
+	if len(r.Trades) != 1 || r.Trades[0].Price != 106 || r.Trades[0].Maker {
+		t.Fatalf("unexpected trades %+v", r.Trades)
+	}
+	if !bt.Now().Equal(t0.Add(2 * time.Second)) {
+		t.Errorf("unexpected clock %s", bt.Now())
+	}
+	if bt.Wait(time.Second) {
+		t.Errorf("Wait should report exhausted data")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	snaps := testSnapshots()
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if err := WriteSnapshot(&buf, snaps[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ReadSnapshots(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(snaps) || !got[0].Time.Equal(t0) || got[0].Book.Offers[1].Venue != routefire.Kraken {
+		t.Errorf("unexpected snapshots %+v", got)
+	}
+}
+
+func TestUpperCaseAssetsValued(t *testing.T) {
+	s := snap(0, []routefire.DmaOrderBookEntry{entry(routefire.Gemini, "99", "1")}, []routefire.DmaOrderBookEntry{entry(routefire.Gemini, "101", "1")})
+	s.Asset, s.BaseAsset = "BTC", "USD"
+	bt := New([]Snapshot{s}, Config{QuoteAsset: "USD", Balances: map[string]map[string]float64{routefire.Gemini: {routefire.Btc: 2}}})
+	bt.Step()
+	approx(t, "equity", bt.Report().EndEquity, 200)
+}
@@ -0,0 +1,72 @@
+package backtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+// Type Snapshot is a consolidated order book recorded at a point in time.
+type Snapshot struct {
+	Time      time.Time              `json:"time"`
+	Asset     string                 `json:"asset"`
+	BaseAsset string                 `json:"base_asset"`
+	Book      routefire.DmaOrderBook `json:"book"`
+}
+
+// Function ReadSnapshots reads snapshots stored one JSON object per line, as
+// written by WriteSnapshot, and returns them sorted by time.
+func ReadSnapshots(r io.Reader) ([]Snapshot, error) {
+	var snaps []Snapshot
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var s Snapshot
+		if err := dec.Decode(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, s)
+	}
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps, nil
+}
+
+// Function WriteSnapshot appends a snapshot to w as a single line of JSON.
+func WriteSnapshot(w io.Writer, s Snapshot) error {
+	bs, err := json.Marshal(&s)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+// Function Record polls consolidated books for the given pairs (each an
+// asset/base asset tuple) every interval and writes them to w until stop is
+// closed. Pairs whose book cannot be fetched are skipped for that interval.
+func Record(api routefire.DMA, userId string, pairs [][2]string, interval time.Duration, w io.Writer, stop <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, p := range pairs {
+			ob, err := api.GetConsolidatedOrderBookDMA(userId, p[0], p[1])
+			if err != nil || len(ob.Errors) > 0 {
+				continue
+			}
+			s := Snapshot{Time: time.Now().UTC(), Asset: p[0], BaseAsset: p[1], Book: ob.Data}
+			if err := WriteSnapshot(w, s); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
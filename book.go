@@ -0,0 +1,115 @@
+package routefire
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrEmptyBook is returned when a price is requested from a book side with no entries.
+var ErrEmptyBook = errors.New("routefire: empty order book")
+
+// Function FormatFloat formats a price or quantity for submission, using the
+// fewest digits that represent it exactly.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Function Floats parses the price and quantity of an order book entry.
+func (e DmaOrderBookEntry) Floats() (price, quantity float64, err error) {
+	price, err = strconv.ParseFloat(e.Price, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	quantity, err = strconv.ParseFloat(e.Amount, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return price, quantity, nil
+}
+
+// Function BestBid returns the highest-priced bid in the book. Books returned by
+// the DMA API are sorted from lowest to highest price, but the side is scanned in
+// full so that unsorted (e.g. locally constructed) books are handled as well.
+func (ob *DmaOrderBook) BestBid() (DmaOrderBookEntry, error) {
+	return bestEntry(ob.Bids, true)
+}
+
+// Function BestOffer returns the lowest-priced offer in the book.
+func (ob *DmaOrderBook) BestOffer() (DmaOrderBookEntry, error) {
+	return bestEntry(ob.Offers, false)
+}
+
+// Function MidPrice returns the midpoint of the best bid and best offer.
+func (ob *DmaOrderBook) MidPrice() (float64, error) {
+	bid, err := ob.BestBid()
+	if err != nil {
+		return 0, err
+	}
+	offer, err := ob.BestOffer()
+	if err != nil {
+		return 0, err
+	}
+	bidPx, _, err := bid.Floats()
+	if err != nil {
+		return 0, err
+	}
+	offerPx, _, err := offer.Floats()
+	if err != nil {
+		return 0, err
+	}
+	return (bidPx + offerPx) / 2.0, nil
+}
+
+func bestEntry(side []DmaOrderBookEntry, highest bool) (DmaOrderBookEntry, error) {
+	var best DmaOrderBookEntry
+	bestPx := 0.0
+	found := false
+	for _, e := range side {
+		px, err := strconv.ParseFloat(e.Price, 64)
+		if err != nil {
+			return DmaOrderBookEntry{}, err
+		}
+		if !found || (highest && px > bestPx) || (!highest && px < bestPx) {
+			best, bestPx, found = e, px, true
+		}
+	}
+	if !found {
+		return DmaOrderBookEntry{}, ErrEmptyBook
+	}
+	return best, nil
+}
+
+// Function SweepLevels returns the entries an order on the given side would take
+// liquidity from, best price first: offers in ascending price order for a buy,
+// bids in descending price order for a sell. Entries with unparseable prices
+// are dropped.
+func (ob *DmaOrderBook) SweepLevels(side string) []DmaOrderBookEntry {
+	src := ob.Offers
+	if strings.ToUpper(side) == SideSell {
+		src = ob.Bids
+	}
+	type level struct {
+		entry DmaOrderBookEntry
+		price float64
+	}
+	levels := make([]level, 0, len(src))
+	for _, e := range src {
+		if px, err := strconv.ParseFloat(e.Price, 64); err == nil {
+			levels = append(levels, level{e, px})
+		}
+	}
+	sell := strings.ToUpper(side) == SideSell
+	sort.SliceStable(levels, func(i, j int) bool {
+		if sell {
+			return levels[i].price > levels[j].price
+		}
+		return levels[i].price < levels[j].price
+	})
+	out := make([]DmaOrderBookEntry, len(levels))
+	for i, l := range levels {
+		out[i] = l.entry
+	}
+	return out
+}
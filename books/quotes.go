@@ -0,0 +1,215 @@
+package books
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/routefire/go-routefire"
+)
+
+var ErrNoRate = errors.New("books: no conversion rate for quote asset")
+
+// Type Rate is the value of a quote asset in the quote of a multi-quote book:
+// what a unit of it sells for (Bid) and what a unit costs (Offer).
+type Rate struct {
+	Bid   float64
+	Offer float64
+}
+
+// Type QuoteEntry is an entry of a multi-quote book. Its Price is in the
+// book's quote; QuoteAsset and OriginalPrice are the market it is listed in
+// and its price there, and Rate the conversion between the two.
+type QuoteEntry struct {
+	routefire.DmaOrderBookEntry
+	QuoteAsset    string
+	OriginalPrice float64
+	Rate          float64
+}
+
+// Type MultiQuoteBook is a consolidated book of an asset across several quote
+// assets, such as btc/usd, btc/usdt and btc/usdc, priced in one quote. Like
+// DMA books, both sides are in ascending price order.
+type MultiQuoteBook struct {
+	Asset  string
+	Quote  string
+	Rates  map[string]Rate
+	Bids   []QuoteEntry
+	Offers []QuoteEntry
+}
+
+// Function Book returns the book as a DMA book, for use with its sweep helpers
+// and fee schedules. Venues are kept, so fees apply as on the original markets.
+func (b *MultiQuoteBook) Book() *routefire.DmaOrderBook {
+	out := &routefire.DmaOrderBook{}
+	for _, e := range b.Bids {
+		out.Bids = append(out.Bids, e.DmaOrderBookEntry)
+	}
+	for _, e := range b.Offers {
+		out.Offers = append(out.Offers, e.DmaOrderBookEntry)
+	}
+	return out
+}
+
+// Function MultiQuote merges legs, books of asset against different quote
+// assets, into one book priced in quote. Bids are converted at the rate their
+// proceeds sell for and offers at the rate their cost is bought for, so prices
+// are what the asset is worth in quote after converting; fees on conversions
+// are not included. Legs quoted in quote itself convert at one, and legs whose
+// quote asset has no rate are left out with ErrNoRate.
+func MultiQuote(asset, quote string, legs []Leg, rates map[string]Rate) (*MultiQuoteBook, error) {
+	asset, quote = strings.ToLower(asset), strings.ToLower(quote)
+	out := &MultiQuoteBook{Asset: asset, Quote: quote, Rates: map[string]Rate{}}
+	var err error
+	for _, l := range legs {
+		q := strings.ToLower(l.BaseAsset)
+		if strings.ToLower(l.Asset) != asset || l.Book == nil {
+			continue
+		}
+		r, ok := rates[q]
+		if q == quote {
+			r, ok = Rate{1, 1}, true
+		}
+		if !ok || r.Bid <= 0 || r.Offer <= 0 {
+			if err == nil {
+				err = ErrNoRate
+			}
+			continue
+		}
+		out.Rates[q] = r
+		out.Bids = append(out.Bids, convert(l.Book.Bids, q, r.Bid)...)
+		out.Offers = append(out.Offers, convert(l.Book.Offers, q, r.Offer)...)
+	}
+	sortEntries(out.Bids)
+	sortEntries(out.Offers)
+	return out, err
+}
+
+func convert(entries []routefire.DmaOrderBookEntry, quote string, rate float64) []QuoteEntry {
+	var out []QuoteEntry
+	for _, e := range entries {
+		px, _, err := e.Floats()
+		if err != nil || px <= 0 {
+			continue
+		}
+		converted := e
+		converted.Price = routefire.FormatFloat(px * rate)
+		out = append(out, QuoteEntry{converted, quote, px, rate})
+	}
+	return out
+}
+
+func sortEntries(entries []QuoteEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].OriginalPrice*entries[i].Rate < entries[j].OriginalPrice*entries[j].Rate
+	})
+}
+
+// Function Rate fetches the rate of asset in quote from the touch of the
+// asset/quote book, or the inverse of the quote/asset book if that is empty.
+// If AssumePar is set, stablecoins without either book are valued at one usd.
+func (b *Builder) Rate(asset, quote string) (Rate, error) {
+	asset, quote = strings.ToLower(asset), strings.ToLower(quote)
+	if asset == quote {
+		return Rate{1, 1}, nil
+	}
+	if r, err := b.touch(asset, quote); err == nil {
+		return r, nil
+	}
+	if r, err := b.touch(quote, asset); err == nil {
+		return Rate{Bid: 1 / r.Offer, Offer: 1 / r.Bid}, nil
+	}
+	if b.AssumePar && quote == routefire.Usd && routefire.IsStablecoin(asset) {
+		return Rate{1, 1}, nil
+	}
+	return Rate{}, ErrNoRate
+}
+
+func (b *Builder) touch(asset, quote string) (Rate, error) {
+	ob, err := b.Book(asset, quote)
+	if err != nil {
+		return Rate{}, err
+	}
+	bid, err := ob.BestBid()
+	if err != nil {
+		return Rate{}, err
+	}
+	offer, err := ob.BestOffer()
+	if err != nil {
+		return Rate{}, err
+	}
+	bidPx, _, err := bid.Floats()
+	if err != nil {
+		return Rate{}, err
+	}
+	offerPx, _, err := offer.Floats()
+	if err != nil {
+		return Rate{}, err
+	}
+	if bidPx <= 0 || offerPx <= 0 {
+		return Rate{}, routefire.ErrEmptyBook
+	}
+	return Rate{bidPx, offerPx}, nil
+}
+
+// Function MultiQuote fetches the books of asset against each of quotes, and
+// the rate of each quote asset in quote, concurrently, and merges them into one
+// book priced in quote. Quote assets whose book or rate cannot be fetched are
+// left out, and the first error is returned with the book. With no quotes given
+// it merges quote and every usd stablecoin, and markets that cannot be fetched
+// are taken not to be listed rather than reported.
+func (b *Builder) MultiQuote(asset, quote string, quotes ...string) (*MultiQuoteBook, error) {
+	asset, quote = strings.ToLower(asset), strings.ToLower(quote)
+	listed := len(quotes) > 0
+	if !listed {
+		quotes = []string{quote}
+		for _, q := range routefire.Stablecoins {
+			if q != quote && q != asset {
+				quotes = append(quotes, q)
+			}
+		}
+	}
+	legs := make([]Leg, len(quotes))
+	rates := make([]Rate, len(quotes))
+	errs := make([]error, len(quotes))
+	var wg sync.WaitGroup
+	for i, q := range quotes {
+		wg.Add(1)
+		go func(i int, q string) {
+			defer wg.Done()
+			q = strings.ToLower(q)
+			legs[i] = Leg{Asset: asset, BaseAsset: q}
+			if legs[i].Book, errs[i] = b.Book(asset, q); errs[i] != nil {
+				if !listed {
+					legs[i].Book, errs[i] = nil, nil
+				}
+				return
+			}
+			rates[i], errs[i] = b.Rate(q, quote)
+		}(i, q)
+	}
+	wg.Wait()
+
+	var firstErr error
+	var fetched []Leg
+	byQuote := map[string]Rate{}
+	for i := range quotes {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		if legs[i].Book == nil {
+			continue
+		}
+		fetched = append(fetched, legs[i])
+		byQuote[legs[i].BaseAsset] = rates[i]
+	}
+	out, err := MultiQuote(asset, quote, fetched, byQuote)
+	if firstErr == nil {
+		firstErr = err
+	}
+	return out, firstErr
+}
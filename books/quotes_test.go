@@ -0,0 +1,95 @@
+package books
+
+import (
+	"testing"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/fees"
+)
+
+func quotesEngine() *backtest.Engine {
+	return engine(
+		book(routefire.Btc, routefire.Usd,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "9990", "1")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "10010", "1")}),
+		book(routefire.Btc, routefire.Usdt,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Binance, "10000", "2")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Binance, "10020", "2")}),
+		book(routefire.Usdt, routefire.Usd,
+			[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "0.998", "100000")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "1.002", "100000")}),
+		// Usdc has no usd book.
+		book(routefire.Btc, routefire.Usdc,
+			[]routefire.DmaOrderBookEntry{entry(routefire.CoinbasePro, "9995", "1")},
+			[]routefire.DmaOrderBookEntry{entry(routefire.CoinbasePro, "10005", "1")}),
+	)
+}
+
+func prices(t *testing.T, entries []QuoteEntry) []float64 {
+	t.Helper()
+	var out []float64
+	for _, e := range entries {
+		px, _ := floats(t, e.DmaOrderBookEntry)
+		out = append(out, px)
+	}
+	return out
+}
+
+func TestMultiQuote(t *testing.T) {
+	b := NewBuilder(quotesEngine(), uid)
+	mq, err := b.MultiQuote(routefire.Btc, routefire.Usd, routefire.Usd, routefire.Usdt, routefire.Usdc)
+	if err != ErrNoRate {
+		t.Errorf("expected ErrNoRate for usdc, got %v", err)
+	}
+	// Usdt bids sell usdt at 0.998, and offers buy it at 1.002.
+	bids, offers := prices(t, mq.Bids), prices(t, mq.Offers)
+	if len(bids) != 2 || len(offers) != 2 {
+		t.Fatalf("unexpected book %+v", mq)
+	}
+	approx(t, "usdt bid", bids[0], 9980)
+	approx(t, "usd bid", bids[1], 9990)
+	approx(t, "usd offer", offers[0], 10010)
+	approx(t, "usdt offer", offers[1], 10020*1.002)
+	if e := mq.Bids[0]; e.QuoteAsset != routefire.Usdt || e.OriginalPrice != 10000 || e.Venue != routefire.Binance {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if r := mq.Rates[routefire.Usdt]; r.Bid != 0.998 || r.Offer != 1.002 {
+		t.Errorf("unexpected rate %+v", r)
+	}
+
+	// Fees apply by venue on the merged book.
+	fs := &fees.Schedule{Venues: map[string][]fees.Tier{routefire.Gemini: {{Taker: 0.01}}}}
+	c, err := fs.Sweep(mq.Book(), routefire.SideBuy, 1)
+	if err != nil || c.Legs[0].Venue != routefire.Binance {
+		t.Errorf("expected Binance to be cheaper after fees, got %+v %v", c, err)
+	}
+}
+
+func TestMultiQuoteDefaultsAndPar(t *testing.T) {
+	b := NewBuilder(quotesEngine(), uid)
+	b.AssumePar = true
+	mq, err := b.MultiQuote(routefire.Btc, routefire.Usd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bids, offers := prices(t, mq.Bids), prices(t, mq.Offers)
+	if len(bids) != 3 || len(offers) != 3 {
+		t.Fatalf("unexpected book %+v", mq)
+	}
+	approx(t, "usdc bid", bids[2], 9995)
+	approx(t, "usdc offer", offers[0], 10005)
+	if mq.Bids[2].QuoteAsset != routefire.Usdc {
+		t.Errorf("unexpected entry %+v", mq.Bids[2])
+	}
+
+	// The inverse of a usd/usdt book also converts.
+	r, err := NewBuilder(engine(book(routefire.Usd, routefire.Usdt,
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "0.998", "1")},
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "1.002", "1")})), uid).Rate(routefire.Usdt, routefire.Usd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approx(t, "inverse bid", r.Bid, 1/1.002)
+	approx(t, "inverse offer", r.Offer, 1/0.998)
+}
@@ -0,0 +1,272 @@
+// Package books builds order books that the DMA API does not consolidate. A
+// synthetic book implies one pair's book from two others through a common
+// asset, with depth, so that direct and synthetic execution can be compared. A
+// multi-quote book merges an asset's books against usd and its stablecoins into
+// one usd-equivalent book.
+package books
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
+)
+
+const epsilon = 1e-12
+
+var ErrNoCommonAsset = errors.New("books: legs do not share an asset linking the pair")
+
+// Type Leg is a consolidated book for a pair.
+type Leg struct {
+	Asset     string
+	BaseAsset string
+	Book      *routefire.DmaOrderBook
+}
+
+// conversion is a level of the leg that trades through the common asset,
+// expressed per unit of the common asset: q is how much of it the level can
+// convert, and rate how much of the synthetic base asset one unit converts
+// into or from.
+type conversion struct {
+	venue string
+	q     float64
+	rate  float64
+}
+
+// anchor is a level of the leg that trades the synthetic asset: price is in
+// the common asset per unit of it.
+type anchor struct {
+	venue string
+	q     float64
+	price float64
+}
+
+// Function Synthetic implies the book of asset/baseAsset from two legs through
+// a common asset q. The first leg is asset/q; the second is either baseAsset/q,
+// which is divided through (eth/usd and btc/usd imply eth/btc), or q/baseAsset,
+// which is multiplied through (xlm/btc and btc/usd imply xlm/usd).
+//
+// A synthetic bid sells the asset into the first leg and converts the proceeds
+// through the second; a synthetic offer does the reverse. Each entry's amount is
+// in the asset and its venue names both legs' venues, as in "GEMINI+KRAKEN".
+// If fs is not nil, the legs' taker fees are netted into the synthetic prices;
+// the combined venues have no schedule of their own, so fs.Sweep then compares
+// direct and synthetic execution like for like.
+func Synthetic(asset, baseAsset string, first, second Leg, fs *fees.Schedule) (*routefire.DmaOrderBook, error) {
+	asset, baseAsset = strings.ToLower(asset), strings.ToLower(baseAsset)
+	if strings.ToLower(first.Asset) != asset {
+		return nil, ErrNoCommonAsset
+	}
+	q := strings.ToLower(first.BaseAsset)
+	var divide bool
+	switch {
+	case strings.ToLower(second.Asset) == baseAsset && strings.ToLower(second.BaseAsset) == q:
+		divide = true
+	case strings.ToLower(second.Asset) == q && strings.ToLower(second.BaseAsset) == baseAsset:
+	default:
+		return nil, ErrNoCommonAsset
+	}
+
+	out := &routefire.DmaOrderBook{}
+	// Bids sell the asset for q, then convert q into the base asset: buying it
+	// from the offers of base/q, or selling q into the bids of q/base.
+	bids := combine(
+		anchors(first.Book, routefire.SideSell, fs),
+		conversions(second.Book, secondSide(divide, routefire.SideSell), divide, fs),
+	)
+	for i := len(bids) - 1; i >= 0; i-- {
+		out.Bids = append(out.Bids, bids[i])
+	}
+	// Offers convert the base asset into q, then buy the asset with q.
+	out.Offers = combine(
+		anchors(first.Book, routefire.SideBuy, fs),
+		conversions(second.Book, secondSide(divide, routefire.SideBuy), divide, fs),
+	)
+	return out, nil
+}
+
+// secondSide is the side traded on the second leg for a synthetic order on
+// side. Dividing through base/q, a synthetic sell buys the base asset; through
+// q/base it sells q.
+func secondSide(divide bool, side string) string {
+	if divide == (side == routefire.SideSell) {
+		return routefire.SideBuy
+	}
+	return routefire.SideSell
+}
+
+func anchors(ob *routefire.DmaOrderBook, side string, fs *fees.Schedule) []anchor {
+	var out []anchor
+	for _, l := range sweep(ob, side, fs) {
+		out = append(out, anchor{l.venue, l.qty * l.net, l.net})
+	}
+	return out
+}
+
+func conversions(ob *routefire.DmaOrderBook, side string, divide bool, fs *fees.Schedule) []conversion {
+	var out []conversion
+	for _, l := range sweep(ob, side, fs) {
+		if divide {
+			// The base asset priced in q: one q is 1/price of it.
+			out = append(out, conversion{l.venue, l.qty * l.net, 1 / l.net})
+		} else {
+			// Q priced in the base asset.
+			out = append(out, conversion{l.venue, l.qty, l.net})
+		}
+	}
+	return out
+}
+
+type sweepLevel struct {
+	venue string
+	qty   float64
+	net   float64
+}
+
+// sweep returns the levels an order on side takes from, best net price first.
+func sweep(ob *routefire.DmaOrderBook, side string, fs *fees.Schedule) []sweepLevel {
+	if ob == nil {
+		return nil
+	}
+	if fs != nil {
+		ob = fs.AdjustBook(ob)
+	}
+	var out []sweepLevel
+	for _, e := range ob.SweepLevels(side) {
+		px, qty, err := e.Floats()
+		if err != nil || px <= 0 || qty <= 0 {
+			continue
+		}
+		out = append(out, sweepLevel{strings.ToUpper(e.Venue), qty, px})
+	}
+	return out
+}
+
+// combine walks both legs best first, in units of the common asset, and
+// returns the implied entries in the same order.
+func combine(as []anchor, cs []conversion) []routefire.DmaOrderBookEntry {
+	var out []routefire.DmaOrderBookEntry
+	i, j := 0, 0
+	var aUsed, cUsed float64
+	for i < len(as) && j < len(cs) {
+		chunk := as[i].q - aUsed
+		if rest := cs[j].q - cUsed; rest < chunk {
+			chunk = rest
+		}
+		if chunk > epsilon {
+			out = append(out, routefire.DmaOrderBookEntry{
+				Venue:  as[i].venue + "+" + cs[j].venue,
+				Price:  routefire.FormatFloat(as[i].price * cs[j].rate),
+				Amount: routefire.FormatFloat(chunk / as[i].price),
+			})
+		}
+		aUsed += chunk
+		cUsed += chunk
+		if as[i].q-aUsed <= epsilon {
+			i, aUsed = i+1, 0
+		}
+		if cs[j].q-cUsed <= epsilon {
+			j, cUsed = j+1, 0
+		}
+	}
+	return out
+}
+
+// Type Builder fetches consolidated books over DMA and builds synthetic books
+// from them.
+type Builder struct {
+	api    routefire.DMA
+	userId string
+
+	// Fees are the taker fees netted into synthetic prices and charged on
+	// direct sweeps. Nil is fee-free.
+	Fees *fees.Schedule
+	// AssumePar, if set, values stablecoins with no book against usd at one
+	// usd when converting between quote assets.
+	AssumePar bool
+}
+
+// Function NewBuilder creates a builder fetching books for userId.
+func NewBuilder(api routefire.DMA, userId string) *Builder {
+	return &Builder{api: api, userId: userId}
+}
+
+// Function Book fetches the consolidated book of asset/baseAsset.
+func (b *Builder) Book(asset, baseAsset string) (*routefire.DmaOrderBook, error) {
+	ob, err := b.api.GetConsolidatedOrderBookDMA(b.userId, asset, baseAsset)
+	if err != nil {
+		return nil, err
+	}
+	if err := routefire.FirstDmaError(ob.Errors); err != nil {
+		return nil, err
+	}
+	return &ob.Data, nil
+}
+
+// Function Synthetic builds the synthetic book of asset/baseAsset through via,
+// from the books of asset/via and baseAsset/via, or via/baseAsset if the former
+// is empty.
+func (b *Builder) Synthetic(asset, baseAsset, via string) (*routefire.DmaOrderBook, error) {
+	first, err := b.Book(asset, via)
+	if err != nil {
+		return nil, err
+	}
+	second := Leg{Asset: baseAsset, BaseAsset: via}
+	second.Book, err = b.Book(baseAsset, via)
+	if err != nil || empty(second.Book) {
+		second = Leg{Asset: via, BaseAsset: baseAsset}
+		if second.Book, err = b.Book(via, baseAsset); err != nil {
+			return nil, err
+		}
+	}
+	return Synthetic(asset, baseAsset, Leg{asset, via, first}, second, b.Fees)
+}
+
+func empty(ob *routefire.DmaOrderBook) bool {
+	return ob == nil || len(ob.Bids)+len(ob.Offers) == 0
+}
+
+// Type Comparison is the cost of sweeping the same quantity from a pair's
+// direct book and from its synthetic book. Either sweep may be partial when
+// its book is too thin; Direct is nil if the direct book could not be fetched.
+// The synthetic sweep's fees are netted into its prices, so its Fees are zero.
+type Comparison struct {
+	Side      string
+	Quantity  float64
+	Direct    *fees.SweepCost
+	Synthetic *fees.SweepCost
+}
+
+// Function Better reports whether the synthetic route is better: it fills more
+// of the quantity, or as much at a better price after fees.
+func (c *Comparison) Better() bool {
+	if c.Synthetic == nil || c.Synthetic.Quantity <= epsilon {
+		return false
+	}
+	if c.Direct == nil || c.Synthetic.Quantity > c.Direct.Quantity+epsilon {
+		return true
+	}
+	if c.Synthetic.Quantity < c.Direct.Quantity-epsilon {
+		return false
+	}
+	if c.Side == routefire.SideSell {
+		return c.Synthetic.NetAvgPrice() > c.Direct.NetAvgPrice()
+	}
+	return c.Synthetic.NetAvgPrice() < c.Direct.NetAvgPrice()
+}
+
+// Function Compare sweeps quantity of asset on side from the direct book of
+// asset/baseAsset and from its synthetic book through via.
+func (b *Builder) Compare(asset, baseAsset, via, side string, quantity float64) (*Comparison, error) {
+	syn, err := b.Synthetic(asset, baseAsset, via)
+	if err != nil {
+		return nil, err
+	}
+	c := &Comparison{Side: strings.ToUpper(side), Quantity: quantity}
+	c.Synthetic, _ = b.Fees.Sweep(syn, side, quantity)
+	if direct, err := b.Book(asset, baseAsset); err == nil {
+		c.Direct, _ = b.Fees.Sweep(direct, side, quantity)
+	}
+	return c, nil
+}
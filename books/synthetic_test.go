@@ -0,0 +1,145 @@
+package books
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/fees"
+)
+
+const uid = "books@example.com"
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+func entry(venue, price, amount string) routefire.DmaOrderBookEntry {
+	return routefire.DmaOrderBookEntry{Venue: venue, Price: price, Amount: amount}
+}
+
+func book(asset, base string, bids, offers []routefire.DmaOrderBookEntry) backtest.Snapshot {
+	return backtest.Snapshot{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     asset,
+		BaseAsset: base,
+		Book:      routefire.DmaOrderBook{Bids: bids, Offers: offers},
+	}
+}
+
+func floats(t *testing.T, e routefire.DmaOrderBookEntry) (float64, float64) {
+	t.Helper()
+	px, qty, err := e.Floats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return px, qty
+}
+
+var (
+	ethUsd = book(routefire.Eth, routefire.Usd,
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "198", "10"), entry(routefire.Gemini, "199", "10")},
+		[]routefire.DmaOrderBookEntry{entry(routefire.Gemini, "201", "10")})
+	btcUsd = book(routefire.Btc, routefire.Usd,
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "9950", "1")},
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "10000", "0.1"), entry(routefire.Kraken, "10050", "1")})
+	// The direct book offers a single eth.
+	ethBtc = book(routefire.Eth, routefire.Btc,
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "0.0199", "1")},
+		[]routefire.DmaOrderBookEntry{entry(routefire.Kraken, "0.0203", "1")})
+)
+
+func engine(snaps ...backtest.Snapshot) *backtest.Engine {
+	bt := backtest.New(snaps, backtest.Config{})
+	for range snaps {
+		bt.Step()
+	}
+	return bt
+}
+
+func TestSyntheticDepth(t *testing.T) {
+	ob, err := NewBuilder(engine(ethUsd, btcUsd), uid).Synthetic(routefire.Eth, routefire.Btc, routefire.Usd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Selling eth at 199 raises 1990 usd: the first 1000 buy btc at 10000,
+	// the rest at 10050, and Kraken's 198 bid buys btc at 10050 too.
+	if len(ob.Bids) != 3 || len(ob.Offers) != 1 {
+		t.Fatalf("unexpected book %+v", ob)
+	}
+	want := []struct{ px, qty float64 }{
+		{198.0 / 10050, 10},
+		{199.0 / 10050, 990.0 / 199},
+		{199.0 / 10000, 1000.0 / 199},
+	}
+	for i, w := range want {
+		px, qty := floats(t, ob.Bids[i])
+		approx(t, "bid price "+strconv.Itoa(i), px, w.px)
+		approx(t, "bid amount "+strconv.Itoa(i), qty, w.qty)
+	}
+	if v := ob.Bids[2].Venue; v != "GEMINI+KRAKEN" {
+		t.Errorf("unexpected venue %s", v)
+	}
+	// Buying eth at 201 costs 2010 usd, raised selling btc at 9950.
+	px, qty := floats(t, ob.Offers[0])
+	approx(t, "offer price", px, 201.0/9950)
+	approx(t, "offer amount", qty, 10)
+}
+
+func TestSyntheticMultiply(t *testing.T) {
+	// Eth/btc and btc/usd imply eth/usd.
+	ob, err := NewBuilder(engine(ethBtc, btcUsd), uid).Synthetic(routefire.Eth, routefire.Usd, routefire.Btc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ob.Bids) != 1 || len(ob.Offers) != 1 {
+		t.Fatalf("unexpected book %+v", ob)
+	}
+	px, qty := floats(t, ob.Bids[0])
+	approx(t, "bid price", px, 0.0199*9950)
+	approx(t, "bid amount", qty, 1)
+	// The 0.0203 btc needed is bought at 10000.
+	px, qty = floats(t, ob.Offers[0])
+	approx(t, "offer price", px, 0.0203*10000)
+	approx(t, "offer amount", qty, 1)
+
+	if _, err := Synthetic(routefire.Eth, routefire.Usd, Leg{routefire.Eth, routefire.Btc, nil}, Leg{routefire.Eth, routefire.Usd, nil}, nil); err != ErrNoCommonAsset {
+		t.Errorf("expected ErrNoCommonAsset, got %v", err)
+	}
+}
+
+func TestSyntheticFeesAndCompare(t *testing.T) {
+	b := NewBuilder(engine(ethUsd, btcUsd, ethBtc), uid)
+	b.Fees = &fees.Schedule{Venues: map[string][]fees.Tier{routefire.Gemini: {{Taker: 0.01}}}}
+	ob, err := b.Synthetic(routefire.Eth, routefire.Btc, routefire.Usd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	px, qty := floats(t, ob.Offers[0])
+	approx(t, "offer price", px, 201*1.01/9950)
+	approx(t, "offer amount", qty, 10)
+
+	c, err := b.Compare(routefire.Eth, routefire.Btc, routefire.Usd, routefire.SideBuy, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The direct book fills only one eth.
+	approx(t, "direct", c.Direct.Quantity, 1)
+	approx(t, "synthetic", c.Synthetic.Quantity, 5)
+	approx(t, "synthetic price", c.Synthetic.NetAvgPrice(), 201*1.01/9950)
+	if !c.Better() {
+		t.Errorf("expected the synthetic route to be better")
+	}
+
+	c, _ = b.Compare(routefire.Eth, routefire.Btc, routefire.Usd, routefire.SideSell, 1)
+	// 0.0199 direct beats 198/10000 from Kraken synthetically.
+	if c.Better() {
+		t.Errorf("expected the direct route to be better, got %f", c.Synthetic.NetAvgPrice())
+	}
+}
@@ -0,0 +1,508 @@
+// Package conditional provides client-side conditional orders: stops, take
+// profits and trailing stops, one-cancels-other pairs, and brackets that attach
+// a take profit and a stop loss to an entry DMA order. An Engine watches the
+// consolidated book and the entry fills, and submits a DMA or algorithm order
+// when a condition is met.
+package conditional
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+var (
+	ErrInvalidLeg = errors.New("conditional: invalid leg")
+)
+
+// Leg types.
+const (
+	// Stop triggers when the price moves against the order's side: at or below
+	// the trigger for a sell, at or above it for a buy.
+	Stop = "stop"
+	// TakeProfit triggers when the price reaches the trigger in the order's
+	// favor: at or above it for a sell, at or below it for a buy.
+	TakeProfit = "take_profit"
+	// TrailingStop is a stop that follows the best price seen by Trail.
+	TrailingStop = "trailing_stop"
+)
+
+// Order states.
+const (
+	StatePending   = "PENDING"   // Waiting for the entry to fill
+	StateActive    = "ACTIVE"    // Watching the market
+	StateTriggered = "TRIGGERED" // A leg has been submitted
+	StateCancelled = "CANCELLED"
+	StateFailed    = "FAILED" // A leg triggered but could not be submitted
+)
+
+// Type Leg is an order submitted when a condition is met. Conditions are
+// evaluated against the price the leg would trade at: the best bid for a sell
+// and the best offer for a buy.
+type Leg struct {
+	Type string
+	Side string
+	// Quantity to submit. In a bracket, zero means the entry's filled quantity.
+	Quantity float64
+	// Trigger is the trigger price. For a trailing stop, zero starts the stop
+	// Trail away from the first price seen.
+	Trigger float64
+	// Trail is the distance a trailing stop follows the best price at.
+	Trail float64
+	// Venue receives a DMA order. If empty, the venue quoting the best price.
+	Venue string
+	// Price is the limit price submitted. If zero, the order is priced to take
+	// liquidity: the touch price moved by the engine's Slippage.
+	Price float64
+	// Algo, if set, submits an algorithm order with AlgoParams instead of a
+	// DMA order. The limit price is passed as the iwould parameter.
+	Algo       string
+	AlgoParams map[string]string
+
+	// Set when the leg triggers.
+	Triggered   time.Time
+	TriggeredAt float64 // Market price that triggered the leg
+	OrderId     string  // DMA venue order ID, or algorithm order ID
+	SubmittedTo string  // Venue of a DMA order
+	Err         error
+
+	peak float64 // Best price seen, for trailing stops
+}
+
+func (l *Leg) validate() error {
+	l.Side = strings.ToUpper(l.Side)
+	if l.Side != routefire.SideBuy && l.Side != routefire.SideSell || l.Quantity < 0 || l.Price < 0 {
+		return ErrInvalidLeg
+	}
+	switch l.Type {
+	case Stop, TakeProfit:
+		if l.Trigger <= 0 {
+			return ErrInvalidLeg
+		}
+	case TrailingStop:
+		if l.Trail <= 0 {
+			return ErrInvalidLeg
+		}
+	default:
+		return ErrInvalidLeg
+	}
+	return nil
+}
+
+// triggered updates a leg with the latest price and reports whether it fires.
+func (l *Leg) triggered(px float64) bool {
+	sell := l.Side == routefire.SideSell
+	switch l.Type {
+	case Stop:
+		return (sell && px <= l.Trigger) || (!sell && px >= l.Trigger)
+	case TakeProfit:
+		return (sell && px >= l.Trigger) || (!sell && px <= l.Trigger)
+	}
+	// Trailing stop: a sell follows the highest price, a buy the lowest.
+	if l.peak == 0 || (sell && px > l.peak) || (!sell && px < l.peak) {
+		l.peak = px
+	}
+	stop := l.peak - l.Trail
+	if !sell {
+		stop = l.peak + l.Trail
+	}
+	if l.Trigger == 0 || (sell && stop > l.Trigger) || (!sell && stop < l.Trigger) {
+		l.Trigger = stop
+	}
+	return (sell && px <= l.Trigger) || (!sell && px >= l.Trigger)
+}
+
+// Type Order is a conditional order: one or more legs, of which at most one
+// is submitted, optionally waiting on an entry order.
+type Order struct {
+	Id        int
+	Asset     string
+	BaseAsset string
+	Legs      []*Leg
+
+	// Entry, for brackets: the DMA order whose fills activate the legs.
+	EntryVenue   string
+	EntryOrderId string
+	EntryFilled  float64
+	entryClosed  bool
+
+	State string
+	Fired *Leg // The leg that triggered
+}
+
+// Type Engine watches the market for conditional orders.
+type Engine struct {
+	api    routefire.DMA
+	userId string
+	clock  strategy.Clock
+
+	lock   sync.Mutex
+	seq    int
+	orders []*Order
+
+	// Slippage is the fraction beyond the touch price at which legs without a
+	// limit price are submitted, e.g. 0.002 to buy at up to 0.2% over the
+	// best offer. Defaults to 0.5%.
+	Slippage float64
+	// Interval is the time between steps in Run. Defaults to one second.
+	Interval time.Duration
+	// OnTrigger, if set, is called after a leg has been submitted, or failed to be.
+	OnTrigger func(o *Order, l *Leg)
+}
+
+// Function New creates an engine. Legs with an Algo need api to be a
+// routefire.API.
+func New(api routefire.DMA, clock strategy.Clock, userId string) *Engine {
+	return &Engine{api: api, userId: userId, clock: clock, Slippage: 0.005, Interval: time.Second}
+}
+
+func (e *Engine) add(o *Order) (*Order, error) {
+	for _, l := range o.Legs {
+		if err := l.validate(); err != nil {
+			return nil, err
+		}
+		if l.Algo != "" {
+			if _, ok := e.api.(routefire.API); !ok {
+				return nil, routefire.ErrNoAlgoAPI
+			}
+		}
+	}
+	o.Asset, o.BaseAsset = strings.ToLower(o.Asset), strings.ToLower(o.BaseAsset)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.seq++
+	o.Id = e.seq
+	e.orders = append(e.orders, o)
+	return o, nil
+}
+
+// Function Conditional watches a single leg, such as a stop loss or a trailing
+// stop.
+func (e *Engine) Conditional(asset, baseAsset string, leg Leg) (*Order, error) {
+	if leg.Quantity <= 0 {
+		return nil, ErrInvalidLeg
+	}
+	return e.add(&Order{Asset: asset, BaseAsset: baseAsset, Legs: []*Leg{&leg}, State: StateActive})
+}
+
+// Function OCO watches two legs; when one triggers, the other is cancelled.
+func (e *Engine) OCO(asset, baseAsset string, a, b Leg) (*Order, error) {
+	if a.Quantity <= 0 || b.Quantity <= 0 {
+		return nil, ErrInvalidLeg
+	}
+	return e.add(&Order{Asset: asset, BaseAsset: baseAsset, Legs: []*Leg{&a, &b}, State: StateActive})
+}
+
+// Function Bracket submits an entry DMA order and attaches a take profit and a
+// stop loss on the opposite side, as a one-cancels-other pair. Once the entry
+// starts to fill, its remainder is cancelled and the exits become active, sized
+// to the entry's filled quantity.
+func (e *Engine) Bracket(venue, asset, baseAsset, side string, quantity, price, takeProfit, stopLoss float64) (*Order, error) {
+	side = strings.ToUpper(side)
+	exit := routefire.SideSell
+	if side == routefire.SideSell {
+		exit = routefire.SideBuy
+	}
+	tp := Leg{Type: TakeProfit, Side: exit, Trigger: takeProfit, Venue: venue}
+	sl := Leg{Type: Stop, Side: exit, Trigger: stopLoss, Venue: venue}
+	for _, l := range []*Leg{&tp, &sl} {
+		if err := l.validate(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := e.api.SubmitOrderDMA(e.userId, venue, asset, baseAsset, side,
+		routefire.FormatQuantity(asset, quantity), routefire.FormatFloat(price), nil)
+	if err == nil {
+		err = routefire.FirstDmaError(resp.Errors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.AttachBracket(venue, resp.VenueOrderId, asset, baseAsset, tp, sl)
+}
+
+// Function AttachBracket attaches exit legs to an existing entry DMA order, as
+// a one-cancels-other group. As with Bracket, the remainder of the entry is
+// cancelled once it starts to fill. Legs with zero quantity are sized to the
+// entry's filled quantity.
+func (e *Engine) AttachBracket(venue, venueOrdId, asset, baseAsset string, legs ...Leg) (*Order, error) {
+	o := &Order{Asset: asset, BaseAsset: baseAsset, EntryVenue: strings.ToUpper(venue), EntryOrderId: venueOrdId, State: StatePending}
+	for i := range legs {
+		o.Legs = append(o.Legs, &legs[i])
+	}
+	return e.add(o)
+}
+
+// Function Cancel stops watching an order. Entry orders and submitted legs are
+// not cancelled.
+func (e *Engine) Cancel(o *Order) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if o.State == StatePending || o.State == StateActive {
+		o.State = StateCancelled
+	}
+}
+
+// Function Orders returns every order, including finished ones. Orders are
+// updated by Step, so they should be read between steps or from OnTrigger.
+func (e *Engine) Orders() []*Order {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]*Order(nil), e.orders...)
+}
+
+// Function Active returns the number of orders still pending or active.
+func (e *Engine) Active() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	n := 0
+	for _, o := range e.orders {
+		if o.State == StatePending || o.State == StateActive {
+			n++
+		}
+	}
+	return n
+}
+
+// Function Step polls entry orders, fetches the book of every pair with an
+// active order and submits the legs whose conditions are met. The engine's lock
+// is not held across API calls or OnTrigger, so OnTrigger may call the engine.
+// The first error is returned after every order has been processed.
+func (e *Engine) Step() error {
+	var firstErr error
+	note := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	e.lock.Lock()
+	var entries []*Order
+	for _, o := range e.orders {
+		if (o.State == StatePending || o.State == StateActive) && o.EntryOrderId != "" && !o.entryClosed {
+			entries = append(entries, o)
+		}
+	}
+	e.lock.Unlock()
+	for _, o := range entries {
+		note(e.pollEntry(o))
+	}
+
+	e.lock.Lock()
+	var pairs [][2]string
+	seen := map[[2]string]bool{}
+	for _, o := range e.orders {
+		pair := [2]string{o.Asset, o.BaseAsset}
+		if o.State == StateActive && !seen[pair] {
+			seen[pair] = true
+			pairs = append(pairs, pair)
+		}
+	}
+	e.lock.Unlock()
+	books := map[[2]string]*routefire.DmaOrderBook{}
+	for _, pair := range pairs {
+		resp, err := e.api.GetConsolidatedOrderBookDMA(e.userId, pair[0], pair[1])
+		if err == nil {
+			err = routefire.FirstDmaError(resp.Errors)
+		}
+		if err != nil {
+			note(err)
+			continue
+		}
+		books[pair] = &resp.Data
+	}
+
+	e.lock.Lock()
+	var fired []*trigger
+	for _, o := range e.orders {
+		ob, ok := books[[2]string{o.Asset, o.BaseAsset}]
+		if o.State != StateActive || !ok {
+			continue
+		}
+		t, err := e.evaluate(o, ob)
+		note(err)
+		if t != nil {
+			fired = append(fired, t)
+		}
+	}
+	e.lock.Unlock()
+
+	for _, t := range fired {
+		orderId, venue, err := e.submit(t)
+		e.lock.Lock()
+		t.leg.OrderId, t.leg.SubmittedTo, t.leg.Err = orderId, venue, err
+		if err != nil {
+			t.order.State = StateFailed
+		}
+		e.lock.Unlock()
+		if e.OnTrigger != nil {
+			e.OnTrigger(t.order, t.leg)
+		}
+		note(err)
+	}
+	return firstErr
+}
+
+// pollEntry books the entry's fills while it is working. A bracket becomes
+// active once its entry has filled, and is cancelled if the entry closes
+// unfilled. When the exits arm, the rest of the entry is cancelled, so that
+// every entry fill is covered by exits of the same size.
+func (e *Engine) pollEntry(o *Order) error {
+	st, err := e.entryStatus(o)
+	if err != nil {
+		return err
+	}
+	closed := !routefire.IsOpenStatus(st.Status)
+	filled, _ := strconv.ParseFloat(st.FilledAmount, 64)
+	e.lock.Lock()
+	arm := o.State == StatePending && !closed && filled > 0
+	e.lock.Unlock()
+	if arm {
+		resp, err := e.api.CancelOrderDMA(e.userId, o.EntryVenue, o.EntryOrderId)
+		if err == nil {
+			err = routefire.FirstDmaError(resp.Errors)
+		}
+		if err != nil {
+			return fmt.Errorf("conditional: cancel entry %s: %s", o.EntryOrderId, err)
+		}
+		// Pick up any fills that raced with the cancel.
+		if st, err = e.entryStatus(o); err != nil {
+			return err
+		}
+		closed = true
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if filled, err := strconv.ParseFloat(st.FilledAmount, 64); err == nil {
+		o.EntryFilled = filled
+	}
+	o.entryClosed = closed
+	switch {
+	case o.State != StatePending:
+	case o.EntryFilled > 0:
+		o.State = StateActive
+	case o.entryClosed:
+		o.State = StateCancelled
+	}
+	return nil
+}
+
+func (e *Engine) entryStatus(o *Order) (*routefire.DmaOrderStatusResponse, error) {
+	st, err := e.api.OrderStatusDMA(e.userId, o.EntryVenue, o.EntryOrderId)
+	if err == nil {
+		err = routefire.FirstDmaError(st.Errors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Type trigger is a leg that has fired and is waiting to be submitted.
+type trigger struct {
+	order *Order
+	leg   *Leg
+	px    float64
+	venue string // Venue quoting px
+	qty   float64
+}
+
+// evaluate fires the first leg of an order whose condition is met, marking the
+// order triggered. It is called with the engine's lock held; the returned leg
+// is submitted after the lock is released.
+func (e *Engine) evaluate(o *Order, ob *routefire.DmaOrderBook) (*trigger, error) {
+	for _, l := range o.Legs {
+		var entry routefire.DmaOrderBookEntry
+		var err error
+		if l.Side == routefire.SideSell {
+			entry, err = ob.BestBid()
+		} else {
+			entry, err = ob.BestOffer()
+		}
+		if err != nil {
+			return nil, err
+		}
+		px, _, err := entry.Floats()
+		if err != nil {
+			return nil, err
+		}
+		if !l.triggered(px) {
+			continue
+		}
+		l.Triggered, l.TriggeredAt = e.clock.Now(), px
+		o.Fired, o.State = l, StateTriggered
+		qty := l.Quantity
+		if qty == 0 {
+			qty = o.EntryFilled
+		}
+		return &trigger{order: o, leg: l, px: px, venue: entry.Venue, qty: qty}, nil
+	}
+	return nil, nil
+}
+
+// submit sends a triggered leg and returns its order ID and, for a DMA order,
+// its venue.
+func (e *Engine) submit(t *trigger) (string, string, error) {
+	o, l := t.order, t.leg
+	if routefire.RoundQuantity(o.Asset, t.qty) <= 0 {
+		return "", "", fmt.Errorf("conditional: quantity %s rounds to zero", routefire.FormatFloat(t.qty))
+	}
+	qty := routefire.FormatQuantity(o.Asset, t.qty)
+	price := l.Price
+	if price == 0 {
+		if l.Side == routefire.SideBuy {
+			price = t.px * (1 + e.Slippage)
+		} else {
+			price = t.px * (1 - e.Slippage)
+		}
+	}
+	if l.Algo != "" {
+		buy, sell := o.Asset, o.BaseAsset
+		if l.Side == routefire.SideSell {
+			buy, sell = sell, buy
+		}
+		params := map[string]string{}
+		for k, v := range l.AlgoParams {
+			params[k] = v
+		}
+		params["iwould"] = routefire.FormatFloat(price)
+		resp, err := e.api.(routefire.API).SubmitOrder(e.userId, buy, sell, qty, "", l.Algo, params)
+		if err != nil {
+			return "", "", err
+		}
+		if resp.OrderId == "" {
+			return "", "", fmt.Errorf("conditional: %s order not accepted", l.Algo)
+		}
+		return resp.OrderId, "", nil
+	}
+	venue := l.Venue
+	if venue == "" {
+		venue = t.venue
+	}
+	resp, err := e.api.SubmitOrderDMA(e.userId, venue, o.Asset, o.BaseAsset, l.Side,
+		qty, routefire.FormatFloat(price), nil)
+	if err == nil {
+		err = routefire.FirstDmaError(resp.Errors)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return resp.VenueOrderId, venue, nil
+}
+
+// Function Run steps the engine every Interval until the clock stops.
+func (e *Engine) Run() {
+	for {
+		e.Step()
+		if !e.clock.Wait(e.Interval) {
+			return
+		}
+	}
+}
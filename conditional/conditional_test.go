@@ -0,0 +1,196 @@
+package conditional
+
+import (
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+)
+
+const uid = "conditional@example.com"
+
+// testEngine replays one snapshot per second with the given best bids, each
+// with an offer one above it.
+func testEngine(bids ...float64) *backtest.Engine {
+	var snaps []backtest.Snapshot
+	for i, bid := range bids {
+		snaps = append(snaps, backtest.Snapshot{
+			Time:      time.Date(2019, 6, 1, 12, 0, i, 0, time.UTC),
+			Asset:     routefire.Btc,
+			BaseAsset: routefire.Usd,
+			Book: routefire.DmaOrderBook{
+				Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: routefire.FormatFloat(bid), Amount: "10"}},
+				Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: routefire.FormatFloat(bid + 1), Amount: "10"}},
+			},
+		})
+	}
+	bt := backtest.New(snaps, backtest.Config{})
+	bt.Step()
+	return bt
+}
+
+func TestBracket(t *testing.T) {
+	bt := testEngine(99, 101, 104, 105, 94)
+	e := New(bt, bt, uid)
+	var fired []*Leg
+	e.OnTrigger = func(o *Order, l *Leg) { fired = append(fired, l) }
+
+	o, err := e.Bracket(routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, 1, 100, 105, 95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.State != StatePending {
+		t.Errorf("bracket should wait for its entry, got %s", o.State)
+	}
+	e.Run()
+
+	if o.State != StateTriggered || o.Fired != o.Legs[0] || len(fired) != 1 || o.EntryFilled != 1 {
+		t.Fatalf("expected the take profit to fire once, got %s %+v", o.State, fired)
+	}
+	if tp := o.Legs[0]; tp.TriggeredAt != 105 || tp.OrderId == "" {
+		t.Errorf("unexpected take profit %+v", tp)
+	}
+	exit, err := bt.Exchange().Order(routefire.Gemini, o.Fired.OrderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exit.Side != routefire.SideSell || exit.Quantity != 1 || exit.Price != 105*(1-e.Slippage) {
+		t.Errorf("unexpected exit order %+v", exit)
+	}
+}
+
+func TestBracketCancelsEntryRemainder(t *testing.T) {
+	var snaps []backtest.Snapshot
+	for i, q := range [][3]string{{"98", "101", "10"}, {"98", "100", "1"}, {"98", "99", "10"}, {"105", "106", "10"}} {
+		snaps = append(snaps, backtest.Snapshot{
+			Time:      time.Date(2019, 6, 1, 12, 0, i, 0, time.UTC),
+			Asset:     routefire.Btc,
+			BaseAsset: routefire.Usd,
+			Book: routefire.DmaOrderBook{
+				Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: q[0], Amount: "10"}},
+				Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: q[1], Amount: q[2]}},
+			},
+		})
+	}
+	bt := backtest.New(snaps, backtest.Config{})
+	bt.Step()
+	e := New(bt, bt, uid)
+
+	o, err := e.Bracket(routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, 3, 100, 105, 95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryId := o.EntryOrderId
+	e.Run()
+
+	entry, _ := bt.Exchange().Order(routefire.Gemini, entryId)
+	if entry.Filled != 1 || entry.IsOpen() || o.EntryFilled != 1 {
+		t.Errorf("the entry should be cancelled after its first fill, got %+v", entry)
+	}
+	if o.State != StateTriggered || o.Fired != o.Legs[0] {
+		t.Fatalf("expected the take profit to fire, got %s", o.State)
+	}
+	if exit, _ := bt.Exchange().Order(routefire.Gemini, o.Fired.OrderId); exit.Quantity != 1 {
+		t.Errorf("the exit should cover the filled entry, got %+v", exit)
+	}
+}
+
+func TestTrailingStop(t *testing.T) {
+	bt := testEngine(100, 103, 104, 102.5, 101.9, 90)
+	e := New(bt, bt, uid)
+	o, err := e.Conditional(routefire.Btc, routefire.Usd, Leg{Type: TrailingStop, Side: routefire.SideSell, Quantity: 2, Trail: 2, Price: 101})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Run()
+	l := o.Legs[0]
+	if o.State != StateTriggered || l.Trigger != 102 || l.TriggeredAt != 101.9 || l.SubmittedTo != routefire.Gemini {
+		t.Errorf("unexpected trailing stop %s %+v", o.State, l)
+	}
+	if e.Active() != 0 {
+		t.Errorf("no orders should be active")
+	}
+}
+
+func TestLegQuantityRounded(t *testing.T) {
+	bt := testEngine(100, 97)
+	e := New(bt, bt, uid)
+	o, err := e.Conditional(routefire.Btc, routefire.Usd, Leg{Type: Stop, Side: routefire.SideSell, Quantity: 2.0 / 3, Trigger: 98})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Run()
+	if exit, _ := bt.Exchange().Order(routefire.Gemini, o.Legs[0].OrderId); exit.Quantity != 0.66666666 {
+		t.Errorf("expected the stop rounded to 0.66666666, got %+v", exit)
+	}
+}
+
+// noVenue accepts orders without echoing their venue.
+type noVenue struct {
+	*backtest.Engine
+}
+
+func (v noVenue) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	resp, err := v.Engine.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	if resp != nil {
+		resp.VenueId = ""
+	}
+	return resp, err
+}
+
+func TestLegSubmittedVenue(t *testing.T) {
+	bt := testEngine(100, 97)
+	e := New(noVenue{bt}, bt, uid)
+	o, err := e.Conditional(routefire.Btc, routefire.Usd, Leg{Type: Stop, Side: routefire.SideSell, Quantity: 1, Trigger: 98})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Run()
+	if l := o.Legs[0]; o.State != StateTriggered || l.SubmittedTo != routefire.Gemini {
+		t.Errorf("expected the stop submitted to %s, got %s %+v", routefire.Gemini, o.State, l)
+	}
+}
+
+func TestOnTriggerCanCallEngine(t *testing.T) {
+	bt := testEngine(100, 97, 96)
+	e := New(bt, bt, uid)
+	o, err := e.OCO(routefire.Btc, routefire.Usd,
+		Leg{Type: Stop, Side: routefire.SideSell, Quantity: 1, Trigger: 98},
+		Leg{Type: TakeProfit, Side: routefire.SideSell, Quantity: 1, Trigger: 110})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := e.Conditional(routefire.Btc, routefire.Usd, Leg{Type: Stop, Side: routefire.SideSell, Quantity: 1, Trigger: 90})
+	active := -1
+	e.OnTrigger = func(fired *Order, l *Leg) {
+		active = e.Active()
+		e.Cancel(other)
+	}
+	e.Run()
+	if o.State != StateTriggered || active != 1 || other.State != StateCancelled {
+		t.Errorf("unexpected states %s %s, %d active in OnTrigger", o.State, other.State, active)
+	}
+}
+
+func TestOCOAndValidation(t *testing.T) {
+	bt := testEngine(100, 97)
+	e := New(bt, bt, uid)
+	o, err := e.OCO(routefire.Btc, routefire.Usd,
+		Leg{Type: TakeProfit, Side: routefire.SideSell, Quantity: 1, Trigger: 110},
+		Leg{Type: Stop, Side: routefire.SideSell, Quantity: 1, Trigger: 98})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Run()
+	if o.Fired != o.Legs[1] || o.Legs[0].OrderId != "" {
+		t.Errorf("only the stop should fire, got %+v", o.Fired)
+	}
+
+	if _, err := e.Conditional(routefire.Btc, routefire.Usd, Leg{Type: Stop, Side: routefire.SideSell, Quantity: 1}); err != ErrInvalidLeg {
+		t.Errorf("expected ErrInvalidLeg, got %v", err)
+	}
+	if _, err := e.Conditional(routefire.Btc, routefire.Usd, Leg{Type: Stop, Side: routefire.SideSell, Quantity: 1, Trigger: 90, Algo: "rfxw"}); err != routefire.ErrNoAlgoAPI {
+		t.Errorf("expected ErrNoAlgoAPI, got %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+// Package deadman provides a dead man's switch. A Watchdog wraps a kill switch,
+// recording the outcome of every call made through it. If no call succeeds for
+// a silence window, submissions are blocked and, as soon as connectivity
+// returns, every open order is cancelled. Open orders can be journaled to disk
+// so that orders left open by a crashed process are cancelled on the next start.
+package deadman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/killswitch"
+)
+
+var ErrExpired = errors.New("deadman: connectivity lost, submissions are blocked")
+
+// Type Config configures a Watchdog.
+type Config struct {
+	// Window is the silence after which open orders are cancelled. Defaults to
+	// 30 seconds.
+	Window time.Duration
+	// Interval is how often the watchdog checks for silence. Defaults to a
+	// fifth of Window.
+	Interval time.Duration
+	// Ping, if set, is called when no call has succeeded for Interval, so an
+	// idle process is not mistaken for a disconnected one, and to detect when
+	// connectivity returns. A BalanceDMA request is a good choice.
+	Ping func() error
+	// Journal, if set, is a file where the open orders are kept.
+	Journal string
+	// Logger receives watchdog diagnostics; nil uses the standard logger.
+	Logger *log.Logger
+}
+
+// Type Watchdog is a dead man's switch. It implements routefire.API by
+// forwarding calls to its kill switch.
+type Watchdog struct {
+	sw  *killswitch.Switch
+	cfg Config
+	now func() time.Time
+
+	lock      sync.Mutex
+	lastOK    time.Time
+	lastErr   error
+	expiredAt time.Time
+
+	// persistLock serializes journal writes, so a snapshot of the open orders
+	// is never written over a newer one.
+	persistLock sync.Mutex
+	journaled   []killswitch.OrderRef
+
+	// OnExpire, if set, is called when the silence window elapses.
+	OnExpire func(silence time.Duration, lastErr error)
+	// OnTrigger, if set, is called with the report of the cancellation once
+	// connectivity returns.
+	OnTrigger func(*killswitch.Report)
+}
+
+// Function New creates a watchdog over a kill switch. Orders placed through the
+// watchdog are tracked by the switch. When the watchdog fires, the switch is
+// tripped and stays tripped until it is re-armed.
+func New(sw *killswitch.Switch, cfg Config) *Watchdog {
+	if cfg.Window <= 0 {
+		cfg.Window = 30 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = cfg.Window / 5
+	}
+	return &Watchdog{sw: sw, cfg: cfg, now: time.Now, lastOK: time.Now()}
+}
+
+// Function Switch returns the kill switch.
+func (w *Watchdog) Switch() *killswitch.Switch {
+	return w.sw
+}
+
+// Function Heartbeat records that the process is alive and connected.
+func (w *Watchdog) Heartbeat() {
+	w.record(nil)
+}
+
+// Function WatchAuth records the outcome of a client's authentication token
+// refreshes.
+func (w *Watchdog) WatchAuth(c *routefire.Client) {
+	c.OnAuth(w.record)
+}
+
+// Function Status returns the time of the last successful call and the last
+// error, and whether the silence window has elapsed.
+func (w *Watchdog) Status() (lastOK time.Time, lastErr error, expired bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.lastOK, w.lastErr, !w.expiredAt.IsZero()
+}
+
+// record notes the outcome of a call. Errors produced locally by the kill
+// switch or the watchdog say nothing about connectivity and are ignored.
+func (w *Watchdog) record(err error) {
+	switch err {
+	case killswitch.ErrTripped, routefire.ErrNoAlgoAPI, ErrExpired:
+		return
+	}
+	w.lock.Lock()
+	if err == nil {
+		w.lastOK = w.now()
+	} else {
+		w.lastErr = err
+	}
+	w.lock.Unlock()
+}
+
+// Function Run checks for silence every Interval until stop is closed.
+func (w *Watchdog) Run(stop <-chan struct{}) {
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (w *Watchdog) tick() {
+	w.lock.Lock()
+	idle := w.now().Sub(w.lastOK)
+	expired := !w.expiredAt.IsZero()
+	w.lock.Unlock()
+	if w.cfg.Ping != nil && (expired || idle >= w.cfg.Interval) {
+		w.record(w.cfg.Ping())
+	}
+
+	w.lock.Lock()
+	now := w.now()
+	silence, lastErr := now.Sub(w.lastOK), w.lastErr
+	expiring := w.expiredAt.IsZero() && silence > w.cfg.Window
+	if expiring {
+		w.expiredAt = now
+	}
+	recovered := !w.expiredAt.IsZero() && w.lastOK.After(w.expiredAt)
+	w.lock.Unlock()
+
+	if expiring {
+		w.logf("no successful call for %s (last error: %v); blocking submissions", silence, lastErr)
+		if w.OnExpire != nil {
+			w.OnExpire(silence, lastErr)
+		}
+	}
+	if recovered {
+		rep := w.sw.Trip(fmt.Sprintf("dead man's switch: connectivity lost at %s", w.expiredAt.Format(time.RFC3339)))
+		w.logf("%s", rep)
+		if rep.OK() {
+			w.lock.Lock()
+			w.expiredAt = time.Time{}
+			w.lock.Unlock()
+		}
+		if w.OnTrigger != nil {
+			w.OnTrigger(rep)
+		}
+	}
+	w.persist()
+}
+
+func (w *Watchdog) logf(format string, args ...interface{}) {
+	if w.cfg.Logger != nil {
+		w.cfg.Logger.Printf(format, args...)
+	} else {
+		log.Printf("DEADMAN> "+format, args...)
+	}
+}
+
+// persist writes the open orders to the journal if they have changed.
+func (w *Watchdog) persist() {
+	if w.cfg.Journal == "" {
+		return
+	}
+	w.persistLock.Lock()
+	defer w.persistLock.Unlock()
+	orders := w.sw.Orders()
+	if equalRefs(orders, w.journaled) {
+		return
+	}
+	if err := writeJournal(w.cfg.Journal, orders); err != nil {
+		w.logf("writing journal: %v", err)
+		return
+	}
+	w.journaled = orders
+}
+
+func equalRefs(a, b []killswitch.OrderRef) bool {
+	if len(a) != len(b) || (a == nil) != (b == nil) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeJournal replaces the journal atomically, so a crash mid-write leaves the
+// previous journal intact.
+func writeJournal(path string, orders []killswitch.OrderRef) error {
+	bs, err := json.Marshal(orders)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Function Recover cancels the orders recorded as open in a journal by a
+// previous run, through the kill switch, and re-arms the switch. Orders that
+// could not be cancelled remain tracked and in the journal. A missing journal
+// is not an error; the report is nil.
+func Recover(sw *killswitch.Switch, journal string) (*killswitch.Report, error) {
+	bs, err := ioutil.ReadFile(journal)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var orders []killswitch.OrderRef
+	if err := json.Unmarshal(bs, &orders); err != nil {
+		return nil, fmt.Errorf("deadman: reading journal: %v", err)
+	}
+	for _, o := range orders {
+		if o.Venue == "" {
+			sw.TrackAlgo(o.OrderId)
+		} else {
+			sw.Track(o.Venue, o.OrderId)
+		}
+	}
+	rep := sw.Trip("orders left open by a previous run")
+	sw.Rearm()
+	return rep, writeJournal(journal, sw.Orders())
+}
+
+//
+//  Watched calls
+//
+
+func (w *Watchdog) blocked() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.expiredAt.IsZero() {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (w *Watchdog) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	if err := w.blocked(); err != nil {
+		return nil, err
+	}
+	resp, err := w.sw.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	w.record(err)
+	w.persist()
+	return resp, err
+}
+
+func (w *Watchdog) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if err := w.blocked(); err != nil {
+		return nil, err
+	}
+	resp, err := w.sw.SubmitOrder(userId, buyAsset, sellAsset, quantity, price, algo, algoParams)
+	w.record(err)
+	w.persist()
+	return resp, err
+}
+
+func (w *Watchdog) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	st, err := w.sw.OrderStatusDMA(userId, venue, venueOrdId)
+	w.record(err)
+	w.persist()
+	return st, err
+}
+
+func (w *Watchdog) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	resp, err := w.sw.CancelOrderDMA(userId, venue, venueOrdId)
+	w.record(err)
+	w.persist()
+	return resp, err
+}
+
+func (w *Watchdog) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	st, err := w.sw.GetOrderStatus(userId, orderId)
+	w.record(err)
+	w.persist()
+	return st, err
+}
+
+func (w *Watchdog) CancelOrder(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	st, err := w.sw.CancelOrder(userId, orderId)
+	w.record(err)
+	w.persist()
+	return st, err
+}
+
+func (w *Watchdog) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	ob, err := w.sw.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+	w.record(err)
+	return ob, err
+}
+
+func (w *Watchdog) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	b, err := w.sw.BalanceDMA(userId, venue, assetId)
+	w.record(err)
+	return b, err
+}
+
+func (w *Watchdog) GetBalances(uid, asset string) (map[string]string, error) {
+	b, err := w.sw.GetBalances(uid, asset)
+	w.record(err)
+	return b, err
+}
+
+func (w *Watchdog) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*routefire.InquiryResponse, error) {
+	s, err := w.sw.GetOrderBookStats(uid, buyAsset, sellAsset, quantity)
+	w.record(err)
+	return s, err
+}
+
+func (w *Watchdog) GetConsolidatedOrderBook(uid, buyAsset, sellAsset string) (*routefire.OrderBookResponse, error) {
+	ob, err := w.sw.GetConsolidatedOrderBook(uid, buyAsset, sellAsset)
+	w.record(err)
+	return ob, err
+}
+
+var _ routefire.API = (*Watchdog)(nil)
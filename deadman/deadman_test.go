@@ -0,0 +1,178 @@
+package deadman
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/killswitch"
+)
+
+const uid = "deadman@example.com"
+
+// flakyNet fails every call while the network is down.
+type flakyNet struct {
+	routefire.DMA
+	down bool
+}
+
+var errNetwork = errors.New("network unreachable")
+
+func (n *flakyNet) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	if n.down {
+		return nil, errNetwork
+	}
+	return n.DMA.CancelOrderDMA(userId, venue, venueOrdId)
+}
+
+func (n *flakyNet) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	if n.down {
+		return nil, errNetwork
+	}
+	return n.DMA.BalanceDMA(userId, venue, assetId)
+}
+
+func testEngine() *backtest.Engine {
+	bt := backtest.New([]backtest.Snapshot{{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "99", Amount: "5"}},
+			Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "101", Amount: "5"}},
+		},
+	}}, backtest.Config{})
+	bt.Step()
+	return bt
+}
+
+func openOrders(bt *backtest.Engine) int {
+	n := 0
+	for _, o := range bt.Exchange().Orders() {
+		if o.IsOpen() {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCancelWhenConnectivityReturns(t *testing.T) {
+	bt := testEngine()
+	net := &flakyNet{DMA: bt}
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	w := New(killswitch.NewDMA(net, uid), Config{
+		Window:   5 * time.Second,
+		Interval: time.Second,
+		Ping: func() error {
+			_, err := net.BalanceDMA(uid, routefire.Gemini, routefire.Usd)
+			return err
+		},
+		Logger: log.New(ioutil.Discard, "", 0),
+	})
+	w.now = func() time.Time { return now }
+	w.lastOK = now
+	var triggered *killswitch.Report
+	w.OnTrigger = func(r *killswitch.Report) { triggered = r }
+
+	if _, err := w.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "95", nil); err != nil {
+		t.Fatal(err)
+	}
+	net.down = true
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		w.tick()
+	}
+	if _, err, expired := w.Status(); !expired || err != errNetwork {
+		t.Fatalf("expected the watchdog to expire, got %v %v", expired, err)
+	}
+	if _, err := w.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "95", nil); err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+	if triggered != nil || openOrders(bt) != 1 {
+		t.Errorf("nothing should be cancelled while disconnected")
+	}
+
+	net.down = false
+	now = now.Add(time.Second)
+	w.tick()
+	if triggered == nil || !triggered.OK() || len(triggered.Cancelled) != 1 || openOrders(bt) != 0 {
+		t.Errorf("expected the order to be cancelled on reconnection, got %v", triggered)
+	}
+	if _, _, expired := w.Status(); expired {
+		t.Errorf("watchdog should reset after a successful cancellation")
+	}
+	if tripped, _ := w.Switch().Tripped(); !tripped {
+		t.Errorf("kill switch should stay tripped until re-armed")
+	}
+}
+
+func TestRecoverFromJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "orders.json")
+
+	if rep, err := Recover(killswitch.NewDMA(testEngine(), uid), journal); rep != nil || err != nil {
+		t.Errorf("a missing journal should be ignored, got %v %v", rep, err)
+	}
+
+	bt := testEngine()
+	w := New(killswitch.NewDMA(bt, uid), Config{Journal: journal})
+	for _, px := range []string{"95", "96"} {
+		if _, err := w.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", px, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The process dies; the next run cancels what it left behind.
+	sw := killswitch.NewDMA(bt, uid)
+	rep, err := Recover(sw, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Cancelled) != 2 || openOrders(bt) != 0 {
+		t.Errorf("expected both orders to be cancelled, got %v", rep)
+	}
+	if tripped, _ := sw.Tripped(); tripped {
+		t.Errorf("switch should be re-armed after recovery")
+	}
+	if bs, _ := ioutil.ReadFile(journal); string(bs) != "[]" {
+		t.Errorf("journal should be empty, got %s", bs)
+	}
+}
+
+func TestJournalConcurrentSubmits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "orders.json")
+
+	bt := testEngine()
+	w := New(killswitch.NewDMA(bt, uid), Config{Journal: journal})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "0.1", "90", nil)
+		}()
+	}
+	wg.Wait()
+
+	// The last write holds every open order.
+	rep, err := Recover(killswitch.NewDMA(bt, uid), journal)
+	if err != nil || len(rep.Cancelled) != 20 || openOrders(bt) != 0 {
+		t.Errorf("expected all 20 orders in the journal, got %v %v", rep, err)
+	}
+}
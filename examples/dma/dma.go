@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
 	"os"
 	"strconv"
 	"time"
@@ -50,8 +51,12 @@ func main() {
 	ourPx := calcMidPrice(obData, -0.01)
 
 	// Submit an order to buy at the best-offered venue at 0.01 less than the mid price from
-	// the _consolidated_ order book.
-	bestOffer := obData.Data.Offers[0] // They are sorted from lowest to highest.
+	// the _consolidated_ order book. Venues are ranked by offer price after taker fees, since
+	// the lowest quoted offer is not always the cheapest once fees are paid.
+	bestOffer, _, err := fees.Default().BestOffer(&obData.Data)
+	if err != nil {
+		panic(err)
+	}
 	bestVenue := bestOffer.Venue
 
 	fmt.Printf("Submitting to venue %s at price level %s\n", bestVenue, ourPx)
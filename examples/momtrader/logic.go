@@ -1,21 +1,23 @@
 package main
 
 import (
-	"errors"
-	"fmt"
-	"github.com/routefire/go-routefire"
-	"log"
 	"math"
-	"strconv"
-	"sync"
 	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/indicators"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+const (
+	DevelopmentExecutionSafety = false
+	orderTTL                   = 60 * time.Second
 )
 
 type position struct {
 	asset string
 	venue string
-	size  string
-	price string
+	size  float64
 }
 
 type momentumParams struct {
@@ -24,327 +26,167 @@ type momentumParams struct {
 	minStdDevBuy  float64
 }
 
-type order struct {
-	order      *routefire.PlaceDmaOrderResponse
-	isFilled   bool
-	isComplete bool
-}
-
+// MomentumTrader buys whichever asset has gained the most over the last few
+// periods, provided its latest ask is far enough above its recent average, and
+// rotates out of the previous holding when the leader changes.
 type MomentumTrader struct {
-	UserId        string
-	Assets        []string
-	BaseAsset     string
-	Alpha         float64
-	Positions     []position
-	Orders        []*order
-	BidHistory    map[string][]float64
-	AskHistory    map[string][]float64
-	LastOrderBook map[string]*routefire.DmaOrderBookResponse
-	RfClient      *routefire.Client
-	Capital       float64
-	params        *momentumParams
-	lock          *sync.Mutex
-}
-
-func NewMomentumTrader(uid string, rfClient *routefire.Client, assets []string, baseAsset string, capital, alpha float64) *MomentumTrader {
-	m1 := map[string][]float64{}
-	m2 := map[string][]float64{}
-	obm := map[string]*routefire.DmaOrderBookResponse{}
-	return &MomentumTrader{
-		UserId:        uid,
-		Assets:        assets,
-		BaseAsset:     baseAsset,
-		Alpha:         alpha,
-		Positions:     nil,
-		Orders:        nil,
-		LastOrderBook: obm,
-		BidHistory:    m1,
-		AskHistory:    m2,
-		Capital:       capital,
-		RfClient:      rfClient,
+	strategy.Base
+	Assets    []string
+	BaseAsset string
+	Capital   float64
+	params    *momentumParams
+	gains     map[string]*indicators.ROC
+	sigmas    map[string]*indicators.ZScore
+	position  *position
+}
+
+func NewMomentumTrader(assets []string, baseAsset string, capital, alpha float64) *MomentumTrader {
+	m := &MomentumTrader{
+		Assets:    assets,
+		BaseAsset: baseAsset,
+		Capital:   capital,
 		params: &momentumParams{
 			gainerPeriods: 5,
-			stdDevPeriods: 15,
+			stdDevPeriods: 10,
 			minStdDevBuy:  alpha,
 		},
-		lock: &sync.Mutex{},
+		gains:  map[string]*indicators.ROC{},
+		sigmas: map[string]*indicators.ZScore{},
 	}
+	for _, asset := range assets {
+		m.gains[asset] = indicators.NewROC(m.params.gainerPeriods - 1)
+		m.sigmas[asset] = indicators.NewZScore(m.params.stdDevPeriods)
+	}
+	return m
 }
 
-func (m *MomentumTrader) RunLoop(dur time.Duration) {
-	for {
-		err := m.Trade()
-		if err != nil {
-			m.log("Trade() error: %s", err.Error())
-		}
-		time.Sleep(dur)
+// Pairs returns the markets the trader needs books for.
+func (m *MomentumTrader) Pairs() []strategy.Pair {
+	var pairs []strategy.Pair
+	for _, asset := range m.Assets {
+		pairs = append(pairs, strategy.Pair{Asset: asset, BaseAsset: m.BaseAsset})
 	}
+	return pairs
 }
-func (m *MomentumTrader) collectData() error {
-	for _, asset := range m.Assets {
-		ob, err := m.RfClient.GetConsolidatedOrderBookDMA(m.UserId, asset, m.BaseAsset)
-		if err != nil {
-			return err
-		} else {
-			m.LastOrderBook[asset] = ob
-		}
-		bestOff := ob.Data.Offers[0]
-		bestBid := ob.Data.Bids[len(ob.Data.Bids)-1]
 
-		bestOffF, err := strconv.ParseFloat(bestOff.Price, 64)
-		bestBidF, err := strconv.ParseFloat(bestBid.Price, 64)
+func (m *MomentumTrader) OnBook(ctx *strategy.Context, pair strategy.Pair, ob *routefire.DmaOrderBook) {
+	bestOff, err := ob.BestOffer()
+	if err != nil {
+		return
+	}
+	px, _, err := bestOff.Floats()
+	if err != nil {
+		return
+	}
+	m.gains[pair.Asset].Update(px)
+	m.sigmas[pair.Asset].Update(px)
 
-		m.BidHistory[asset] = append(m.BidHistory[asset], bestBidF)
-		m.AskHistory[asset] = append(m.AskHistory[asset], bestOffF)
+	// Books arrive in configured order; trade once every asset has updated.
+	if pair.Asset == m.Assets[len(m.Assets)-1] {
+		m.trade(ctx)
 	}
-	return nil
 }
 
-func (m *MomentumTrader) gainOver(asset string, nPeriods int) (float64, error) {
-	if arr, ok := m.AskHistory[asset]; ok {
-		if len(arr) < nPeriods {
-			return 0.0, errors.New("InsufficientData")
-		} else {
-			p0 := arr[len(arr)-nPeriods]
-			p1 := arr[len(arr)-1]
-			gain := (p1 / p0) - 1.0
-			return gain, nil
-		}
+func (m *MomentumTrader) trade(ctx *strategy.Context) {
+	if len(ctx.OpenOrders()) > 0 {
+		ctx.Logf("Skipping iteration, waiting on execution...")
+		return
 	}
-	return 0.0, errors.New("InsufficientData")
-}
 
-func (m *MomentumTrader) biggestGainerOver(nPeriods int) (string, float64, error) {
-	bestYet := -999999.99
-	bestYetAsst := ""
+	winner := ""
+	bestYet := math.Inf(-1)
 	for _, asset := range m.Assets {
-		g, err := m.gainOver(asset, nPeriods)
-		if err != nil {
-			continue
-		}
-		if g > bestYet {
-			bestYet = g
-			bestYetAsst = asset
+		if g := m.gains[asset]; g.Ready() {
+			ctx.Logf("\tGains: %s gained %f...", asset, g.Value()/100)
+			if g.Value() > bestYet {
+				winner, bestYet = asset, g.Value()
+			}
 		}
-		m.log("\tGains: %s gained %f...", asset, g)
 	}
-	if len(bestYetAsst) == 0 {
-		return "", 0.0, errors.New("InsufficientData")
+	if winner == "" || !m.sigmas[winner].Ready() {
+		ctx.Logf("Math/data error: InsufficientData")
+		return
 	}
-	return bestYetAsst, bestYet, nil
-}
 
-func (m *MomentumTrader) stdDevs(asset string, nPeriods int) (float64, error) {
-	if arr0, ok := m.AskHistory[asset]; ok && len(arr0) >= nPeriods {
-		arr := arr0[len(arr0)-nPeriods:]
-		sigma := stdDev(arr)
-		if math.Abs(sigma) < 0.00000001 {
-			return 0.0, nil
-		}
-		mu := mean(arr)
-		last := arr[len(arr)-1]
-		sigmas := (last - mu) / sigma
-		m.log("Std devs for %s: last %f - average %f / sigma %f = %f", asset, last, mu, sigma, sigmas)
-		return sigmas, nil
+	wSds := m.sigmas[winner].Value()
+	ctx.Logf("Winner is %s - at %f SDs", winner, wSds)
+	if wSds < m.params.minStdDevBuy {
+		ctx.Logf("Std dev not met for: %s", winner)
+		return
 	}
-	return 0.0, errors.New("InsufficientData")
-}
-
-func (m *MomentumTrader) Trade() error {
-	m.lock.Lock()
-
-	err := m.collectData()
-	if err != nil {
-		m.log("Data collection error: %s", err.Error())
-		m.lock.Unlock()
-		return err
+	if m.position != nil && m.position.asset == winner {
+		return
 	}
 
-	winner, _, err := m.biggestGainerOver(5)
+	ob, _ := ctx.Book(strategy.Pair{Asset: winner, BaseAsset: m.BaseAsset})
+	bo, err := ob.BestOffer()
 	if err != nil {
-		m.log("Math/data error: %s", err.Error())
-		m.lock.Unlock()
-		return err
+		return
 	}
-
-	wSds, err := m.stdDevs(winner, 10)
-	if err != nil {
-		m.log("Math/data error [2]: %s", err.Error())
-		m.lock.Unlock()
-		return err
-	}
-	m.log("Winner is %s - at %f SDs", winner, wSds)
-
-	if wSds >= m.params.minStdDevBuy {
-		m.log("Buy %s!", winner)
-	} else {
-		m.log("Std dev not met for: %s", winner)
-		m.lock.Unlock()
-		return err
+	// Spend Capital on the best offer's venue, sweeping its offers as deep as
+	// that takes.
+	q, err := routefire.QuoteNotional(ob.ForVenue(bo.Venue), winner, routefire.SideBuy, m.Capital)
+	if (err != nil && err != routefire.ErrNotionalExceedsBook) || q.Quantity <= 0 {
+		return
 	}
-	m.lock.Unlock()
+	size, px := q.Quantity, q.LimitPrice
+	ctx.Logf("Intended positions: %f %s @ %f (%s)", size, winner, px, bo.Venue)
 
-	if m.waitingOnExecution() {
-		m.log("Skipping iteration, waiting on execution...")
-		return nil
+	if DevelopmentExecutionSafety {
+		ctx.Logf("SafetyOn")
+		return
 	}
 
-	bb := m.LastOrderBook[winner].Data.Bids[len(m.LastOrderBook[winner].Data.Bids)-1]
-	bo := m.LastOrderBook[winner].Data.Offers[0]
-
-	px := bo.Price
-	size := m.amountToTradeAt(px)
-	m.log("Intended positions: %s %s @ %s (%s)", size, winner, px, bb.Venue)
-
-	if len(m.Positions) > 0 && m.Positions[0].asset != winner {
+	if m.position != nil {
 		// Sell the old position
-		curPos := m.Positions[0]
-		curVenu := curPos.venue
-		sellPx := m.priceAtVenue(m.Positions[0].asset, curVenu, true)
-		m.log("EXIT %s %s @ %s (%s)", curPos.size, curPos.asset, sellPx, curPos)
-		err := m.doTrade(curPos.asset, curVenu, curPos.size, sellPx, false)
-		if err != nil || DevelopmentExecutionSafety {
-			return err
-		}
-
-		// Do the buy
-		m.log("ENTER %s %s @ %s (%s)", size, winner, px, bo.Venue)
-		err = m.doTrade(winner, bo.Venue, size, px, true)
+		curPos := m.position
+		curBook, _ := ctx.Book(strategy.Pair{Asset: curPos.asset, BaseAsset: m.BaseAsset})
+		bb, err := curBook.BestBid()
 		if err != nil {
-			return err
+			return
 		}
-	} else if len(m.Positions) == 0 {
-		// Do the buy
-		m.log("ENTER[0] %s %s @ %s (%s)", size, winner, px, bo.Venue)
-		err = m.doTrade(winner, bo.Venue, size, px, true)
-		if err != nil {
-			return err
+		sellPx, _, _ := bb.Floats()
+		ctx.Logf("EXIT %f %s @ %f (%s)", curPos.size, curPos.asset, sellPx, curPos.venue)
+		if _, err := m.submit(ctx, curPos.venue, curPos.asset, routefire.SideSell, curPos.size, sellPx); err != nil {
+			ctx.Logf("CRITICAL - Order failed: %s", err.Error())
+			return
 		}
 	}
 
-	return nil
-}
-
-func (m *MomentumTrader) priceAtVenue(asst, venu string, bidSide bool) string {
-	ob, err := m.RfClient.GetConsolidatedOrderBookDMA(m.UserId, routefire.Btc, routefire.Usd)
-	if err != nil {
-		return ""
-	}
-	if bidSide {
-		bids := ob.Data.Bids
-		return bids[0].Price
-	} else {
-		offers := ob.Data.Offers
-		return offers[len(offers)-1].Price
+	// Do the buy
+	ctx.Logf("ENTER %f %s @ %f (%s)", size, winner, px, bo.Venue)
+	if _, err := m.submit(ctx, bo.Venue, winner, routefire.SideBuy, size, px); err != nil {
+		ctx.Logf("CRITICAL - Order failed: %s", err.Error())
 	}
 }
 
-func (m *MomentumTrader) amountToTradeAt(px string) string {
-	pxF, err := strconv.ParseFloat(px, 64)
-	if err != nil {
-		return "0"
-	}
-	amt := m.Capital / pxF
-	amtS := fmt.Sprintf("%.4f", amt)
-	return amtS
+func (m *MomentumTrader) submit(ctx *strategy.Context, venue, asset, side string, qty, px float64) (*strategy.Order, error) {
+	return ctx.Submit(strategy.OrderRequest{
+		Venue:    venue,
+		Pair:     strategy.Pair{Asset: asset, BaseAsset: m.BaseAsset},
+		Side:     side,
+		Quantity: qty,
+		Price:    px,
+		TTL:      orderTTL,
+	})
 }
 
-func (m *MomentumTrader) doTrade(asset, venu, qty, px string, isBuy bool) error {
-	ch := make(chan error, 1)
-
-	res, err := SubmitAndWait(m.UserId, m.RfClient, isBuy, asset, m.BaseAsset, venu, qty, px, ch, 3*time.Second)
-	if err != nil {
-		return err
-	}
-
-	ord := &order{
-		order:      res,
-		isFilled:   false,
-		isComplete: false,
-	}
-	m.Orders = append(m.Orders, ord)
-
-	go func(errCh chan error, asst, size, price, venue string, m0 *MomentumTrader, ordr *order) {
-		mustCancel := false
-		select {
-		case err := <-errCh:
-			if err == nil {
-				if isBuy {
-					// buy...
-					m.Positions = append(m.Positions, position{
-						asset: asset,
-						venue: venue,
-						size:  qty,
-						price: px,
-					})
-				} else {
-					// sell...
-					m0.removePosition(asst, size, price)
-				}
-				m0.setOrderComplete(ordr.order.VenueId, ordr.order.VenueOrderId, true)
-			} else {
-				m0.log("CRITICAL - Order failed: %s", err.Error())
-				m0.setOrderComplete(ordr.order.VenueId, ordr.order.VenueOrderId, false)
-				mustCancel = true
-			}
-		case <-time.NewTicker(60 * time.Second).C:
-			m0.log("CRITICAL - Timed out waiting for trade to finish...")
-			m0.setOrderComplete(ordr.order.VenueId, ordr.order.VenueOrderId, false)
-			mustCancel = true
+func (m *MomentumTrader) OnFill(ctx *strategy.Context, f strategy.Fill) {
+	if f.Order.Side == routefire.SideBuy {
+		if m.position == nil || m.position.asset != f.Order.Pair.Asset {
+			m.position = &position{asset: f.Order.Pair.Asset, venue: f.Order.Venue}
 		}
-		if mustCancel {
-			m.log("Canceling unsuccessful order %s...")
-			_, err := m0.RfClient.CancelOrderDMA(m0.UserId, ord.order.VenueId, ord.order.VenueOrderId)
-			if err != nil {
-				panic(err)
-			}
-		}
-	}(ch, asset, qty, px, venu, m, ord)
-
-	return nil
-}
-
-func (m *MomentumTrader) waitingOnExecution() bool {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for _, x := range m.Orders {
-		if !x.isComplete {
-			return true
+		m.position.size += f.Quantity
+	} else if m.position != nil && m.position.asset == f.Order.Pair.Asset {
+		m.position.size -= f.Quantity
+		if m.position.size <= 0 {
+			m.position = nil
 		}
 	}
-
-	return false
 }
 
-func (m *MomentumTrader) removePosition(asset, qty, px string) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	var newPos []position
-	for _, x := range m.Positions {
-		if !(x.asset == asset && x.price == px && x.size == qty) {
-			newPos = append(newPos, x)
-		}
+func (m *MomentumTrader) OnOrderUpdate(ctx *strategy.Context, o strategy.Order) {
+	if o.Status == routefire.StatusCancelled {
+		ctx.Logf("CRITICAL - Timed out waiting for trade to finish: %s %s", o.ID, o.Pair)
 	}
-	m.Positions = newPos
-}
-
-func (m *MomentumTrader) setOrderComplete(venu, venuOrdId string, filled bool) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for i, x := range m.Orders {
-		if x.order.VenueOrderId == venuOrdId && x.order.VenueId == venu {
-			m.Orders[i].isComplete = true
-			if filled {
-				m.Orders[i].isFilled = true
-			}
-		}
-	}
-}
-
-func (m *MomentumTrader) log(fmtStr string, argArr ...interface{}) {
-	fs := "MT> " + fmtStr + "\n"
-	log.Printf(fs, argArr...)
 }
@@ -4,30 +4,58 @@ import (
 	"flag"
 	"fmt"
 	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/paper"
+	"github.com/routefire/go-routefire/strategy"
 	"os"
 	"time"
 )
 
-
 func printUsage() {
-	fmt.Printf("Usage: ./momtrader -uid username -pass p@ssw0rd\n")
+	fmt.Printf("Usage: ./momtrader -uid username -pass p@ssw0rd [-paper]\n")
+	fmt.Printf("       ./momtrader -backtest books.jsonl\n")
 	os.Exit(1)
 }
 
 // TODO: filter for balances; track PnL and order ID histories (for fees)
 
-func main(){
+func main() {
 
 	// Set up `flag` to accept a username and password via command-line arguments
 	uid := flag.String("uid", "", "username")
 	password := flag.String("pass", "", "password")
+	paperTrade := flag.Bool("paper", false, "simulate orders against live data")
+	backtestFile := flag.String("backtest", "", "replay recorded order books from this file")
 	flag.Parse()
 
-	// Check all the inputs are valid.
-	if uid == nil || password == nil{
-		printUsage()
+	assets := []string{"btc", "eth", "zrx"}
+	trader := NewMomentumTrader(assets, "usd", 40.0, 1.0) // Trade with $40
+	cfg := strategy.Config{
+		UserId:   *uid,
+		Pairs:    trader.Pairs(),
+		Interval: 10 * time.Second,
+	}
+
+	// Backtests need no credentials: the recorded books drive the clock.
+	if len(*backtestFile) > 0 {
+		f, err := os.Open(*backtestFile)
+		if err != nil {
+			panic(err)
+		}
+		snaps, err := backtest.ReadSnapshots(f)
+		f.Close()
+		if err != nil {
+			panic(err)
+		}
+		bt := backtest.New(snaps, backtest.Config{})
+		strategy.New(bt, bt, trader, cfg).Run()
+		r := bt.Report()
+		fmt.Printf("%d trades, PnL %f (fees %f)\n", len(r.Trades), r.PnL(), r.Fees)
 		return
-	} else if len(*uid) == 0 || len(*password) == 0  {
+	}
+
+	// Check all the inputs are valid.
+	if len(*uid) == 0 || len(*password) == 0 {
 		printUsage()
 		return
 	}
@@ -37,9 +65,9 @@ func main(){
 		panic(err)
 	}
 
-	assets := []string{"btc","eth","zrx"}
-	trader := NewMomentumTrader(*uid, client, assets, "usd", 40.0, 1.0) // Trade with $100
-	trader.RunLoop(10 * time.Second)
+	var api routefire.DMA = client
+	if *paperTrade {
+		api = paper.New(client, nil)
+	}
+	strategy.New(api, nil, trader, cfg).Run()
 }
-
-
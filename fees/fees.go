@@ -0,0 +1,157 @@
+// Package fees models per-venue maker/taker fee schedules and provides
+// fee-adjusted views of consolidated order books and sweep costs, so venues
+// can be compared on the price actually paid or received.
+package fees
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+// Type Tier is one volume tier of a venue's fee schedule. Rates are fractions
+// of notional, e.g. 0.0025 for 25 basis points.
+type Tier struct {
+	Volume float64 `json:"volume"` // Minimum trailing volume for the tier
+	Maker  float64 `json:"maker"`
+	Taker  float64 `json:"taker"`
+}
+
+// Type Schedule holds fee tiers per venue and the trailing volume that selects
+// each venue's tier. *Schedule implements sim.FeeModel, so the same schedule can
+// drive backtests and paper trading.
+type Schedule struct {
+	Venues map[string][]Tier  `json:"venues"`
+	Volume map[string]float64 `json:"volume,omitempty"`
+}
+
+// Function Default returns indicative base-tier rates for the supported venues.
+// Actual rates depend on account volume and change over time; load a schedule
+// for the account where accuracy matters.
+func Default() *Schedule {
+	return &Schedule{Venues: map[string][]Tier{
+		routefire.CoinbasePro: {{Maker: 0.0015, Taker: 0.0025}},
+		routefire.Gemini:      {{Maker: 0.0010, Taker: 0.0035}},
+		routefire.Binance:     {{Maker: 0.0010, Taker: 0.0010}},
+		routefire.Bittrex:     {{Maker: 0.0025, Taker: 0.0025}},
+		routefire.Kraken:      {{Maker: 0.0016, Taker: 0.0026}},
+		routefire.Bitfinex:    {{Maker: 0.0010, Taker: 0.0020}},
+		routefire.Poloniex:    {{Maker: 0.0008, Taker: 0.0020}},
+	}}
+}
+
+// Function Load reads a JSON schedule, e.g.
+//
+//	{"venues": {"GEMINI": [{"volume": 0, "maker": 0.001, "taker": 0.0035},
+//	                       {"volume": 5000000, "maker": 0.0005, "taker": 0.002}]},
+//	 "volume": {"GEMINI": 1200000}}
+func Load(r io.Reader) (*Schedule, error) {
+	var s Schedule
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	normalized := map[string][]Tier{}
+	for venue, tiers := range s.Venues {
+		tiers = append([]Tier(nil), tiers...)
+		sort.Slice(tiers, func(i, j int) bool { return tiers[i].Volume < tiers[j].Volume })
+		normalized[strings.ToUpper(venue)] = tiers
+	}
+	s.Venues = normalized
+	if s.Volume != nil {
+		volume := map[string]float64{}
+		for venue, v := range s.Volume {
+			volume[strings.ToUpper(venue)] += v
+		}
+		s.Volume = volume
+	}
+	return &s, nil
+}
+
+// Function LoadFile reads a JSON schedule from a file.
+func LoadFile(path string) (*Schedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Function Rates returns the maker and taker rates that apply at a venue given
+// its trailing volume. Venues without a schedule are fee-free. A nil schedule
+// is fee-free everywhere.
+func (s *Schedule) Rates(venue string) (maker, taker float64) {
+	if s == nil {
+		return 0, 0
+	}
+	venue = strings.ToUpper(venue)
+	vol := s.Volume[venue]
+	for _, t := range s.Venues[venue] {
+		if vol >= t.Volume {
+			maker, taker = t.Maker, t.Taker
+		}
+	}
+	return maker, taker
+}
+
+// Function Fee returns the fee on a fill of the given notional value.
+func (s *Schedule) Fee(venue string, maker bool, notional float64) float64 {
+	m, t := s.Rates(venue)
+	if maker {
+		return m * notional
+	}
+	return t * notional
+}
+
+// Function NetPrice returns the effective price of taking liquidity at px on a
+// venue: higher than px for a buy, lower for a sell.
+func (s *Schedule) NetPrice(venue, side string, px float64) float64 {
+	_, taker := s.Rates(venue)
+	if strings.ToUpper(side) == routefire.SideSell {
+		return px * (1 - taker)
+	}
+	return px * (1 + taker)
+}
+
+// Function AdjustBook returns a copy of a consolidated book with every price
+// replaced by its net-of-taker-fee equivalent: offers marked up and bids marked
+// down by the fee of the entry's venue. Both sides are sorted from lowest to
+// highest price, like books returned by the DMA API.
+func (s *Schedule) AdjustBook(ob *routefire.DmaOrderBook) *routefire.DmaOrderBook {
+	out := &routefire.DmaOrderBook{}
+	for _, l := range s.netLevels(ob, routefire.SideBuy) {
+		out.Offers = append(out.Offers, l.adjusted())
+	}
+	bids := s.netLevels(ob, routefire.SideSell)
+	for i := len(bids) - 1; i >= 0; i-- {
+		out.Bids = append(out.Bids, bids[i].adjusted())
+	}
+	return out
+}
+
+// Function BestOffer returns the offer with the lowest price after taker fees,
+// and that net price.
+func (s *Schedule) BestOffer(ob *routefire.DmaOrderBook) (routefire.DmaOrderBookEntry, float64, error) {
+	return s.best(ob, routefire.SideBuy)
+}
+
+// Function BestBid returns the bid with the highest price after taker fees,
+// and that net price.
+func (s *Schedule) BestBid(ob *routefire.DmaOrderBook) (routefire.DmaOrderBookEntry, float64, error) {
+	return s.best(ob, routefire.SideSell)
+}
+
+func (s *Schedule) best(ob *routefire.DmaOrderBook, side string) (routefire.DmaOrderBookEntry, float64, error) {
+	levels := s.netLevels(ob, side)
+	if len(levels) == 0 {
+		return routefire.DmaOrderBookEntry{}, 0, routefire.ErrEmptyBook
+	}
+	return levels[0].entry, levels[0].net, nil
+}
+
+var _ sim.FeeModel = (*Schedule)(nil)
@@ -0,0 +1,96 @@
+package fees
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/routefire/go-routefire"
+)
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+var testSchedule = &Schedule{Venues: map[string][]Tier{
+	routefire.Gemini: {{Maker: 0.001, Taker: 0.01}},
+	routefire.Kraken: {{Maker: 0, Taker: 0.001}},
+}}
+
+var testBook = &routefire.DmaOrderBook{
+	Bids: []routefire.DmaOrderBookEntry{
+		{Venue: routefire.Kraken, Price: "99", Amount: "1"},
+		{Venue: routefire.Gemini, Price: "99.5", Amount: "1"},
+	},
+	Offers: []routefire.DmaOrderBookEntry{
+		{Venue: routefire.Gemini, Price: "100", Amount: "1"},
+		{Venue: routefire.Kraken, Price: "100.5", Amount: "2"},
+	},
+}
+
+func TestLoadTiers(t *testing.T) {
+	s, err := Load(strings.NewReader(`{
+		"venues": {"gemini": [{"volume": 1000, "maker": 0.0005, "taker": 0.002}, {"volume": 0, "maker": 0.001, "taker": 0.0035}]},
+		"volume": {"gemini": 5000}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	maker, taker := s.Rates(routefire.Gemini)
+	approx(t, "maker", maker, 0.0005)
+	approx(t, "taker", taker, 0.002)
+	s.Volume[routefire.Gemini] = 10
+	approx(t, "base tier fee", s.Fee(routefire.Gemini, false, 1000), 3.5)
+	approx(t, "unknown venue", s.Fee(routefire.Binance, false, 1000), 0)
+}
+
+func TestBestAfterFees(t *testing.T) {
+	offer, net, err := testSchedule.BestOffer(testBook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.Venue != routefire.Kraken {
+		t.Errorf("Kraken should be cheapest after fees, got %s", offer.Venue)
+	}
+	approx(t, "net offer", net, 100.5*1.001)
+
+	bid, net, _ := testSchedule.BestBid(testBook)
+	if bid.Venue != routefire.Kraken {
+		t.Errorf("Kraken should pay most after fees, got %s", bid.Venue)
+	}
+	approx(t, "net bid", net, 99*0.999)
+
+	adj := testSchedule.AdjustBook(testBook)
+	if adj.Offers[0].Venue != routefire.Kraken || adj.Bids[len(adj.Bids)-1].Venue != routefire.Kraken {
+		t.Errorf("adjusted book should be re-sorted by net price: %+v", adj)
+	}
+	if adj.Offers[1].Price != "101" {
+		t.Errorf("Gemini offer should be marked up by its taker fee, got %s", adj.Offers[1].Price)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c, err := testSchedule.Sweep(testBook, routefire.SideBuy, 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Legs) != 2 || c.Legs[0].Venue != routefire.Kraken || c.Legs[1].Quantity != 0.5 {
+		t.Errorf("unexpected legs %+v", c.Legs)
+	}
+	approx(t, "notional", c.Notional, 201+50)
+	approx(t, "fees", c.Fees, 0.201+0.5)
+	approx(t, "net avg", c.NetAvgPrice(), (251+0.701)/2.5)
+
+	c, err = testSchedule.Sweep(testBook, routefire.SideSell, 3)
+	if err != ErrInsufficientLiquidity || c.Quantity != 2 {
+		t.Errorf("expected a partial sweep, got %+v %v", c, err)
+	}
+	approx(t, "sell net", c.Net(), 99+99.5-0.099-0.995)
+
+	var none *Schedule
+	c, _ = none.Sweep(testBook, routefire.SideBuy, 1)
+	approx(t, "fee-free", c.Net(), 100)
+}
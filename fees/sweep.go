@@ -0,0 +1,116 @@
+package fees
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/routefire/go-routefire"
+)
+
+var ErrInsufficientLiquidity = errors.New("fees: insufficient liquidity in book")
+
+// netLevel is a book entry with its parsed and net-of-fee prices.
+type netLevel struct {
+	entry    routefire.DmaOrderBookEntry
+	price    float64
+	quantity float64
+	net      float64
+}
+
+func (l netLevel) adjusted() routefire.DmaOrderBookEntry {
+	e := l.entry
+	e.Price = routefire.FormatFloat(l.net)
+	return e
+}
+
+// netLevels returns the side of the book an order on the given side takes,
+// best net-of-fee price first.
+func (s *Schedule) netLevels(ob *routefire.DmaOrderBook, side string) []netLevel {
+	sell := strings.ToUpper(side) == routefire.SideSell
+	var levels []netLevel
+	for _, e := range ob.SweepLevels(side) {
+		px, qty, err := e.Floats()
+		if err != nil || qty <= 0 {
+			continue
+		}
+		levels = append(levels, netLevel{e, px, qty, s.NetPrice(e.Venue, side, px)})
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		if sell {
+			return levels[i].net > levels[j].net
+		}
+		return levels[i].net < levels[j].net
+	})
+	return levels
+}
+
+// Type Leg is the part of a sweep executed at one book entry.
+type Leg struct {
+	Venue    string
+	Price    float64
+	Quantity float64
+	Fee      float64
+}
+
+// Type SweepCost is the cost of taking a quantity from a consolidated book.
+type SweepCost struct {
+	Side     string
+	Quantity float64 // Quantity that could be filled
+	Notional float64 // Value at book prices, before fees
+	Fees     float64
+	Legs     []Leg
+}
+
+// Function Net returns the notional after fees: the total paid for a buy, or
+// the total received for a sell.
+func (c *SweepCost) Net() float64 {
+	if c.Side == routefire.SideSell {
+		return c.Notional - c.Fees
+	}
+	return c.Notional + c.Fees
+}
+
+// Function AvgPrice returns the average book price of the sweep.
+func (c *SweepCost) AvgPrice() float64 {
+	if c.Quantity <= 0 {
+		return 0
+	}
+	return c.Notional / c.Quantity
+}
+
+// Function NetAvgPrice returns the average price of the sweep after fees.
+func (c *SweepCost) NetAvgPrice() float64 {
+	if c.Quantity <= 0 {
+		return 0
+	}
+	return c.Net() / c.Quantity
+}
+
+// Function Sweep computes the cost of taking quantity from a consolidated
+// book, taking entries in order of net-of-fee price across venues. If the book
+// cannot fill the whole quantity, the partial sweep is returned together with
+// ErrInsufficientLiquidity.
+func (s *Schedule) Sweep(ob *routefire.DmaOrderBook, side string, quantity float64) (*SweepCost, error) {
+	c := &SweepCost{Side: strings.ToUpper(side)}
+	remaining := quantity
+	for _, l := range s.netLevels(ob, side) {
+		if remaining <= 1e-12 {
+			break
+		}
+		q := l.quantity
+		if remaining < q {
+			q = remaining
+		}
+		fee := s.Fee(l.entry.Venue, false, q*l.price)
+		c.Legs = append(c.Legs, Leg{Venue: l.entry.Venue, Price: l.price, Quantity: q, Fee: fee})
+		c.Quantity += q
+		c.Notional += q * l.price
+		c.Fees += fee
+		remaining -= q
+	}
+	if remaining > 1e-12 {
+		return c, ErrInsufficientLiquidity
+	}
+	return c, nil
+}
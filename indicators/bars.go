@@ -0,0 +1,77 @@
+package indicators
+
+import (
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+// Type Bar is an OHLCV bar covering the interval [Time, Time+period).
+type Bar struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// Type BarBuilder aggregates a stream of prices (e.g. order book mid prices)
+// into fixed-period bars aligned to the period boundary.
+type BarBuilder struct {
+	period time.Duration
+	cur    Bar
+	open   bool
+}
+
+// Function NewBarBuilder creates a bar builder producing bars of the given period.
+func NewBarBuilder(period time.Duration) *BarBuilder {
+	return &BarBuilder{period: period}
+}
+
+// Function Add adds a price observation at time t. When t falls in a later
+// period than the bar being built, the finished bar is returned with ok set.
+func (bb *BarBuilder) Add(t time.Time, price, volume float64) (done Bar, ok bool) {
+	start := t.Truncate(bb.period)
+	if bb.open && start.After(bb.cur.Time) {
+		done, ok = bb.cur, true
+		bb.open = false
+	}
+	if !bb.open {
+		bb.cur = Bar{Time: start, Open: price, High: price, Low: price, Close: price}
+		bb.open = true
+	}
+	if price > bb.cur.High {
+		bb.cur.High = price
+	}
+	if price < bb.cur.Low {
+		bb.cur.Low = price
+	}
+	bb.cur.Close = price
+	bb.cur.Volume += volume
+	return done, ok
+}
+
+// Function AddBook adds the mid price of a consolidated order book snapshot
+// taken at time t. Books without both sides are ignored.
+func (bb *BarBuilder) AddBook(t time.Time, ob *routefire.DmaOrderBook) (Bar, bool) {
+	mid, err := ob.MidPrice()
+	if err != nil {
+		return Bar{}, false
+	}
+	return bb.Add(t, mid, 0)
+}
+
+// Function Current returns the bar currently being built, if any.
+func (bb *BarBuilder) Current() (Bar, bool) {
+	return bb.cur, bb.open
+}
+
+// Function UpdateBook feeds the mid price of an order book snapshot to an
+// indicator. Books without both sides leave the indicator unchanged.
+func UpdateBook(ind Indicator, ob *routefire.DmaOrderBook) float64 {
+	if mid, err := ob.MidPrice(); err == nil {
+		return ind.Update(mid)
+	}
+	return ind.Value()
+}
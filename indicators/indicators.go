@@ -0,0 +1,426 @@
+// Package indicators provides streaming technical indicators. Every indicator
+// is updated one observation at a time in constant time, so it can be fed
+// directly from a stream of bars or order book snapshots.
+package indicators
+
+import "math"
+
+// Type Indicator is implemented by single-input indicators.
+type Indicator interface {
+	// Update adds an observation and returns the new indicator value.
+	Update(x float64) float64
+	// Value returns the current indicator value.
+	Value() float64
+	// Ready reports whether enough observations have been seen for Value to be meaningful.
+	Ready() bool
+}
+
+// window is a fixed-size ring buffer of the most recent observations.
+type window struct {
+	xs   []float64
+	next int
+	full bool
+}
+
+func newWindow(n int) *window {
+	if n < 1 {
+		n = 1
+	}
+	return &window{xs: make([]float64, n)}
+}
+
+// push adds x and returns the observation it evicted, if any.
+func (w *window) push(x float64) (float64, bool) {
+	old, evicted := w.xs[w.next], w.full
+	w.xs[w.next] = x
+	w.next++
+	if w.next == len(w.xs) {
+		w.next = 0
+		w.full = true
+	}
+	return old, evicted
+}
+
+// oldest returns the oldest observation held in the window.
+func (w *window) oldest() float64 {
+	if w.full {
+		return w.xs[w.next]
+	}
+	return w.xs[0]
+}
+
+func (w *window) len() int {
+	if w.full {
+		return len(w.xs)
+	}
+	return w.next
+}
+
+// Type SMA is a simple moving average over the last N observations.
+type SMA struct {
+	w   *window
+	sum float64
+}
+
+// Function NewSMA creates a simple moving average over n observations.
+func NewSMA(n int) *SMA {
+	return &SMA{w: newWindow(n)}
+}
+
+func (s *SMA) Update(x float64) float64 {
+	if old, ok := s.w.push(x); ok {
+		s.sum -= old
+	}
+	s.sum += x
+	return s.Value()
+}
+
+func (s *SMA) Value() float64 {
+	if s.w.len() == 0 {
+		return 0
+	}
+	return s.sum / float64(s.w.len())
+}
+
+func (s *SMA) Ready() bool {
+	return s.w.full
+}
+
+// Type EMA is an exponential moving average with smoothing factor 2/(N+1). It is
+// seeded with the simple average of the first N observations.
+type EMA struct {
+	n     int
+	alpha float64
+	count int
+	value float64
+}
+
+// Function NewEMA creates an exponential moving average over n periods.
+func NewEMA(n int) *EMA {
+	if n < 1 {
+		n = 1
+	}
+	return &EMA{n: n, alpha: 2.0 / float64(n+1)}
+}
+
+func (e *EMA) Update(x float64) float64 {
+	e.count++
+	if e.count <= e.n {
+		e.value += (x - e.value) / float64(e.count)
+	} else {
+		e.value += e.alpha * (x - e.value)
+	}
+	return e.value
+}
+
+func (e *EMA) Value() float64 {
+	return e.value
+}
+
+func (e *EMA) Ready() bool {
+	return e.count >= e.n
+}
+
+// Type StdDev is the rolling population standard deviation over the last N
+// observations. The mean and sum of squared deviations are updated with
+// Welford's method to avoid the cancellation error of naive sum-of-squares.
+type StdDev struct {
+	w    *window
+	mean float64
+	m2   float64
+}
+
+// Function NewStdDev creates a rolling standard deviation over n observations.
+func NewStdDev(n int) *StdDev {
+	return &StdDev{w: newWindow(n)}
+}
+
+func (s *StdDev) Update(x float64) float64 {
+	old, evicted := s.w.push(x)
+	if evicted {
+		n := float64(s.w.len())
+		delta := x - old
+		oldMean := s.mean
+		s.mean += delta / n
+		s.m2 += delta * (x - s.mean + old - oldMean)
+	} else {
+		n := float64(s.w.len())
+		delta := x - s.mean
+		s.mean += delta / n
+		s.m2 += delta * (x - s.mean)
+	}
+	if s.m2 < 0 {
+		s.m2 = 0
+	}
+	return s.Value()
+}
+
+func (s *StdDev) Value() float64 {
+	if s.w.len() == 0 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.w.len()))
+}
+
+// Function Mean returns the rolling mean of the window.
+func (s *StdDev) Mean() float64 {
+	return s.mean
+}
+
+func (s *StdDev) Ready() bool {
+	return s.w.full
+}
+
+// Type ZScore is the number of rolling standard deviations between the latest
+// observation and the rolling mean (both computed over a window that includes
+// the latest observation). It is zero while the window has no dispersion.
+type ZScore struct {
+	sd    *StdDev
+	value float64
+}
+
+// Function NewZScore creates a rolling z-score over n observations.
+func NewZScore(n int) *ZScore {
+	return &ZScore{sd: NewStdDev(n)}
+}
+
+func (z *ZScore) Update(x float64) float64 {
+	sigma := z.sd.Update(x)
+	if sigma < 1e-8 {
+		z.value = 0
+	} else {
+		z.value = (x - z.sd.Mean()) / sigma
+	}
+	return z.value
+}
+
+func (z *ZScore) Value() float64 {
+	return z.value
+}
+
+func (z *ZScore) Ready() bool {
+	return z.sd.Ready()
+}
+
+// Type Bollinger holds Bollinger bands: a simple moving average with bands K
+// population standard deviations above and below it.
+type Bollinger struct {
+	k  float64
+	sd *StdDev
+}
+
+// Function NewBollinger creates Bollinger bands over n observations at k standard deviations.
+func NewBollinger(n int, k float64) *Bollinger {
+	return &Bollinger{k: k, sd: NewStdDev(n)}
+}
+
+// Function Update adds an observation and returns the new middle band.
+func (b *Bollinger) Update(x float64) float64 {
+	b.sd.Update(x)
+	return b.Value()
+}
+
+// Function Value returns the middle band.
+func (b *Bollinger) Value() float64 {
+	return b.sd.Mean()
+}
+
+// Function Bands returns the lower, middle and upper bands.
+func (b *Bollinger) Bands() (lower, middle, upper float64) {
+	mid, width := b.sd.Mean(), b.k*b.sd.Value()
+	return mid - width, mid, mid + width
+}
+
+// Function PercentB returns the position of x relative to the bands: 0 at the
+// lower band, 1 at the upper band.
+func (b *Bollinger) PercentB(x float64) float64 {
+	lower, _, upper := b.Bands()
+	if upper == lower {
+		return 0.5
+	}
+	return (x - lower) / (upper - lower)
+}
+
+func (b *Bollinger) Ready() bool {
+	return b.sd.Ready()
+}
+
+// Type RSI is Wilder's relative strength index over N periods. The first
+// average gain and loss are simple averages of the first N changes; later
+// values use Wilder's smoothing.
+type RSI struct {
+	n       int
+	count   int
+	last    float64
+	avgGain float64
+	avgLoss float64
+}
+
+// Function NewRSI creates a relative strength index over n periods.
+func NewRSI(n int) *RSI {
+	if n < 1 {
+		n = 1
+	}
+	return &RSI{n: n}
+}
+
+func (r *RSI) Update(x float64) float64 {
+	if r.count == 0 {
+		r.last = x
+		r.count++
+		return r.Value()
+	}
+	change := x - r.last
+	r.last = x
+	gain, loss := math.Max(change, 0), math.Max(-change, 0)
+	n := float64(r.n)
+	if r.count <= r.n {
+		r.avgGain += gain / n
+		r.avgLoss += loss / n
+	} else {
+		r.avgGain = (r.avgGain*(n-1) + gain) / n
+		r.avgLoss = (r.avgLoss*(n-1) + loss) / n
+	}
+	r.count++
+	return r.Value()
+}
+
+func (r *RSI) Value() float64 {
+	if !r.Ready() {
+		return 0
+	}
+	if r.avgLoss == 0 {
+		if r.avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+r.avgGain/r.avgLoss)
+}
+
+func (r *RSI) Ready() bool {
+	return r.count > r.n
+}
+
+// Type ROC is the rate of change, in percent, between the latest observation and
+// the observation N periods earlier.
+type ROC struct {
+	w     *window
+	value float64
+}
+
+// Function NewROC creates a rate of change indicator over n periods.
+func NewROC(n int) *ROC {
+	return &ROC{w: newWindow(n + 1)}
+}
+
+func (r *ROC) Update(x float64) float64 {
+	r.w.push(x)
+	if base := r.w.oldest(); r.Ready() && base != 0 {
+		r.value = (x/base - 1) * 100
+	}
+	return r.value
+}
+
+func (r *ROC) Value() float64 {
+	return r.value
+}
+
+func (r *ROC) Ready() bool {
+	return r.w.full
+}
+
+// Type ATR is Wilder's average true range over N bars. The first value is the
+// simple average of the first N true ranges.
+type ATR struct {
+	n         int
+	count     int
+	prevClose float64
+	value     float64
+}
+
+// Function NewATR creates an average true range over n bars.
+func NewATR(n int) *ATR {
+	if n < 1 {
+		n = 1
+	}
+	return &ATR{n: n}
+}
+
+// Function UpdateBar adds a bar and returns the new average true range.
+func (a *ATR) UpdateBar(b Bar) float64 {
+	tr := b.High - b.Low
+	if a.count > 0 {
+		tr = math.Max(tr, math.Max(math.Abs(b.High-a.prevClose), math.Abs(b.Low-a.prevClose)))
+	}
+	a.prevClose = b.Close
+	a.count++
+	n := float64(a.n)
+	if a.count <= a.n {
+		a.value += (tr - a.value) / float64(a.count)
+	} else {
+		a.value = (a.value*(n-1) + tr) / n
+	}
+	return a.value
+}
+
+func (a *ATR) Value() float64 {
+	return a.value
+}
+
+func (a *ATR) Ready() bool {
+	return a.count >= a.n
+}
+
+// Type Correlation is the rolling Pearson correlation of two series over the
+// last N paired observations.
+type Correlation struct {
+	xs, ys                *window
+	sx, sy, sxx, syy, sxy float64
+}
+
+// Function NewCorrelation creates a rolling correlation over n observations.
+func NewCorrelation(n int) *Correlation {
+	return &Correlation{xs: newWindow(n), ys: newWindow(n)}
+}
+
+// Function Update adds a paired observation and returns the new correlation.
+func (c *Correlation) Update(x, y float64) float64 {
+	if ox, ok := c.xs.push(x); ok {
+		oy, _ := c.ys.push(y)
+		c.sx -= ox
+		c.sy -= oy
+		c.sxx -= ox * ox
+		c.syy -= oy * oy
+		c.sxy -= ox * oy
+	} else {
+		c.ys.push(y)
+	}
+	c.sx += x
+	c.sy += y
+	c.sxx += x * x
+	c.syy += y * y
+	c.sxy += x * y
+	return c.Value()
+}
+
+// Function Value returns the current correlation, or zero if either series has
+// no variance over the window.
+func (c *Correlation) Value() float64 {
+	n := float64(c.xs.len())
+	if n < 2 {
+		return 0
+	}
+	cov := c.sxy - c.sx*c.sy/n
+	vx := c.sxx - c.sx*c.sx/n
+	vy := c.syy - c.sy*c.sy/n
+	if vx <= 1e-12*c.sxx || vy <= 1e-12*c.syy {
+		return 0
+	}
+	r := cov / math.Sqrt(vx*vy)
+	return math.Max(-1, math.Min(1, r))
+}
+
+func (c *Correlation) Ready() bool {
+	return c.xs.full
+}
@@ -0,0 +1,149 @@
+package indicators
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+// Closing prices from Wilder's RSI worked example (as reproduced by StockCharts).
+// StockCharts rounds intermediate averages to two decimals, so its published
+// values differ slightly from the full-precision ones used below.
+var wilderCloses = []float64{
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+	45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+}
+
+func approx(t *testing.T, name string, got, want, tol float64) {
+	t.Helper()
+	if math.Abs(got-want) > tol {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+func TestSMA(t *testing.T) {
+	s := NewSMA(3)
+	for _, x := range []float64{1, 2, 3, 4, 5} {
+		s.Update(x)
+	}
+	if !s.Ready() {
+		t.Fatalf("SMA should be ready")
+	}
+	approx(t, "SMA", s.Value(), 4, 1e-12)
+}
+
+func TestEMA(t *testing.T) {
+	e := NewEMA(3)
+	for _, x := range []float64{2, 4, 6} {
+		e.Update(x)
+	}
+	approx(t, "EMA seed", e.Value(), 4, 1e-12)
+	approx(t, "EMA", e.Update(8), 6, 1e-12)
+	approx(t, "EMA", e.Update(2), 4, 1e-12)
+}
+
+func TestStdDevAndZScore(t *testing.T) {
+	sd := NewStdDev(8)
+	z := NewZScore(8)
+	// Population standard deviation of {2,4,4,4,5,5,7,9} is exactly 2.
+	for _, x := range []float64{100, 2, 4, 4, 4, 5, 5, 7, 9} {
+		sd.Update(x)
+		z.Update(x)
+	}
+	approx(t, "StdDev", sd.Value(), 2, 1e-9)
+	approx(t, "Mean", sd.Mean(), 5, 1e-9)
+	approx(t, "ZScore", z.Value(), 2, 1e-9)
+}
+
+func TestBollinger(t *testing.T) {
+	b := NewBollinger(8, 2)
+	for _, x := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		b.Update(x)
+	}
+	lower, mid, upper := b.Bands()
+	approx(t, "lower", lower, 1, 1e-9)
+	approx(t, "middle", mid, 5, 1e-9)
+	approx(t, "upper", upper, 9, 1e-9)
+	approx(t, "%b", b.PercentB(9), 1, 1e-9)
+}
+
+func TestRSI(t *testing.T) {
+	r := NewRSI(14)
+	want := map[int]float64{14: 70.46, 15: 66.25, 16: 66.48, 17: 69.35, 18: 66.29, 19: 57.92}
+	for i, x := range wilderCloses {
+		v := r.Update(x)
+		if i < 14 && r.Ready() {
+			t.Fatalf("RSI ready after %d observations", i+1)
+		}
+		if w, ok := want[i]; ok {
+			approx(t, "RSI", v, w, 0.01)
+		}
+	}
+}
+
+func TestROC(t *testing.T) {
+	r := NewROC(2)
+	r.Update(100)
+	r.Update(50)
+	if r.Ready() {
+		t.Fatalf("ROC should not be ready")
+	}
+	approx(t, "ROC", r.Update(110), 10, 1e-9)
+	approx(t, "ROC", r.Update(75), 50, 1e-9)
+}
+
+func TestATR(t *testing.T) {
+	a := NewATR(3)
+	bars := []Bar{
+		{High: 10, Low: 8, Close: 9},   // TR 2
+		{High: 12, Low: 10, Close: 11}, // TR 3 (12 - prev close 9)
+		{High: 11, Low: 10, Close: 10}, // TR 1
+		{High: 15, Low: 12, Close: 14}, // TR 5 (15 - prev close 10)
+	}
+	for i, b := range bars[:3] {
+		a.UpdateBar(b)
+		if (i == 2) != a.Ready() {
+			t.Fatalf("unexpected ready state after %d bars", i+1)
+		}
+	}
+	approx(t, "ATR seed", a.Value(), 2, 1e-9)
+	approx(t, "ATR", a.UpdateBar(bars[3]), 3, 1e-9)
+}
+
+func TestCorrelation(t *testing.T) {
+	c := NewCorrelation(4)
+	for _, p := range [][2]float64{{9, 0}, {1, 2}, {2, 4}, {3, 6}, {4, 8}} {
+		c.Update(p[0], p[1])
+	}
+	approx(t, "perfect", c.Value(), 1, 1e-9)
+	c.Update(5, 0)
+	// Window is now x={2,3,4,5}, y={4,6,8,0}.
+	approx(t, "rolling", c.Value(), -5/math.Sqrt(175), 1e-9)
+}
+
+func TestBarBuilder(t *testing.T) {
+	t0 := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	bb := NewBarBuilder(time.Minute)
+	if _, ok := bb.Add(t0, 10, 1); ok {
+		t.Fatalf("no bar should be complete yet")
+	}
+	bb.Add(t0.Add(10*time.Second), 12, 1)
+	bb.Add(t0.Add(20*time.Second), 9, 1)
+	bb.Add(t0.Add(30*time.Second), 11, 1)
+	ob := &routefire.DmaOrderBook{
+		Bids:   []routefire.DmaOrderBookEntry{{Price: "19", Amount: "1"}, {Price: "20", Amount: "1"}},
+		Offers: []routefire.DmaOrderBookEntry{{Price: "22", Amount: "1"}},
+	}
+	bar, ok := bb.AddBook(t0.Add(time.Minute), ob)
+	if !ok {
+		t.Fatalf("expected a completed bar")
+	}
+	want := Bar{Time: t0, Open: 10, High: 12, Low: 9, Close: 11, Volume: 4}
+	if bar != want {
+		t.Errorf("got %+v, want %+v", bar, want)
+	}
+	cur, _ := bb.Current()
+	approx(t, "next open", cur.Open, 21, 1e-12)
+}
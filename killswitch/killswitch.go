@@ -0,0 +1,418 @@
+// Package killswitch provides a kill switch for trading processes. A Switch
+// wraps a Routefire API and tracks the orders submitted through it; when it is
+// tripped, it cancels every open DMA and algorithm order concurrently, with
+// retries, and rejects further submissions until it is re-armed.
+package killswitch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/risk"
+)
+
+var (
+	ErrTripped = errors.New("killswitch: tripped, submissions are blocked until re-armed")
+)
+
+// Type OrderRef identifies a tracked order. Venue is empty for algorithm orders.
+type OrderRef struct {
+	Venue   string `json:"venue,omitempty"`
+	OrderId string `json:"order_id"`
+}
+
+func (r OrderRef) String() string {
+	if r.Venue == "" {
+		return r.OrderId
+	}
+	return r.Venue + ":" + r.OrderId
+}
+
+// Type Failure is an order that could not be cancelled.
+type Failure struct {
+	Order OrderRef
+	Err   error
+}
+
+// Type Report is the outcome of tripping the switch.
+type Report struct {
+	Reason    string
+	Time      time.Time
+	Duration  time.Duration
+	Cancelled []OrderRef
+	Failed    []Failure
+}
+
+// Function OK reports whether every order was cancelled.
+func (r *Report) OK() bool {
+	return len(r.Failed) == 0
+}
+
+func (r *Report) String() string {
+	s := fmt.Sprintf("kill switch (%s): %d cancelled, %d failed", r.Reason, len(r.Cancelled), len(r.Failed))
+	for _, f := range r.Failed {
+		s += fmt.Sprintf("\n  %s: %v", f.Order, f.Err)
+	}
+	return s
+}
+
+// Type Switch wraps a Routefire API with a kill switch. It implements
+// routefire.API, so it can be used in place of the client it wraps.
+type Switch struct {
+	dma    routefire.DMA
+	api    routefire.API
+	userId string
+
+	lock    sync.Mutex
+	orders  map[OrderRef]bool
+	tripped bool
+	reason  string
+
+	// Retries is the number of times a failed cancel is retried.
+	Retries int
+	// RetryDelay is the pause between retries.
+	RetryDelay time.Duration
+	// OnTrip, if set, is called with the report each time the switch trips.
+	OnTrip func(*Report)
+}
+
+// Function New wraps api. UserId is used for the cancels sent when the switch
+// trips.
+func New(api routefire.API, userId string) *Switch {
+	s := NewDMA(api, userId)
+	s.api = api
+	return s
+}
+
+// Function NewDMA wraps a DMA-only API, such as a backtest engine.
+func NewDMA(api routefire.DMA, userId string) *Switch {
+	return &Switch{
+		dma:        api,
+		userId:     userId,
+		orders:     map[OrderRef]bool{},
+		Retries:    3,
+		RetryDelay: 500 * time.Millisecond,
+	}
+}
+
+// Function Track adds a DMA order submitted elsewhere to the orders cancelled
+// when the switch trips.
+func (s *Switch) Track(venue, venueOrdId string) {
+	s.track(OrderRef{strings.ToUpper(venue), venueOrdId})
+}
+
+// Function TrackAlgo adds an algorithm order submitted elsewhere to the orders
+// cancelled when the switch trips.
+func (s *Switch) TrackAlgo(orderId string) {
+	s.track(OrderRef{"", orderId})
+}
+
+// track adds an order and reports whether the switch is tripped. Checking under
+// the same lock means an order is either in the snapshot taken by Trip or seen
+// to have been placed after it.
+func (s *Switch) track(ref OrderRef) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.orders[ref] = true
+	return s.tripped
+}
+
+// placed tracks a newly submitted order. If the switch tripped while the order
+// was in flight, Trip may not have seen it, so it is cancelled at once and
+// ErrTripped is returned. An order that cannot be cancelled stays tracked.
+func (s *Switch) placed(ref OrderRef) error {
+	if !s.track(ref) {
+		return nil
+	}
+	s.cancel(ref)
+	return ErrTripped
+}
+
+// Function Orders returns the tracked open orders.
+func (s *Switch) Orders() []OrderRef {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	out := make([]OrderRef, 0, len(s.orders))
+	for ref := range s.orders {
+		out = append(out, ref)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// Function Tripped reports whether the switch is tripped, and why.
+func (s *Switch) Tripped() (bool, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tripped, s.reason
+}
+
+// Function Rearm allows submissions again after the switch has tripped.
+func (s *Switch) Rearm() {
+	s.lock.Lock()
+	s.tripped, s.reason = false, ""
+	s.lock.Unlock()
+}
+
+// Function Trip blocks submissions and cancels every tracked order
+// concurrently, retrying failed cancels. Orders found to be closed when a
+// cancel fails count as cancelled. Orders that could not be cancelled remain
+// tracked, so tripping again retries them.
+func (s *Switch) Trip(reason string) *Report {
+	start := time.Now()
+	s.lock.Lock()
+	s.tripped, s.reason = true, reason
+	s.lock.Unlock()
+
+	rep := &Report{Reason: reason, Time: start}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, ref := range s.Orders() {
+		wg.Add(1)
+		go func(ref OrderRef) {
+			defer wg.Done()
+			err := s.cancel(ref)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				rep.Failed = append(rep.Failed, Failure{ref, err})
+			} else {
+				rep.Cancelled = append(rep.Cancelled, ref)
+			}
+		}(ref)
+	}
+	wg.Wait()
+	sort.Slice(rep.Cancelled, func(i, j int) bool { return rep.Cancelled[i].String() < rep.Cancelled[j].String() })
+	sort.Slice(rep.Failed, func(i, j int) bool { return rep.Failed[i].Order.String() < rep.Failed[j].Order.String() })
+	rep.Duration = time.Since(start)
+	if s.OnTrip != nil {
+		s.OnTrip(rep)
+	}
+	return rep
+}
+
+// cancel cancels one order, retrying on failure. After a failed attempt the
+// order's status is checked, since it may have filled or been cancelled
+// already.
+func (s *Switch) cancel(ref OrderRef) error {
+	var err error
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.RetryDelay)
+		}
+		if err = s.cancelOnce(ref); err == nil {
+			break
+		}
+		if !s.stillOpen(ref) {
+			err = nil
+			break
+		}
+	}
+	if err == nil {
+		s.lock.Lock()
+		delete(s.orders, ref)
+		s.lock.Unlock()
+	}
+	return err
+}
+
+func (s *Switch) cancelOnce(ref OrderRef) error {
+	if ref.Venue == "" {
+		if s.api == nil {
+			return routefire.ErrNoAlgoAPI
+		}
+		_, err := s.api.CancelOrder(s.userId, ref.OrderId)
+		return err
+	}
+	resp, err := s.dma.CancelOrderDMA(s.userId, ref.Venue, ref.OrderId)
+	if err == nil {
+		err = routefire.FirstDmaError(resp.Errors)
+	}
+	return err
+}
+
+func (s *Switch) stillOpen(ref OrderRef) bool {
+	var status string
+	if ref.Venue == "" {
+		if s.api == nil {
+			return true
+		}
+		st, err := s.api.GetOrderStatus(s.userId, ref.OrderId)
+		if err != nil {
+			return true
+		}
+		status = st.Status
+	} else {
+		st, err := s.dma.OrderStatusDMA(s.userId, ref.Venue, ref.OrderId)
+		if err != nil || len(st.Errors) > 0 {
+			return true
+		}
+		status = st.Status
+	}
+	return routefire.IsOpenStatus(status)
+}
+
+// Function TripOnSignal trips the switch when the process receives one of the
+// given signals, e.g. os.Interrupt, and then calls then, if set, with the
+// report. It returns a function that stops listening.
+func (s *Switch) TripOnSignal(then func(*Report), sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				rep := s.Trip("signal: " + sig.String())
+				if then != nil {
+					then(rep)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// Function TripOnBreach trips the switch on a risk limit breach. It can be
+// assigned to risk.Manager.OnBreach.
+func (s *Switch) TripOnBreach(r *risk.Rejection) {
+	s.Trip("risk breach: " + r.Rule)
+}
+
+func (s *Switch) blocked() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tripped {
+		return ErrTripped
+	}
+	return nil
+}
+
+func (s *Switch) untrack(ref OrderRef, status string) {
+	if !routefire.IsOpenStatus(status) {
+		s.lock.Lock()
+		delete(s.orders, ref)
+		s.lock.Unlock()
+	}
+}
+
+//
+//  Guarded order calls
+//
+
+func (s *Switch) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	if err := s.blocked(); err != nil {
+		return nil, err
+	}
+	resp, err := s.dma.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	if err == nil && resp.VenueOrderId != "" {
+		if err := s.placed(OrderRef{strings.ToUpper(venue), resp.VenueOrderId}); err != nil {
+			return nil, err
+		}
+	}
+	return resp, err
+}
+
+func (s *Switch) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if s.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	if err := s.blocked(); err != nil {
+		return nil, err
+	}
+	resp, err := s.api.SubmitOrder(userId, buyAsset, sellAsset, quantity, price, algo, algoParams)
+	if err == nil && resp.OrderId != "" {
+		if err := s.placed(OrderRef{"", resp.OrderId}); err != nil {
+			return nil, err
+		}
+	}
+	return resp, err
+}
+
+func (s *Switch) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	st, err := s.dma.OrderStatusDMA(userId, venue, venueOrdId)
+	if err == nil && len(st.Errors) == 0 {
+		s.untrack(OrderRef{strings.ToUpper(venue), venueOrdId}, st.Status)
+	}
+	return st, err
+}
+
+func (s *Switch) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	resp, err := s.dma.CancelOrderDMA(userId, venue, venueOrdId)
+	if err == nil && len(resp.Errors) == 0 {
+		s.untrack(OrderRef{strings.ToUpper(venue), venueOrdId}, routefire.StatusCancelled)
+	}
+	return resp, err
+}
+
+func (s *Switch) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	if s.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	st, err := s.api.GetOrderStatus(userId, orderId)
+	if err == nil {
+		s.untrack(OrderRef{"", orderId}, st.Status)
+	}
+	return st, err
+}
+
+func (s *Switch) CancelOrder(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	if s.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	st, err := s.api.CancelOrder(userId, orderId)
+	if err == nil {
+		s.untrack(OrderRef{"", orderId}, routefire.StatusCancelled)
+	}
+	return st, err
+}
+
+//
+//  Forwarded calls
+//
+
+func (s *Switch) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	return s.dma.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+}
+
+func (s *Switch) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	return s.dma.BalanceDMA(userId, venue, assetId)
+}
+
+func (s *Switch) GetBalances(uid, asset string) (map[string]string, error) {
+	if s.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	return s.api.GetBalances(uid, asset)
+}
+
+func (s *Switch) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*routefire.InquiryResponse, error) {
+	if s.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	return s.api.GetOrderBookStats(uid, buyAsset, sellAsset, quantity)
+}
+
+func (s *Switch) GetConsolidatedOrderBook(uid, buyAsset, sellAsset string) (*routefire.OrderBookResponse, error) {
+	if s.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	return s.api.GetConsolidatedOrderBook(uid, buyAsset, sellAsset)
+}
+
+var _ routefire.API = (*Switch)(nil)
@@ -0,0 +1,135 @@
+package killswitch
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/risk"
+)
+
+const uid = "killswitch@example.com"
+
+// flakyDMA fails the first cancel of every order.
+type flakyDMA struct {
+	routefire.DMA
+	lock  sync.Mutex
+	tried map[string]bool
+}
+
+func (f *flakyDMA) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	f.lock.Lock()
+	first := !f.tried[venueOrdId]
+	f.tried[venueOrdId] = true
+	f.lock.Unlock()
+	if first {
+		return nil, errors.New("timeout")
+	}
+	return f.DMA.CancelOrderDMA(userId, venue, venueOrdId)
+}
+
+func testEngine() *backtest.Engine {
+	bt := backtest.New([]backtest.Snapshot{{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "99", Amount: "5"}},
+			Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "101", Amount: "5"}},
+		},
+	}}, backtest.Config{})
+	bt.Step()
+	return bt
+}
+
+func TestTripCancelsAndBlocks(t *testing.T) {
+	bt := testEngine()
+	s := NewDMA(&flakyDMA{DMA: bt, tried: map[string]bool{}}, uid)
+	s.RetryDelay = 0
+
+	for _, px := range []string{"95", "96"} {
+		if _, err := s.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", px, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An order placed elsewhere, and one the venue does not know.
+	resp, _ := bt.SubmitOrderDMA(uid, routefire.Kraken, routefire.Btc, routefire.Usd, routefire.SideSell, "1", "105", nil)
+	s.Track(resp.VenueId, resp.VenueOrderId)
+	s.Track(routefire.Gemini, "missing")
+
+	var tripped *Report
+	s.OnTrip = func(r *Report) { tripped = r }
+	rep := s.Trip("test")
+	if len(rep.Cancelled) != 3 || len(rep.Failed) != 1 || rep.Failed[0].Order.OrderId != "missing" || tripped != rep {
+		t.Errorf("unexpected report: %s", rep)
+	}
+	for _, o := range bt.Exchange().Orders() {
+		if o.IsOpen() {
+			t.Errorf("order %s is still open", o.ID)
+		}
+	}
+	if orders := s.Orders(); len(orders) != 1 {
+		t.Errorf("failed cancels should stay tracked, got %v", orders)
+	}
+
+	if _, err := s.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "95", nil); err != ErrTripped {
+		t.Errorf("expected ErrTripped, got %v", err)
+	}
+	s.Rearm()
+	if _, err := s.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "95", nil); err != nil {
+		t.Errorf("re-armed switch should accept orders, got %v", err)
+	}
+}
+
+// tripDuringSubmit trips the switch while an order is in flight.
+type tripDuringSubmit struct {
+	routefire.DMA
+	s *Switch
+}
+
+func (d *tripDuringSubmit) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	d.s.Trip("concurrent")
+	return d.DMA.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+}
+
+func TestSubmitRacingTripIsCancelled(t *testing.T) {
+	bt := testEngine()
+	d := &tripDuringSubmit{DMA: bt}
+	s := NewDMA(d, uid)
+	d.s = s
+
+	if _, err := s.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "95", nil); err != ErrTripped {
+		t.Errorf("expected ErrTripped, got %v", err)
+	}
+	for _, o := range bt.Exchange().Orders() {
+		if o.IsOpen() {
+			t.Errorf("order %s placed during the trip is still open", o.ID)
+		}
+	}
+	if orders := s.Orders(); len(orders) != 0 {
+		t.Errorf("cancelled order should not stay tracked, got %v", orders)
+	}
+}
+
+func TestTripOnBreach(t *testing.T) {
+	bt := testEngine()
+	s := NewDMA(bt, uid)
+	m := risk.NewDMA(s, risk.Limits{MaxOrderNotional: 500})
+	m.OnBreach = s.TripOnBreach
+
+	if _, err := m.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "95", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "10", "95", nil); err == nil {
+		t.Fatal("expected a risk rejection")
+	}
+	if tripped, reason := s.Tripped(); !tripped || reason != "risk breach: "+risk.RuleMaxNotional {
+		t.Errorf("expected the switch to trip on the breach, got %v %q", tripped, reason)
+	}
+	if len(s.Orders()) != 0 {
+		t.Errorf("resting order should have been cancelled")
+	}
+}
@@ -0,0 +1,311 @@
+// Package ledger keeps positions and profit and loss from fills. Positions are
+// kept per venue and, separately, per asset across all venues, each with an
+// average cost, realized PnL, fees and unrealized PnL marked against the
+// consolidated book mid price.
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+const epsilon = 1e-12
+
+var ErrInvalidFill = errors.New("ledger: invalid fill")
+
+// Type Fill is an execution to be booked. Fee is in the base asset.
+type Fill struct {
+	Time      time.Time `json:"time"`
+	OrderId   string    `json:"order_id,omitempty"`
+	Venue     string    `json:"venue,omitempty"`
+	Asset     string    `json:"asset"`
+	BaseAsset string    `json:"base_asset"`
+	Side      string    `json:"side"`
+	Quantity  float64   `json:"quantity"`
+	Price     float64   `json:"price"`
+	Fee       float64   `json:"fee"`
+}
+
+// Type Position is a position in an asset, valued in its base asset. Quantity
+// is negative for short positions. Venue is empty for positions aggregated
+// across venues.
+type Position struct {
+	Asset         string  `json:"asset"`
+	BaseAsset     string  `json:"base_asset"`
+	Venue         string  `json:"venue,omitempty"`
+	Quantity      float64 `json:"quantity"`
+	AvgCost       float64 `json:"avg_cost"`
+	RealizedPnL   float64 `json:"realized_pnl"`
+	Fees          float64 `json:"fees"`
+	Mark          float64 `json:"mark"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+}
+
+// Function NetPnL returns realized plus unrealized PnL, less fees.
+func (p Position) NetPnL() float64 {
+	return p.RealizedPnL + p.UnrealizedPnL - p.Fees
+}
+
+// apply books a fill against the position using average cost accounting.
+func (p *Position) apply(side string, qty, px, fee float64) {
+	signed := qty
+	if side == routefire.SideSell {
+		signed = -qty
+	}
+	p.Fees += fee
+	switch {
+	case math.Abs(p.Quantity) < epsilon || (p.Quantity > 0) == (signed > 0):
+		// Opening or adding to a position.
+		total := math.Abs(p.Quantity) + qty
+		p.AvgCost = (p.AvgCost*math.Abs(p.Quantity) + px*qty) / total
+		p.Quantity += signed
+	case qty <= math.Abs(p.Quantity)+epsilon:
+		// Reducing or closing a position.
+		dir := math.Copysign(1, p.Quantity)
+		p.RealizedPnL += (px - p.AvgCost) * qty * dir
+		p.Quantity += signed
+		if math.Abs(p.Quantity) < epsilon {
+			p.Quantity, p.AvgCost = 0, 0
+		}
+	default:
+		// Closing and reversing a position.
+		dir := math.Copysign(1, p.Quantity)
+		p.RealizedPnL += (px - p.AvgCost) * math.Abs(p.Quantity) * dir
+		p.Quantity += signed
+		p.AvgCost = px
+	}
+	p.revalue()
+}
+
+func (p *Position) revalue() {
+	if p.Mark > 0 {
+		p.UnrealizedPnL = (p.Mark - p.AvgCost) * p.Quantity
+	} else {
+		p.UnrealizedPnL = 0
+	}
+}
+
+// Type Snapshot is the serializable state of a ledger.
+type Snapshot struct {
+	Time          time.Time  `json:"time"`
+	Positions     []Position `json:"positions"`
+	Totals        []Position `json:"totals"`
+	RealizedPnL   float64    `json:"realized_pnl"`
+	UnrealizedPnL float64    `json:"unrealized_pnl"`
+	Fees          float64    `json:"fees"`
+	Fills         []Fill     `json:"fills,omitempty"`
+}
+
+// Type Ledger books fills into positions. It is safe for concurrent use.
+type Ledger struct {
+	lock   sync.Mutex
+	venues map[string]*Position
+	totals map[string]*Position
+	marks  map[string]float64
+	fills  []Fill
+	orders map[string]*trackedOrder
+
+	// KeepFills retains every fill for Fills and serialization.
+	KeepFills bool
+	// Fees estimates fees on fills booked from order status updates, which do
+	// not report them. Fills are assumed to take liquidity.
+	Fees sim.FeeModel
+	// Now returns the current time, used to stamp fills booked from order
+	// status updates and snapshots; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Function New creates an empty ledger.
+func New() *Ledger {
+	return &Ledger{
+		venues: map[string]*Position{},
+		totals: map[string]*Position{},
+		marks:  map[string]float64{},
+		orders: map[string]*trackedOrder{},
+		Now:    time.Now,
+	}
+}
+
+// Function Record books a fill.
+func (l *Ledger) Record(f Fill) error {
+	side := strings.ToUpper(f.Side)
+	if f.Quantity <= 0 || f.Price <= 0 || (side != routefire.SideBuy && side != routefire.SideSell) {
+		return ErrInvalidFill
+	}
+	f.Side = side
+	f.Asset, f.BaseAsset = strings.ToLower(f.Asset), strings.ToLower(f.BaseAsset)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.position(l.venues, f.Asset, f.BaseAsset, f.Venue).apply(side, f.Quantity, f.Price, f.Fee)
+	l.position(l.totals, f.Asset, f.BaseAsset, "").apply(side, f.Quantity, f.Price, f.Fee)
+	if l.KeepFills {
+		l.fills = append(l.fills, f)
+	}
+	return nil
+}
+
+func (l *Ledger) position(m map[string]*Position, asset, baseAsset, venue string) *Position {
+	key := positionKey(asset, baseAsset, venue)
+	p, ok := m[key]
+	if !ok {
+		p = &Position{Asset: asset, BaseAsset: baseAsset, Venue: venue, Mark: l.marks[positionKey(asset, baseAsset, "")]}
+		m[key] = p
+	}
+	return p
+}
+
+// Function Mark sets the mark price of a pair and revalues its positions.
+func (l *Ledger) Mark(asset, baseAsset string, price float64) {
+	asset, baseAsset = strings.ToLower(asset), strings.ToLower(baseAsset)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.marks[positionKey(asset, baseAsset, "")] = price
+	for _, m := range []map[string]*Position{l.venues, l.totals} {
+		for _, p := range m {
+			if p.Asset == asset && p.BaseAsset == baseAsset {
+				p.Mark = price
+				p.revalue()
+			}
+		}
+	}
+}
+
+// Function MarkBook marks a pair at the mid price of a consolidated book.
+func (l *Ledger) MarkBook(asset, baseAsset string, ob *routefire.DmaOrderBook) error {
+	mid, err := ob.MidPrice()
+	if err != nil {
+		return err
+	}
+	l.Mark(asset, baseAsset, mid)
+	return nil
+}
+
+// Function MarkAll fetches the consolidated book of every pair with a position
+// and marks it at the mid price. The first error is returned after all pairs
+// have been attempted.
+func (l *Ledger) MarkAll(api routefire.DMA, userId string) error {
+	var firstErr error
+	for _, p := range l.Totals() {
+		ob, err := api.GetConsolidatedOrderBookDMA(userId, p.Asset, p.BaseAsset)
+		if err == nil {
+			err = routefire.FirstDmaError(ob.Errors)
+		}
+		if err == nil {
+			err = l.MarkBook(p.Asset, p.BaseAsset, &ob.Data)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Function Positions returns every per-venue position, sorted by pair and venue.
+func (l *Ledger) Positions() []Position {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return sortedPositions(l.venues)
+}
+
+// Function Totals returns every position aggregated across venues, sorted by pair.
+func (l *Ledger) Totals() []Position {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return sortedPositions(l.totals)
+}
+
+// Function Position returns the position in a pair at a venue, or across all
+// venues if venue is empty.
+func (l *Ledger) Position(asset, baseAsset, venue string) Position {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	m := l.venues
+	if venue == "" {
+		m = l.totals
+	}
+	if p, ok := m[positionKey(strings.ToLower(asset), strings.ToLower(baseAsset), venue)]; ok {
+		return *p
+	}
+	return Position{Asset: asset, BaseAsset: baseAsset, Venue: venue}
+}
+
+// Function Fills returns the recorded fills, if KeepFills is set.
+func (l *Ledger) Fills() []Fill {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]Fill(nil), l.fills...)
+}
+
+// Function Snapshot returns the current state of the ledger. PnL totals are
+// summed across base assets, so they are only meaningful when all positions
+// share a base asset.
+func (l *Ledger) Snapshot() Snapshot {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s := Snapshot{
+		Time:      l.Now().UTC(),
+		Positions: sortedPositions(l.venues),
+		Totals:    sortedPositions(l.totals),
+		Fills:     append([]Fill(nil), l.fills...),
+	}
+	for _, p := range s.Totals {
+		s.RealizedPnL += p.RealizedPnL
+		s.UnrealizedPnL += p.UnrealizedPnL
+		s.Fees += p.Fees
+	}
+	return s
+}
+
+// Function Save writes a JSON snapshot of the ledger to w.
+func (l *Ledger) Save(w io.Writer) error {
+	s := l.Snapshot()
+	return json.NewEncoder(w).Encode(&s)
+}
+
+// Function Load restores a ledger from a JSON snapshot written by Save.
+func Load(r io.Reader) (*Ledger, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	l := New()
+	for _, p := range s.Positions {
+		p := p
+		l.venues[positionKey(p.Asset, p.BaseAsset, p.Venue)] = &p
+	}
+	for _, p := range s.Totals {
+		p := p
+		l.totals[positionKey(p.Asset, p.BaseAsset, "")] = &p
+		if p.Mark > 0 {
+			l.marks[positionKey(p.Asset, p.BaseAsset, "")] = p.Mark
+		}
+	}
+	l.fills = s.Fills
+	l.KeepFills = len(s.Fills) > 0
+	return l, nil
+}
+
+func sortedPositions(m map[string]*Position) []Position {
+	out := make([]Position, 0, len(m))
+	for _, p := range m {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return positionKey(out[i].Asset, out[i].BaseAsset, out[i].Venue) < positionKey(out[j].Asset, out[j].BaseAsset, out[j].Venue)
+	})
+	return out
+}
+
+func positionKey(asset, baseAsset, venue string) string {
+	return asset + "/" + baseAsset + "@" + strings.ToUpper(venue)
+}
@@ -0,0 +1,119 @@
+package ledger
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+func fill(venue, side string, qty, px, fee float64) Fill {
+	return Fill{Venue: venue, Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: side, Quantity: qty, Price: px, Fee: fee}
+}
+
+func TestAverageCostAndPnL(t *testing.T) {
+	l := New()
+	l.Record(fill(routefire.Gemini, routefire.SideBuy, 1, 100, 0.1))
+	l.Record(fill(routefire.Gemini, routefire.SideBuy, 3, 104, 0.3))
+	l.Record(fill(routefire.Kraken, routefire.SideSell, 2, 110, 0.2))
+
+	total := l.Position(routefire.Btc, routefire.Usd, "")
+	approx(t, "quantity", total.Quantity, 2)
+	approx(t, "avg cost", total.AvgCost, 103)
+	approx(t, "realized", total.RealizedPnL, 14)
+	approx(t, "fees", total.Fees, 0.6)
+
+	// Per venue, the Kraken sale opens a short rather than realizing PnL.
+	kraken := l.Position(routefire.Btc, routefire.Usd, routefire.Kraken)
+	approx(t, "kraken quantity", kraken.Quantity, -2)
+	approx(t, "kraken realized", kraken.RealizedPnL, 0)
+
+	l.MarkBook(routefire.Btc, routefire.Usd, &routefire.DmaOrderBook{
+		Bids:   []routefire.DmaOrderBookEntry{{Price: "107", Amount: "1"}},
+		Offers: []routefire.DmaOrderBookEntry{{Price: "109", Amount: "1"}},
+	})
+	total = l.Position(routefire.Btc, routefire.Usd, "")
+	approx(t, "unrealized", total.UnrealizedPnL, 10)
+	approx(t, "net", total.NetPnL(), 14+10-0.6)
+
+	// Selling through the position reverses it at the fill price.
+	l.Record(fill(routefire.Gemini, routefire.SideSell, 3, 100, 0))
+	total = l.Position(routefire.Btc, routefire.Usd, "")
+	approx(t, "reversed quantity", total.Quantity, -1)
+	approx(t, "reversed cost", total.AvgCost, 100)
+	approx(t, "realized after reversal", total.RealizedPnL, 14-6)
+	approx(t, "short unrealized", total.UnrealizedPnL, -8)
+
+	if err := l.Record(fill(routefire.Gemini, "HOLD", 1, 1, 0)); err != ErrInvalidFill {
+		t.Errorf("expected ErrInvalidFill, got %v", err)
+	}
+}
+
+func TestOrderUpdates(t *testing.T) {
+	l := New()
+	l.Fees = sim.FlatFees{routefire.Gemini: 0.01}
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	l.Now = func() time.Time { return now }
+	l.TrackDMA(routefire.Gemini, &routefire.PlaceDmaOrderResponse{VenueOrderId: "1"}, routefire.Btc, routefire.Usd, routefire.SideBuy, 100)
+
+	st := &routefire.DmaOrderStatusResponse{VenueId: routefire.Gemini, VenueOrderId: "1", FilledAmount: "0.5"}
+	if f, ok, err := l.UpdateDMA(st); err != nil || !ok || f.Quantity != 0.5 || f.Fee != 0.5 || !f.Time.Equal(now) {
+		t.Fatalf("unexpected fill %+v %v %v", f, ok, err)
+	}
+	if _, ok, _ := l.UpdateDMA(st); ok {
+		t.Errorf("an unchanged status should not book a fill")
+	}
+	st.FilledAmount = "1"
+	l.UpdateDMA(st)
+	approx(t, "dma quantity", l.Position(routefire.Btc, routefire.Usd, routefire.Gemini).Quantity, 1)
+
+	// Buying usd with btc is a sale of btc, booked at the mark without a venue.
+	l.TrackAlgo("algo-1", routefire.Usd, routefire.Btc)
+	l.Mark(routefire.Btc, routefire.Usd, 120)
+	if _, _, err := l.UpdateAlgo("algo-1", &routefire.OrderStatusResponse{Filled: "0.25"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	total := l.Position(routefire.Btc, routefire.Usd, "")
+	approx(t, "after algo", total.Quantity, 0.75)
+	approx(t, "algo realized", total.RealizedPnL, 5)
+
+	if _, _, err := l.UpdateAlgo("unknown", &routefire.OrderStatusResponse{Filled: "1"}, 1); err != ErrUntrackedOrder {
+		t.Errorf("expected ErrUntrackedOrder, got %v", err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	l := New()
+	l.KeepFills = true
+	l.Record(fill(routefire.Gemini, routefire.SideBuy, 2, 100, 1))
+	l.Mark(routefire.Btc, routefire.Usd, 105)
+
+	var buf bytes.Buffer
+	if err := l.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	l2, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := l2.Snapshot()
+	approx(t, "unrealized", s.UnrealizedPnL, 10)
+	approx(t, "fees", s.Fees, 1)
+	if len(s.Fills) != 1 || len(s.Positions) != 1 {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+
+	// Restored positions keep accruing.
+	l2.Record(fill(routefire.Gemini, routefire.SideSell, 2, 110, 0))
+	approx(t, "realized", l2.Position(routefire.Btc, routefire.Usd, routefire.Gemini).RealizedPnL, 20)
+}
@@ -0,0 +1,133 @@
+package ledger
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+var (
+	ErrUntrackedOrder = errors.New("ledger: order is not tracked")
+	ErrNoPrice        = errors.New("ledger: no fill price or mark available")
+)
+
+// trackedOrder remembers what is needed to turn status updates into fills.
+type trackedOrder struct {
+	venue     string
+	asset     string
+	baseAsset string
+	side      string
+	price     float64
+	filled    float64
+}
+
+// Function TrackDMA registers a DMA order submitted to venue so that its status
+// updates can be booked with UpdateDMA. The venue is taken from the caller,
+// since venues may not echo it in the response.
+func (l *Ledger) TrackDMA(venue string, resp *routefire.PlaceDmaOrderResponse, asset, baseAsset, side string, price float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.orders[dmaKey(venue, resp.VenueOrderId)] = &trackedOrder{
+		venue:     venue,
+		asset:     asset,
+		baseAsset: baseAsset,
+		side:      strings.ToUpper(side),
+		price:     price,
+	}
+}
+
+// Function UpdateDMA books any increase in the filled amount reported for a
+// tracked DMA order. The status API does not report fill prices, so fills are
+// booked at the order's limit price, and stamped with the ledger's Now.
+func (l *Ledger) UpdateDMA(st *routefire.DmaOrderStatusResponse) (Fill, bool, error) {
+	return l.update(dmaKey(st.VenueId, st.VenueOrderId), st.VenueOrderId, st.FilledAmount, 0)
+}
+
+// Function TrackAlgo registers a submitted Routefire (algorithm) order so that
+// its status updates can be booked with UpdateAlgo.
+func (l *Ledger) TrackAlgo(orderId, buyAsset, sellAsset string) {
+	asset, baseAsset, side := routefire.AlgoOrderPair(buyAsset, sellAsset)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.orders[algoKey(orderId)] = &trackedOrder{asset: asset, baseAsset: baseAsset, side: side}
+}
+
+// Function UpdateAlgo books any increase in the filled amount reported for a
+// tracked algorithm order at the given price. Algorithm orders may fill across
+// several venues, so they are booked without a venue. If price is zero the
+// pair's current mark is used.
+func (l *Ledger) UpdateAlgo(orderId string, st *routefire.OrderStatusResponse, price float64) (Fill, bool, error) {
+	return l.update(algoKey(orderId), orderId, st.Filled, price)
+}
+
+func (l *Ledger) update(key, orderId, filledAmount string, price float64) (Fill, bool, error) {
+	filled, err := strconv.ParseFloat(filledAmount, 64)
+	if err != nil {
+		return Fill{}, false, err
+	}
+
+	l.lock.Lock()
+	o, ok := l.orders[key]
+	if !ok {
+		l.lock.Unlock()
+		return Fill{}, false, ErrUntrackedOrder
+	}
+	delta := filled - o.filled
+	if delta <= epsilon {
+		l.lock.Unlock()
+		return Fill{}, false, nil
+	}
+	if price <= 0 {
+		price = o.price
+	}
+	if price <= 0 {
+		price = l.marks[positionKey(o.asset, o.baseAsset, "")]
+	}
+	if price <= 0 {
+		l.lock.Unlock()
+		return Fill{}, false, ErrNoPrice
+	}
+	o.filled = filled
+	f := Fill{
+		Time:      l.Now().UTC(),
+		OrderId:   orderId,
+		Venue:     o.venue,
+		Asset:     o.asset,
+		BaseAsset: o.baseAsset,
+		Side:      o.side,
+		Quantity:  delta,
+		Price:     price,
+	}
+	if l.Fees != nil {
+		f.Fee = l.Fees.Fee(o.venue, false, delta*price)
+	}
+	l.lock.Unlock()
+
+	return f, true, l.Record(f)
+}
+
+// Function FromSim converts a simulated fill from a backtest or paper client.
+func FromSim(f sim.Fill) Fill {
+	return Fill{
+		Time:      f.Time,
+		OrderId:   f.OrderID,
+		Venue:     f.Venue,
+		Asset:     f.Asset,
+		BaseAsset: f.BaseAsset,
+		Side:      f.Side,
+		Quantity:  f.Quantity,
+		Price:     f.Price,
+		Fee:       f.Fee,
+	}
+}
+
+func dmaKey(venue, venueOrderId string) string {
+	return "dma|" + strings.ToUpper(venue) + "|" + venueOrderId
+}
+
+func algoKey(orderId string) string {
+	return "algo|" + orderId
+}
@@ -0,0 +1,410 @@
+// Package monitor tracks Routefire (algorithm) orders against their schedule.
+// GetOrderStatus reports only a status and a filled quantity; the monitor
+// compares the filled fraction with the fraction expected from the order's
+// target_seconds, flags orders that fall behind, and can cancel a lagging
+// order and resubmit its remainder with a higher aggression.
+//
+// The monitor is driven by a strategy.Clock, so it runs live, with
+// strategy.RealClock, or in a backtest.
+package monitor
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+var (
+	ErrNoTarget        = errors.New("monitor: order has no target_seconds")
+	ErrUnknownOrder    = errors.New("monitor: unknown order")
+	ErrNotAccepted     = errors.New("monitor: order not accepted")
+	ErrInvalidQuantity = errors.New("monitor: quantity must be positive")
+)
+
+// Type Config configures a Monitor.
+type Config struct {
+	// Tolerance is how far, as a fraction of the order quantity, an order may
+	// fall behind its schedule before it is flagged. Defaults to 0.1.
+	Tolerance float64
+	// Resubmit enables cancelling lagging orders and resubmitting their
+	// remainder.
+	Resubmit bool
+	// AggressionStep is added to the aggression algo param on each
+	// resubmission, up to 1. Defaults to 0.25.
+	AggressionStep float64
+	// MaxResubmits limits the resubmissions of each order. Defaults to 3. A
+	// resubmitted order is resubmitted again only if it falls a further
+	// Tolerance behind its schedule.
+	MaxResubmits int
+	// Interval is the time between checks in Run. Defaults to one second.
+	Interval time.Duration
+}
+
+// Type Order is an algorithm order tracked by the monitor. After a
+// resubmission, OrderId is the id of the order working the remainder and
+// Filled includes what the orders it replaced had filled. The monitor hands out
+// copies, taken under its lock; call Order again for the latest state.
+type Order struct {
+	UserId    string
+	BuyAsset  string
+	SellAsset string
+	Algo      string
+	Params    map[string]string
+	Quantity  float64
+	Start     time.Time
+	Target    time.Duration
+
+	OrderId   string
+	History   []string // Ids of the orders replaced by resubmissions
+	Status    string
+	Filled    float64
+	Expected  float64 // Fraction of Quantity expected filled by now
+	Actual    float64 // Fraction of Quantity filled
+	Lagging   bool
+	Resubmits int
+	Err       error
+
+	priorFilled float64
+	resubmitLag float64
+}
+
+// Function Lag returns how far the order is behind its schedule, as a fraction
+// of its quantity. It is negative for an order ahead of schedule.
+func (o *Order) Lag() float64 {
+	return o.Expected - o.Actual
+}
+
+// Function Done reports whether the order is no longer working.
+func (o *Order) Done() bool {
+	return !routefire.IsOpenStatus(o.Status)
+}
+
+// Type Monitor tracks algorithm orders against their schedule.
+type Monitor struct {
+	api   routefire.API
+	clock strategy.Clock
+	cfg   Config
+
+	lock   sync.Mutex
+	orders map[string]*Order // By the id the order was first tracked under
+
+	// OnLag, if set, is called when an order falls behind its schedule.
+	OnLag func(*Order)
+	// OnResubmit, if set, is called after a lagging order is resubmitted, with
+	// the id of the order that was cancelled.
+	OnResubmit func(o *Order, cancelledId string)
+}
+
+// Function New creates a monitor for orders placed through api.
+func New(api routefire.API, clock strategy.Clock, cfg Config) *Monitor {
+	if cfg.Tolerance <= 0 {
+		cfg.Tolerance = 0.1
+	}
+	if cfg.AggressionStep <= 0 {
+		cfg.AggressionStep = 0.25
+	}
+	if cfg.MaxResubmits <= 0 {
+		cfg.MaxResubmits = 3
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	return &Monitor{api: api, clock: clock, cfg: cfg, orders: map[string]*Order{}}
+}
+
+// Function Submit submits an algorithm order and tracks it. The algo params
+// must include target_seconds.
+func (m *Monitor) Submit(userId, buyAsset, sellAsset, quantity, algo string, algoParams map[string]string) (*Order, error) {
+	o, err := newOrder(userId, buyAsset, sellAsset, quantity, algo, algoParams)
+	if err != nil {
+		return nil, err
+	}
+	o.Start = m.clock.Now()
+	resp, err := m.api.SubmitOrder(userId, buyAsset, sellAsset, quantity, "", algo, algoParams)
+	if err != nil {
+		return nil, err
+	}
+	if resp.OrderId == "" {
+		return nil, ErrNotAccepted
+	}
+	o.OrderId = resp.OrderId
+	return m.add(o), nil
+}
+
+// Function Track tracks an algorithm order already submitted at start with the
+// given algo params, which must include target_seconds.
+func (m *Monitor) Track(userId, orderId, buyAsset, sellAsset, quantity, algo string, algoParams map[string]string, start time.Time) (*Order, error) {
+	o, err := newOrder(userId, buyAsset, sellAsset, quantity, algo, algoParams)
+	if err != nil {
+		return nil, err
+	}
+	o.OrderId = orderId
+	o.Start = start
+	return m.add(o), nil
+}
+
+func newOrder(userId, buyAsset, sellAsset, quantity, algo string, algoParams map[string]string) (*Order, error) {
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return nil, err
+	}
+	if qty <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	secs, err := strconv.ParseFloat(algoParams["target_seconds"], 64)
+	if err != nil || secs <= 0 {
+		return nil, ErrNoTarget
+	}
+	params := make(map[string]string, len(algoParams))
+	for k, v := range algoParams {
+		params[k] = v
+	}
+	return &Order{
+		UserId:    userId,
+		BuyAsset:  buyAsset,
+		SellAsset: sellAsset,
+		Algo:      algo,
+		Params:    params,
+		Quantity:  qty,
+		Target:    time.Duration(secs * float64(time.Second)),
+		Status:    routefire.StatusOpen,
+	}, nil
+}
+
+// add tracks an order and returns a copy of it.
+func (m *Monitor) add(o *Order) *Order {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.orders[o.OrderId] = o
+	return o.copy()
+}
+
+func (o *Order) copy() *Order {
+	cp := *o
+	cp.History = append([]string(nil), o.History...)
+	return &cp
+}
+
+// Function Untrack stops tracking the order first tracked under orderId.
+func (m *Monitor) Untrack(orderId string) {
+	m.lock.Lock()
+	delete(m.orders, orderId)
+	m.lock.Unlock()
+}
+
+// Function Order returns the order first tracked under orderId.
+func (m *Monitor) Order(orderId string) (*Order, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	o, ok := m.orders[orderId]
+	if !ok {
+		return nil, ErrUnknownOrder
+	}
+	return o.copy(), nil
+}
+
+// Function Orders returns the tracked orders, oldest first.
+func (m *Monitor) Orders() []*Order {
+	m.lock.Lock()
+	out := make([]*Order, 0, len(m.orders))
+	for _, o := range m.orders {
+		out = append(out, o.copy())
+	}
+	m.lock.Unlock()
+	sortOrders(out)
+	return out
+}
+
+func sortOrders(out []*Order) {
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Start.Equal(out[j].Start) {
+			return out[i].Start.Before(out[j].Start)
+		}
+		return out[i].OrderId < out[j].OrderId
+	})
+}
+
+// Function Lagging returns the working orders that are behind schedule.
+func (m *Monitor) Lagging() []*Order {
+	var out []*Order
+	for _, o := range m.Orders() {
+		if o.Lagging && !o.Done() {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// Function Active returns the number of orders still working.
+func (m *Monitor) Active() int {
+	n := 0
+	for _, o := range m.Orders() {
+		if !o.Done() {
+			n++
+		}
+	}
+	return n
+}
+
+// Function Step requests the status of every working order and compares it
+// with its schedule, resubmitting lagging orders if configured to. The
+// monitor's lock is not held across API calls or callbacks.
+func (m *Monitor) Step() {
+	now := m.clock.Now()
+	m.lock.Lock()
+	var working []*Order
+	for _, o := range m.orders {
+		if !o.Done() {
+			working = append(working, o)
+		}
+	}
+	m.lock.Unlock()
+	sortOrders(working)
+	for _, o := range working {
+		m.check(o, now)
+	}
+}
+
+// Function Run steps the monitor every Interval until no tracked order is
+// working or the clock stops.
+func (m *Monitor) Run() {
+	for {
+		m.Step()
+		if m.Active() == 0 || !m.clock.Wait(m.cfg.Interval) {
+			return
+		}
+	}
+}
+
+// check updates an order from its status. Only Step changes tracked orders,
+// so it reads them without the lock and takes the lock to change them.
+func (m *Monitor) check(o *Order, now time.Time) {
+	st, err := m.api.GetOrderStatus(o.UserId, o.OrderId)
+	m.lock.Lock()
+	if err != nil {
+		o.Err = err
+		m.lock.Unlock()
+		return
+	}
+	o.Err = nil
+	m.update(o, st, now)
+	if o.Done() {
+		o.Lagging = false
+		m.lock.Unlock()
+		return
+	}
+	wasLagging := o.Lagging
+	o.Lagging = o.Lag() > m.cfg.Tolerance
+	resubmit := o.Lagging && m.cfg.Resubmit && o.Resubmits < m.cfg.MaxResubmits && (o.Resubmits == 0 || o.Lag() > o.resubmitLag+m.cfg.Tolerance)
+	cp := o.copy()
+	m.lock.Unlock()
+
+	if !cp.Lagging {
+		return
+	}
+	if !wasLagging && m.OnLag != nil {
+		m.OnLag(cp)
+	}
+	if resubmit {
+		m.resubmit(o, now)
+	}
+}
+
+// update records a status response and recomputes the schedule.
+func (m *Monitor) update(o *Order, st *routefire.OrderStatusResponse, now time.Time) {
+	o.Status = st.Status
+	if f, err := strconv.ParseFloat(st.Filled, 64); err == nil {
+		o.Filled = o.priorFilled + f
+	}
+	if o.Quantity > 0 {
+		o.Actual = o.Filled / o.Quantity
+	}
+	elapsed := now.Sub(o.Start)
+	o.Expected = math.Min(1, math.Max(0, float64(elapsed)/float64(o.Target)))
+	if o.Status == routefire.StatusFilled || o.Status == routefire.StatusComplete {
+		o.Expected = o.Actual
+	}
+}
+
+// resubmit cancels a lagging order and submits its remainder with a higher
+// aggression, targeting what is left of the original schedule.
+func (m *Monitor) resubmit(o *Order, now time.Time) {
+	cancelled := o.OrderId
+	st, err := m.api.CancelOrder(o.UserId, cancelled)
+	if err != nil {
+		m.lock.Lock()
+		o.Err = err
+		m.lock.Unlock()
+		return
+	}
+	// A fill may race the cancel, so take the latest filled amount reported.
+	if final, err := m.api.GetOrderStatus(o.UserId, cancelled); err == nil {
+		if filledOf(final) > filledOf(st) {
+			st = final
+		}
+	}
+	filled := o.priorFilled + filledOf(st)
+	// A remainder that rounds to zero cannot be resubmitted and counts as filled.
+	asset, _, _ := routefire.AlgoOrderPair(o.BuyAsset, o.SellAsset)
+	remaining := routefire.RoundQuantity(asset, o.Quantity-filled)
+	if remaining <= 0 {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		o.Filled = filled
+		o.Actual = filled / o.Quantity
+		o.Status = routefire.StatusFilled
+		o.Lagging = false
+		return
+	}
+
+	params := make(map[string]string, len(o.Params)+2)
+	for k, v := range o.Params {
+		params[k] = v
+	}
+	aggression, _ := strconv.ParseFloat(params["aggression"], 64)
+	params["aggression"] = routefire.FormatFloat(math.Min(1, aggression+m.cfg.AggressionStep))
+	left := o.Start.Add(o.Target).Sub(now)
+	if left < time.Second {
+		left = time.Second
+	}
+	params["target_seconds"] = strconv.Itoa(int(math.Ceil(left.Seconds())))
+
+	resp, err := m.api.SubmitOrder(o.UserId, o.BuyAsset, o.SellAsset, routefire.FormatQuantity(asset, remaining), "", o.Algo, params)
+	if err == nil && resp.OrderId == "" {
+		err = ErrNotAccepted
+	}
+	m.lock.Lock()
+	if err != nil {
+		defer m.lock.Unlock()
+		// The original order is cancelled; record its fills and stop.
+		o.Filled = filled
+		o.Actual = filled / o.Quantity
+		o.Status = routefire.StatusCancelled
+		o.Err = err
+		return
+	}
+	o.resubmitLag = o.Lag()
+	o.History = append(o.History, cancelled)
+	o.OrderId = resp.OrderId
+	o.Params = params
+	o.priorFilled = filled
+	o.Filled = filled
+	o.Status = routefire.StatusOpen
+	o.Resubmits++
+	cp := o.copy()
+	m.lock.Unlock()
+	if m.OnResubmit != nil {
+		m.OnResubmit(cp, cancelled)
+	}
+}
+
+func filledOf(st *routefire.OrderStatusResponse) float64 {
+	f, _ := strconv.ParseFloat(st.Filled, 64)
+	return f
+}
@@ -0,0 +1,160 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+const uid = "monitor@example.com"
+
+type fakeClock struct {
+	now   time.Time
+	ticks int
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Wait(d time.Duration) bool {
+	if c.ticks == 0 {
+		return false
+	}
+	c.ticks--
+	c.now = c.now.Add(d)
+	return true
+}
+
+// slowAlgos fills each order at its rate per status request, scaled by its
+// aggression plus one. Orders for zero are rejected.
+type slowAlgos struct {
+	routefire.API
+	rate   float64
+	seq    int
+	orders map[string]*slowOrder
+}
+
+type slowOrder struct {
+	quantity, filled float64
+	params           map[string]string
+	status           string
+}
+
+func (a *slowAlgos) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if quantity == "999" {
+		return &routefire.SubmitOrderResponse{}, nil
+	}
+	a.seq++
+	id := fmt.Sprintf("ALGO-%d", a.seq)
+	qty, _ := strconv.ParseFloat(quantity, 64)
+	a.orders[id] = &slowOrder{quantity: qty, params: algoParams, status: routefire.StatusOpen}
+	return &routefire.SubmitOrderResponse{OrderId: id}, nil
+}
+
+func (a *slowAlgos) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	o := a.orders[orderId]
+	if o.status == routefire.StatusOpen {
+		aggression, _ := strconv.ParseFloat(o.params["aggression"], 64)
+		o.filled = math.Min(o.quantity, o.filled+a.rate*(1+aggression))
+		if o.filled >= o.quantity {
+			o.status = routefire.StatusFilled
+		}
+	}
+	return &routefire.OrderStatusResponse{Status: o.status, Filled: routefire.FormatFloat(o.filled)}, nil
+}
+
+func (a *slowAlgos) CancelOrder(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	o := a.orders[orderId]
+	if o.status == routefire.StatusOpen {
+		o.status = routefire.StatusCancelled
+	}
+	return &routefire.OrderStatusResponse{Status: o.status, Filled: routefire.FormatFloat(o.filled)}, nil
+}
+
+func TestFlagsLaggingOrders(t *testing.T) {
+	api := &slowAlgos{rate: 0.4, orders: map[string]*slowOrder{}}
+	clock := &fakeClock{now: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), ticks: 3}
+	m := New(api, clock, Config{Interval: 10 * time.Second})
+	var lagged []*Order
+	m.OnLag = func(o *Order) { lagged = append(lagged, o) }
+
+	o, err := m.Submit(uid, routefire.Btc, routefire.Usd, "10", "rfxw", map[string]string{"target_seconds": "100", "aggression": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	o, _ = m.Order(o.OrderId)
+
+	// After 30s, 30% is expected but 4 * 0.4 = 1.6 of 10 is filled.
+	if math.Abs(o.Expected-0.3) > 1e-9 || math.Abs(o.Actual-0.16) > 1e-9 {
+		t.Errorf("unexpected schedule %f %f", o.Expected, o.Actual)
+	}
+	if !o.Lagging || len(lagged) != 1 || len(m.Lagging()) != 1 || o.Resubmits != 0 {
+		t.Errorf("expected one lagging order, got %+v", o)
+	}
+
+	if _, err := m.Submit(uid, routefire.Btc, routefire.Usd, "1", "rfxw", nil); err != ErrNoTarget {
+		t.Errorf("expected ErrNoTarget, got %v", err)
+	}
+	if _, err := m.Submit(uid, routefire.Btc, routefire.Usd, "0", "rfxw", map[string]string{"target_seconds": "100"}); err != ErrInvalidQuantity {
+		t.Errorf("expected ErrInvalidQuantity, got %v", err)
+	}
+	if _, err := m.Submit(uid, routefire.Btc, routefire.Usd, "999", "rfxw", map[string]string{"target_seconds": "100"}); err != ErrNotAccepted {
+		t.Errorf("expected ErrNotAccepted, got %v", err)
+	}
+}
+
+func TestResubmitsWithHigherAggression(t *testing.T) {
+	api := &slowAlgos{rate: 0.5, orders: map[string]*slowOrder{}}
+	clock := &fakeClock{now: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), ticks: 20}
+	m := New(api, clock, Config{Interval: 10 * time.Second, Resubmit: true, AggressionStep: 1})
+	var cancelled []string
+	m.OnResubmit = func(o *Order, id string) { cancelled = append(cancelled, id) }
+
+	o, err := m.Submit(uid, routefire.Btc, routefire.Usd, "10", "rfxw", map[string]string{"target_seconds": "100", "aggression": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	if o.OrderId != "ALGO-1" || o.Status != routefire.StatusOpen {
+		t.Errorf("Submit should return a copy, got %+v", o)
+	}
+	o, _ = m.Order(o.OrderId)
+
+	if o.Status != routefire.StatusFilled || math.Abs(o.Filled-10) > 1e-9 {
+		t.Fatalf("expected the order to complete, got %+v", o)
+	}
+	if o.Resubmits != 1 || len(cancelled) != 1 || cancelled[0] != "ALGO-1" || o.OrderId != "ALGO-2" {
+		t.Errorf("expected one resubmission, got %+v", o)
+	}
+	next := api.orders["ALGO-2"]
+	if next.params["aggression"] != "1" || next.params["target_seconds"] != "60" || next.quantity != 7.5 {
+		t.Errorf("unexpected resubmission %+v", next)
+	}
+	if api.orders["ALGO-1"].status != routefire.StatusCancelled {
+		t.Errorf("the lagging order should be cancelled")
+	}
+}
+
+func TestReadOrdersWhileRunning(t *testing.T) {
+	api := &slowAlgos{rate: 0.5, orders: map[string]*slowOrder{}}
+	clock := &fakeClock{now: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), ticks: 20}
+	m := New(api, clock, Config{Interval: 10 * time.Second, Resubmit: true})
+	if _, err := m.Submit(uid, routefire.Btc, routefire.Usd, "10", "rfxw", map[string]string{"target_seconds": "100"}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m.Active() > 0 {
+			for _, o := range m.Orders() {
+				_ = o.Lag()
+			}
+		}
+	}()
+	m.Run()
+	<-done
+}
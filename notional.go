@@ -0,0 +1,159 @@
+package routefire
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNotionalExceedsBook is returned when a book is too thin to spend or raise
+// a notional amount.
+var ErrNotionalExceedsBook = errors.New("routefire: notional exceeds the depth of the order book")
+
+// Type NotionalQuote sizes an order by notional: the quantity of the asset that
+// spends (for a buy) or raises (for a sell) a notional amount of the base
+// asset, swept through a book. Quantity is rounded down to the asset's
+// precision, and Notional, AvgPrice and LimitPrice are for that quantity.
+type NotionalQuote struct {
+	Side       string
+	Quantity   float64
+	Notional   float64 // Value of Quantity at book prices
+	AvgPrice   float64
+	LimitPrice float64 // Worst price taken
+}
+
+// Function ForVenue returns the entries of the book on venue.
+func (ob *DmaOrderBook) ForVenue(venue string) *DmaOrderBook {
+	out := &DmaOrderBook{}
+	for _, e := range ob.Bids {
+		if strings.EqualFold(e.Venue, venue) {
+			out.Bids = append(out.Bids, e)
+		}
+	}
+	for _, e := range ob.Offers {
+		if strings.EqualFold(e.Venue, venue) {
+			out.Offers = append(out.Offers, e)
+		}
+	}
+	return out
+}
+
+// Function QuoteNotional sweeps ob on side for notional of the base asset,
+// taking levels best price first, and returns the quantity of asset it buys or
+// sells. If the book is too thin, the quote for its whole depth is returned
+// with ErrNotionalExceedsBook.
+func QuoteNotional(ob *DmaOrderBook, asset, side string, notional float64) (*NotionalQuote, error) {
+	q := &NotionalQuote{Side: strings.ToUpper(side)}
+	remaining := notional
+	var raw float64
+	for _, e := range ob.SweepLevels(side) {
+		if remaining <= 1e-12 {
+			break
+		}
+		px, qty, err := e.Floats()
+		if err != nil || px <= 0 || qty <= 0 {
+			continue
+		}
+		if qty*px > remaining {
+			qty = remaining / px
+		}
+		raw += qty
+		remaining -= qty * px
+	}
+	if raw <= 0 {
+		return q, ErrEmptyBook
+	}
+
+	// Reprice the rounded quantity, which may no longer reach the last level.
+	q.Quantity = RoundQuantity(asset, raw)
+	left := q.Quantity
+	for _, e := range ob.SweepLevels(side) {
+		if left <= 1e-12 {
+			break
+		}
+		px, qty, err := e.Floats()
+		if err != nil || px <= 0 || qty <= 0 {
+			continue
+		}
+		if qty > left {
+			qty = left
+		}
+		q.Notional += qty * px
+		q.LimitPrice = px
+		left -= qty
+	}
+	if q.Quantity > 0 {
+		q.AvgPrice = q.Notional / q.Quantity
+	}
+	if remaining > 1e-9*notional {
+		return q, ErrNotionalExceedsBook
+	}
+	return q, nil
+}
+
+// Function SubmitNotionalDMA submits a DMA order on venue that spends (for a
+// buy) or raises (for a sell) notional of baseAsset. The quantity is swept from
+// the venue's entries in the consolidated book, and the order is limited at the
+// worst price taken. Orders the venue's book cannot fill are not submitted.
+func SubmitNotionalDMA(api DMA, userId, venue, asset, baseAsset, side string, notional float64, orderParams map[string]string) (*PlaceDmaOrderResponse, *NotionalQuote, error) {
+	ob, err := api.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+	if err == nil {
+		err = FirstDmaError(ob.Errors)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	q, err := QuoteNotional(ob.Data.ForVenue(venue), asset, side, notional)
+	if err != nil {
+		return nil, q, err
+	}
+	if q.Quantity <= 0 {
+		return nil, q, ErrEmptyBook
+	}
+	resp, err := api.SubmitOrderDMA(userId, venue, asset, baseAsset, side, FormatQuantity(asset, q.Quantity), FormatFloat(q.LimitPrice), orderParams)
+	return resp, q, err
+}
+
+// Function SubmitNotional submits an algorithm order to buy buyAsset with
+// sellAsset, sized by notional of the base asset of the market, as chosen by
+// AlgoOrderPair: spending notional usd on btc, or raising notional usd from
+// selling btc, for either direction of btc and usd. The quantity is swept from
+// the consolidated book across venues. Unless algoParams already set one, the
+// worst price taken is passed as the iwould limit.
+func SubmitNotional(api API, userId, buyAsset, sellAsset string, notional float64, algo string, algoParams map[string]string) (*SubmitOrderResponse, *NotionalQuote, error) {
+	asset, baseAsset, side := AlgoOrderPair(buyAsset, sellAsset)
+	ob, err := api.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+	if err == nil {
+		err = FirstDmaError(ob.Errors)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	q, err := QuoteNotional(&ob.Data, asset, side, notional)
+	if err != nil {
+		return nil, q, err
+	}
+	if q.Quantity <= 0 {
+		return nil, q, ErrEmptyBook
+	}
+	params := make(map[string]string, len(algoParams)+1)
+	for k, v := range algoParams {
+		params[k] = v
+	}
+	if _, ok := params["iwould"]; !ok {
+		params["iwould"] = FormatFloat(q.LimitPrice)
+	}
+	resp, err := api.SubmitOrder(userId, buyAsset, sellAsset, FormatQuantity(asset, q.Quantity), "", algo, params)
+	return resp, q, err
+}
+
+// Function SubmitNotionalDMA submits a DMA order sized by notional; see the
+// package function SubmitNotionalDMA.
+func (api *Client) SubmitNotionalDMA(userId, venue, asset, baseAsset, side string, notional float64, orderParams map[string]string) (*PlaceDmaOrderResponse, *NotionalQuote, error) {
+	return SubmitNotionalDMA(api, userId, venue, asset, baseAsset, side, notional, orderParams)
+}
+
+// Function SubmitNotional submits an algorithm order sized by notional; see
+// the package function SubmitNotional.
+func (api *Client) SubmitNotional(userId, buyAsset, sellAsset string, notional float64, algo string, algoParams map[string]string) (*SubmitOrderResponse, *NotionalQuote, error) {
+	return SubmitNotional(api, userId, buyAsset, sellAsset, notional, algo, algoParams)
+}
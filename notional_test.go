@@ -0,0 +1,90 @@
+package routefire
+
+import (
+	"math"
+	"testing"
+)
+
+// depthAPI serves a book several levels deep and records the orders placed.
+type depthAPI struct {
+	API
+	quantity, price string
+	params          map[string]string
+}
+
+func (d *depthAPI) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*DmaOrderBookResponse, error) {
+	return &DmaOrderBookResponse{Data: DmaOrderBook{
+		Bids: []DmaOrderBookEntry{
+			{Venue: Kraken, Price: "98", Amount: "3"},
+			{Venue: Gemini, Price: "99", Amount: "1"},
+		},
+		Offers: []DmaOrderBookEntry{
+			{Venue: Gemini, Price: "100", Amount: "1"},
+			{Venue: Kraken, Price: "101", Amount: "2"},
+			{Venue: Gemini, Price: "102", Amount: "5"},
+		},
+	}}, nil
+}
+
+func (d *depthAPI) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*PlaceDmaOrderResponse, error) {
+	d.quantity, d.price = quantity, price
+	return &PlaceDmaOrderResponse{VenueId: venue, VenueOrderId: "dma-1"}, nil
+}
+
+func (d *depthAPI) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*SubmitOrderResponse, error) {
+	d.quantity, d.params = quantity, algoParams
+	return &SubmitOrderResponse{OrderId: "algo-1"}, nil
+}
+
+func TestQuoteNotional(t *testing.T) {
+	d := &depthAPI{}
+	ob, _ := d.GetConsolidatedOrderBookDMA(uid, Eth, Usd)
+	// 100 usd buys one eth at 100, and the other 50 buy 50/101 at 101.
+	q, err := QuoteNotional(&ob.Data, Eth, SideBuy, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Quantity != 1.4950495 || q.LimitPrice != 101 {
+		t.Errorf("unexpected quote %+v", q)
+	}
+	if math.Abs(q.Notional-(100+0.4950495*101)) > 1e-9 || q.Notional > 150 {
+		t.Errorf("unexpected notional %f", q.Notional)
+	}
+
+	q, err = QuoteNotional(&ob.Data, Eth, SideBuy, 10000)
+	if err != ErrNotionalExceedsBook || q.Quantity != 8 || q.LimitPrice != 102 {
+		t.Errorf("expected the whole book with ErrNotionalExceedsBook, got %+v %v", q, err)
+	}
+
+	for _, c := range []struct {
+		asset string
+		qty   float64
+		want  string
+	}{{Usd, 10.129, "10.12"}, {Btc, 0.29, "0.29"}, {Btc, 1, "1"}, {Xrp, 12.3456789, "12.345678"}} {
+		if got := FormatQuantity(c.asset, c.qty); got != c.want {
+			t.Errorf("FormatQuantity(%s, %v): got %s, want %s", c.asset, c.qty, got, c.want)
+		}
+	}
+}
+
+func TestSubmitNotional(t *testing.T) {
+	d := &depthAPI{}
+	// Only Gemini's offers, at 100 and 102, are swept.
+	_, q, err := SubmitNotionalDMA(d, uid, Gemini, Eth, Usd, SideBuy, 150, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.quantity != "1.49019607" || d.price != "102" || q.Side != SideBuy {
+		t.Errorf("unexpected order %s @ %s", d.quantity, d.price)
+	}
+
+	// Buying usd with eth sells eth: raising 148 usd takes 99 from one eth and
+	// 49 from half an eth at 98.
+	_, q, err = SubmitNotional(d, uid, Usd, Eth, 148, "rfxw", map[string]string{"target_seconds": "60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.quantity != "1.5" || d.params["iwould"] != "98" || d.params["target_seconds"] != "60" || q.Side != SideSell {
+		t.Errorf("unexpected order %s %v", d.quantity, d.params)
+	}
+}
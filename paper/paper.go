@@ -0,0 +1,323 @@
+// Package paper provides a paper trading client. Market data calls are
+// forwarded to the live Routefire service, while order and balance calls are
+// simulated against the live books with virtual balances per venue, so a
+// strategy can be run against real-time data without sending orders.
+package paper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/sim"
+)
+
+var ErrUnknownOrder = errors.New("paper: unknown order")
+
+// Type Client is a paper trading client. It implements routefire.API.
+type Client struct {
+	live  routefire.API
+	exch  *sim.Exchange
+	lock  sync.Mutex
+	seq   int
+	algos map[string]*algoOrder
+
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// algoOrder is a simulated Routefire (algorithm) order. It is worked by sweeping
+// the consolidated book across venues, up to its iwould limit, each time its
+// status is requested. If target_seconds is given, fills are paced linearly
+// over that period. Each child order rests until it has reached its venue,
+// after the exchange's latency, and is then cancelled if not filled.
+type algoOrder struct {
+	id            string
+	asset         string
+	baseAsset     string
+	side          string
+	quantity      float64
+	limit         float64
+	targetSeconds float64
+	submitted     time.Time
+	children      []sim.Order
+	status        string
+}
+
+func (a *algoOrder) filled() float64 {
+	total := 0.0
+	for _, o := range a.children {
+		total += o.Filled
+	}
+	return total
+}
+
+// Function New creates a paper trading client reading market data from live.
+// Balances holds the initial virtual balances by venue then asset; if set,
+// orders that cannot be funded from the venue's balance are rejected.
+func New(live routefire.API, balances map[string]map[string]float64) *Client {
+	x := sim.NewExchange()
+	if balances != nil {
+		x.EnforceBalances = true
+		for venue, m := range balances {
+			for asset, amt := range m {
+				x.SetBalance(venue, asset, amt)
+			}
+		}
+	}
+	return &Client{live: live, exch: x, algos: map[string]*algoOrder{}, Now: time.Now}
+}
+
+// Function Exchange returns the simulated exchange, e.g. to configure latency
+// and fees or to inspect fills.
+func (c *Client) Exchange() *sim.Exchange {
+	return c.exch
+}
+
+// refreshBook fetches the live book for a pair and matches resting orders against it.
+func (c *Client) refreshBook(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	ob, err := c.live.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+	if err != nil {
+		return nil, err
+	}
+	if len(ob.Errors) == 0 {
+		book := ob.Data
+		c.exch.UpdateBook(c.Now(), asset, baseAsset, &book)
+	}
+	return ob, nil
+}
+
+//
+//  Forwarded market data calls
+//
+
+func (c *Client) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	return c.refreshBook(userId, asset, baseAsset)
+}
+
+func (c *Client) GetConsolidatedOrderBook(uid, buyAsset, sellAsset string) (*routefire.OrderBookResponse, error) {
+	return c.live.GetConsolidatedOrderBook(uid, buyAsset, sellAsset)
+}
+
+func (c *Client) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*routefire.InquiryResponse, error) {
+	return c.live.GetOrderBookStats(uid, buyAsset, sellAsset, quantity)
+}
+
+//
+//  Simulated DMA calls
+//
+
+func (c *Client) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	if _, err := c.refreshBook(userId, asset, baseAsset); err != nil {
+		return nil, err
+	}
+	return c.exch.SubmitDMA(c.Now(), venue, asset, baseAsset, side, quantity, price), nil
+}
+
+func (c *Client) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	if o, err := c.exch.Order(venue, venueOrdId); err == nil && o.IsOpen() {
+		if _, err := c.refreshBook(userId, o.Asset, o.BaseAsset); err != nil {
+			return nil, err
+		}
+	}
+	return c.exch.StatusDMA(venue, venueOrdId), nil
+}
+
+func (c *Client) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	return c.exch.CancelDMA(venue, venueOrdId), nil
+}
+
+func (c *Client) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	return c.exch.BalanceDMA(venue, assetId), nil
+}
+
+// Function GetBalances returns the virtual balance of an asset at each venue.
+func (c *Client) GetBalances(uid, asset string) (map[string]string, error) {
+	out := map[string]string{}
+	for venue := range c.exch.Balances() {
+		out[venue] = routefire.FormatFloat(c.exch.Balance(venue, asset))
+	}
+	return out, nil
+}
+
+//
+//  Simulated Routefire (algorithm) orders
+//
+
+func (c *Client) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil || qty <= 0 {
+		return &routefire.SubmitOrderResponse{}, nil
+	}
+	asset, baseAsset, side := routefire.AlgoOrderPair(buyAsset, sellAsset)
+	a := &algoOrder{
+		asset:     asset,
+		baseAsset: baseAsset,
+		side:      side,
+		quantity:  qty,
+		submitted: c.Now(),
+		status:    routefire.StatusOpen,
+	}
+	if s, ok := algoParams["iwould"]; ok {
+		if a.limit, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("paper: invalid iwould %q", s)
+		}
+	}
+	if s, ok := algoParams["target_seconds"]; ok {
+		if a.targetSeconds, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("paper: invalid target_seconds %q", s)
+		}
+	}
+
+	c.lock.Lock()
+	c.seq++
+	a.id = fmt.Sprintf("PAPER-%06d", c.seq)
+	c.algos[a.id] = a
+	c.lock.Unlock()
+
+	if err := c.work(userId, a); err != nil {
+		return nil, err
+	}
+	return &routefire.SubmitOrderResponse{OrderId: a.id}, nil
+}
+
+func (c *Client) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	a, err := c.algoOrder(orderId)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.work(userId, a); err != nil {
+		return nil, err
+	}
+	return c.algoStatus(a), nil
+}
+
+func (c *Client) CancelOrder(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	a, err := c.algoOrder(orderId)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	if a.status == routefire.StatusOpen || a.status == routefire.StatusPartiallyFilled {
+		a.status = routefire.StatusCancelled
+		for i, o := range a.children {
+			if o.IsOpen() {
+				if cancelled, err := c.exch.Cancel(o.Venue, o.ID); err == nil {
+					a.children[i] = cancelled
+				}
+			}
+		}
+	}
+	c.lock.Unlock()
+	return c.algoStatus(a), nil
+}
+
+func (c *Client) algoOrder(orderId string) (*algoOrder, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	a, ok := c.algos[orderId]
+	if !ok {
+		return nil, ErrUnknownOrder
+	}
+	return a, nil
+}
+
+func (c *Client) algoStatus(a *algoOrder) *routefire.OrderStatusResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return &routefire.OrderStatusResponse{
+		Status: a.status,
+		Filled: routefire.FormatFloat(a.filled()),
+	}
+}
+
+// work sweeps the current book for as much of the order as its schedule allows.
+// Child orders are immediate-or-cancel once they reach their venue: any
+// remainder still open after the exchange's latency is cancelled. The live book
+// is fetched before the client's lock is taken.
+func (c *Client) work(userId string, a *algoOrder) error {
+	c.lock.Lock()
+	open := a.status == routefire.StatusOpen || a.status == routefire.StatusPartiallyFilled
+	c.lock.Unlock()
+	if !open {
+		return nil
+	}
+	if _, err := c.refreshBook(userId, a.asset, a.baseAsset); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if a.status != routefire.StatusOpen && a.status != routefire.StatusPartiallyFilled {
+		return nil
+	}
+	now := c.Now()
+	pending := 0.0
+	for i, o := range a.children {
+		if !o.IsOpen() {
+			continue
+		}
+		if current, err := c.exch.Order(o.Venue, o.ID); err == nil {
+			o = current
+		}
+		if o.IsOpen() && !o.ActiveAt.After(now) {
+			if cancelled, err := c.exch.Cancel(o.Venue, o.ID); err == nil {
+				o = cancelled
+			}
+		}
+		a.children[i] = o
+		if o.IsOpen() {
+			pending += o.Remaining()
+		}
+	}
+
+	allowed := a.quantity
+	if a.targetSeconds > 0 {
+		elapsed := now.Sub(a.submitted).Seconds()
+		allowed = a.quantity * math.Min(1, elapsed/a.targetSeconds)
+	}
+	need := allowed - a.filled() - pending
+
+	ob, ok := c.exch.Book(a.asset, a.baseAsset)
+	if ok && need > 0 {
+		for _, e := range ob.SweepLevels(a.side) {
+			if need <= 1e-12 {
+				break
+			}
+			px, qty, err := e.Floats()
+			if err != nil {
+				continue
+			}
+			if a.limit > 0 && ((a.side == routefire.SideBuy && px > a.limit) || (a.side == routefire.SideSell && px < a.limit)) {
+				break
+			}
+			o, err := c.exch.Submit(now, e.Venue, a.asset, a.baseAsset, a.side, math.Min(qty, need), px)
+			if err != nil {
+				continue
+			}
+			if o.IsOpen() && !o.ActiveAt.After(now) {
+				if cancelled, err := c.exch.Cancel(o.Venue, o.ID); err == nil {
+					o = cancelled
+				}
+			}
+			a.children = append(a.children, o)
+			need -= o.Filled
+			if o.IsOpen() {
+				need -= o.Remaining()
+			}
+		}
+	}
+
+	if filled := a.filled(); filled >= a.quantity-1e-12 {
+		a.status = routefire.StatusFilled
+	} else if filled > 0 {
+		a.status = routefire.StatusPartiallyFilled
+	}
+	return nil
+}
+
+var _ routefire.API = (*Client)(nil)
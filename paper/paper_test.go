@@ -0,0 +1,127 @@
+package paper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+const uid = "paper@example.com"
+
+// liveStub serves a fixed consolidated book and counts forwarded data calls.
+type liveStub struct {
+	routefire.API
+	book       routefire.DmaOrderBook
+	statsCalls int
+}
+
+func (s *liveStub) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	return &routefire.DmaOrderBookResponse{Data: s.book}, nil
+}
+
+func (s *liveStub) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*routefire.InquiryResponse, error) {
+	s.statsCalls++
+	return &routefire.InquiryResponse{IsoCost: 1}, nil
+}
+
+func newStub() *liveStub {
+	return &liveStub{book: routefire.DmaOrderBook{
+		Bids: []routefire.DmaOrderBookEntry{
+			{Venue: routefire.Gemini, Price: "99", Amount: "1"},
+		},
+		Offers: []routefire.DmaOrderBookEntry{
+			{Venue: routefire.Gemini, Price: "100", Amount: "1"},
+			{Venue: routefire.Kraken, Price: "101", Amount: "1"},
+			{Venue: routefire.Gemini, Price: "103", Amount: "5"},
+		},
+	}}
+}
+
+func TestDMAOrdersAgainstLiveBook(t *testing.T) {
+	live := newStub()
+	c := New(live, map[string]map[string]float64{routefire.Gemini: {routefire.Usd: 1000}})
+
+	resp, err := c.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "2", "99.5", nil)
+	if err != nil || len(resp.Errors) > 0 {
+		t.Fatalf("submit failed: %v %+v", err, resp)
+	}
+	st, _ := c.OrderStatusDMA(uid, routefire.Gemini, resp.VenueOrderId)
+	if st.Status != routefire.StatusOpen {
+		t.Fatalf("order below the offer should rest, got %+v", st)
+	}
+
+	// The live offer drops through our bid.
+	live.book.Offers[0].Price = "99"
+	st, _ = c.OrderStatusDMA(uid, routefire.Gemini, resp.VenueOrderId)
+	if st.Status != routefire.StatusPartiallyFilled || st.FilledAmount != "1" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	c.CancelOrderDMA(uid, routefire.Gemini, resp.VenueOrderId)
+
+	bal, _ := c.BalanceDMA(uid, routefire.Gemini, routefire.Btc)
+	if bal.Amount != "1" {
+		t.Errorf("expected 1 btc, got %s", bal.Amount)
+	}
+	bals, _ := c.GetBalances(uid, routefire.Usd)
+	if bals[routefire.Gemini] != "900.5" {
+		t.Errorf("expected 900.5 usd, got %+v", bals)
+	}
+
+	c.GetOrderBookStats(uid, routefire.Btc, routefire.Usd, "1")
+	if live.statsCalls != 1 {
+		t.Errorf("GetOrderBookStats should be forwarded")
+	}
+}
+
+func TestAlgoOrderSweepsVenuesUpToLimit(t *testing.T) {
+	c := New(newStub(), nil)
+	resp, err := c.SubmitOrder(uid, routefire.Btc, routefire.Usd, "3", "", "rfxw", map[string]string{"iwould": "102"})
+	if err != nil || resp.OrderId == "" {
+		t.Fatalf("submit failed: %v %+v", err, resp)
+	}
+	st, _ := c.GetOrderStatus(uid, resp.OrderId)
+	if st.Status != routefire.StatusPartiallyFilled || st.Filled != "2" {
+		t.Fatalf("expected 2 filled within the limit, got %+v", st)
+	}
+	fills := c.Exchange().Fills()
+	if len(fills) != 2 || fills[0].Venue != routefire.Gemini || fills[1].Venue != routefire.Kraken {
+		t.Errorf("unexpected fills %+v", fills)
+	}
+	st, _ = c.CancelOrder(uid, resp.OrderId)
+	if st.Status != routefire.StatusCancelled {
+		t.Errorf("expected cancelled, got %+v", st)
+	}
+
+	// Buying usd with btc sells btc into the bids.
+	resp, _ = c.SubmitOrder(uid, routefire.Usd, routefire.Btc, "1", "", "rfxw", nil)
+	st, _ = c.GetOrderStatus(uid, resp.OrderId)
+	if st.Status != routefire.StatusFilled {
+		t.Errorf("expected filled, got %+v", st)
+	}
+	if bal, _ := c.BalanceDMA(uid, routefire.Gemini, routefire.Usd); bal.Amount != "-1" {
+		t.Errorf("unexpected usd balance %s", bal.Amount)
+	}
+}
+
+func TestAlgoChildrenRestForLatency(t *testing.T) {
+	c := New(newStub(), nil)
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.Now = func() time.Time { return now }
+	c.Exchange().Latency = time.Second
+
+	resp, _ := c.SubmitOrder(uid, routefire.Btc, routefire.Usd, "2", "", "rfxw", map[string]string{"iwould": "101"})
+	st, _ := c.GetOrderStatus(uid, resp.OrderId)
+	if st.Status != routefire.StatusOpen || st.Filled != "0" {
+		t.Fatalf("children should still be in flight, got %+v", st)
+	}
+	if n := len(c.Exchange().Orders()); n != 2 {
+		t.Fatalf("in-flight children should not be resubmitted, got %d orders", n)
+	}
+
+	now = now.Add(time.Second)
+	st, _ = c.GetOrderStatus(uid, resp.OrderId)
+	if st.Status != routefire.StatusFilled || st.Filled != "2" {
+		t.Errorf("expected the children to fill after the latency, got %+v", st)
+	}
+}
@@ -0,0 +1,273 @@
+// Package peg watches the usd peg of stablecoins. A Monitor prices each
+// stablecoin from its consolidated book against usd: the mid and its deviation
+// from par, and the depth-weighted prices at which a set amount could be sold
+// or bought. It raises alerts as deviations cross their thresholds and again
+// when a coin recovers.
+//
+// The monitor is driven by a strategy.Clock, so it runs live, with
+// strategy.RealClock, or in a backtest.
+package peg
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/books"
+	"github.com/routefire/go-routefire/fees"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+const (
+	// AlertDepeg is raised when the mid deviates from par beyond Threshold.
+	AlertDepeg = "depeg"
+	// AlertExecutable is raised when selling or buying Size would average a
+	// price beyond ExecutableThreshold from par, or the book cannot fill it.
+	AlertExecutable = "executable"
+	// AlertUnpriced is raised when the book cannot be fetched or is empty.
+	AlertUnpriced = "unpriced"
+	// AlertRecovered is raised when every alert on a coin has cleared.
+	AlertRecovered = "recovered"
+)
+
+// Type Config configures a Monitor.
+type Config struct {
+	// Assets are the stablecoins watched. Defaults to usdt, usdc, tusd, gusd,
+	// dai and pax.
+	Assets []string
+	// Quote is the currency the coins are pegged to. Defaults to usd.
+	Quote string
+	// Threshold is the deviation of the mid from par, as a fraction, that
+	// raises AlertDepeg. Defaults to 0.005.
+	Threshold float64
+	// Size is the amount of each coin priced through the book. Defaults to
+	// 100000.
+	Size float64
+	// ExecutableThreshold is the deviation of the depth-weighted prices from
+	// par that raises AlertExecutable. Defaults to 0.01.
+	ExecutableThreshold float64
+	// Interval is the time between checks in Run. Defaults to ten seconds.
+	Interval time.Duration
+}
+
+// Type Price is a stablecoin priced in the quote currency. SellPrice and
+// BuyPrice are the average prices, after taker fees, of selling and buying
+// Size through the consolidated book; they are over less than Size when Thin.
+type Price struct {
+	Asset     string
+	Time      time.Time
+	Bid       float64
+	Offer     float64
+	Mid       float64
+	Deviation float64 // Mid less par, as a fraction of par
+	SellPrice float64
+	BuyPrice  float64
+	Thin      bool
+	Err       error
+}
+
+// Function ExecutableDeviation returns the larger deviation of the
+// depth-weighted prices from par: how far below par selling Size averages, or
+// how far above par buying it does.
+func (p *Price) ExecutableDeviation() float64 {
+	dev := 0.0
+	if p.SellPrice > 0 {
+		dev = 1 - p.SellPrice
+	}
+	if p.BuyPrice > 0 && p.BuyPrice-1 > dev {
+		dev = p.BuyPrice - 1
+	}
+	return dev
+}
+
+// Type Alert is raised when a coin's price crosses a threshold, or recovers.
+type Alert struct {
+	Time  time.Time
+	Kind  string
+	Asset string
+	Price Price
+}
+
+// Type Monitor prices stablecoins against their peg on each check.
+type Monitor struct {
+	api    routefire.DMA
+	userId string
+	clock  strategy.Clock
+	cfg    Config
+	books  *books.Builder
+
+	lock   sync.Mutex
+	prices map[string]*Price
+	active map[string]map[string]bool // Alert kinds raised, by asset
+
+	// Fees are the taker fees included in the depth-weighted prices. Nil is
+	// fee-free.
+	Fees *fees.Schedule
+	// OnAlert, if set, is called for each alert raised.
+	OnAlert func(Alert)
+}
+
+// Function New creates a monitor pricing books fetched for userId.
+func New(api routefire.DMA, userId string, clock strategy.Clock, cfg Config) *Monitor {
+	if len(cfg.Assets) == 0 {
+		cfg.Assets = append([]string(nil), routefire.Stablecoins...)
+	}
+	if cfg.Quote == "" {
+		cfg.Quote = routefire.Usd
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 0.005
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 100000
+	}
+	if cfg.ExecutableThreshold <= 0 {
+		cfg.ExecutableThreshold = 0.01
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	return &Monitor{
+		api:    api,
+		userId: userId,
+		clock:  clock,
+		cfg:    cfg,
+		books:  books.NewBuilder(api, userId),
+		prices: map[string]*Price{},
+		active: map[string]map[string]bool{},
+	}
+}
+
+// Function Check prices every coin concurrently and returns the alerts raised,
+// after calling OnAlert for each.
+func (m *Monitor) Check() []Alert {
+	now := m.clock.Now()
+	prices := make([]*Price, len(m.cfg.Assets))
+	var wg sync.WaitGroup
+	for i, asset := range m.cfg.Assets {
+		wg.Add(1)
+		go func(i int, asset string) {
+			defer wg.Done()
+			prices[i] = m.price(strings.ToLower(asset), now)
+		}(i, asset)
+	}
+	wg.Wait()
+
+	var alerts []Alert
+	m.lock.Lock()
+	for _, p := range prices {
+		m.prices[p.Asset] = p
+		alerts = append(alerts, m.transitions(p)...)
+	}
+	m.lock.Unlock()
+	if m.OnAlert != nil {
+		for _, a := range alerts {
+			m.OnAlert(a)
+		}
+	}
+	return alerts
+}
+
+// Function Run checks every interval until the clock stops.
+func (m *Monitor) Run() {
+	for {
+		m.Check()
+		if !m.clock.Wait(m.cfg.Interval) {
+			return
+		}
+	}
+}
+
+// Function Price returns the latest price of asset, or nil before it has been
+// checked.
+func (m *Monitor) Price(asset string) *Price {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.prices[strings.ToLower(asset)]
+}
+
+// Function Prices returns the latest price of every coin checked, by asset.
+func (m *Monitor) Prices() []*Price {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	out := make([]*Price, 0, len(m.prices))
+	for _, p := range m.prices {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Asset < out[j].Asset })
+	return out
+}
+
+// Function Alerting returns the alert kinds currently raised on asset.
+func (m *Monitor) Alerting(asset string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var out []string
+	for kind := range m.active[strings.ToLower(asset)] {
+		out = append(out, kind)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (m *Monitor) price(asset string, now time.Time) *Price {
+	p := &Price{Asset: asset, Time: now}
+	ob, err := m.books.Book(asset, m.cfg.Quote)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	bid, bidErr := ob.BestBid()
+	offer, offerErr := ob.BestOffer()
+	if bidErr != nil || offerErr != nil {
+		p.Err = routefire.ErrEmptyBook
+		return p
+	}
+	if p.Bid, _, err = bid.Floats(); err == nil {
+		p.Offer, _, err = offer.Floats()
+	}
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	p.Mid = (p.Bid + p.Offer) / 2
+	p.Deviation = p.Mid - 1
+
+	sell, sellErr := m.Fees.Sweep(ob, routefire.SideSell, m.cfg.Size)
+	buy, buyErr := m.Fees.Sweep(ob, routefire.SideBuy, m.cfg.Size)
+	p.SellPrice, p.BuyPrice = sell.NetAvgPrice(), buy.NetAvgPrice()
+	p.Thin = sellErr != nil || buyErr != nil
+	return p
+}
+
+// transitions updates the alerts raised on p's coin and returns those newly
+// raised, and AlertRecovered once none remain.
+func (m *Monitor) transitions(p *Price) []Alert {
+	now := map[string]bool{}
+	switch {
+	case p.Err != nil:
+		now[AlertUnpriced] = true
+	default:
+		if math.Abs(p.Deviation) > m.cfg.Threshold {
+			now[AlertDepeg] = true
+		}
+		if p.Thin || p.ExecutableDeviation() > m.cfg.ExecutableThreshold {
+			now[AlertExecutable] = true
+		}
+	}
+	prev := m.active[p.Asset]
+	var out []Alert
+	for _, kind := range []string{AlertUnpriced, AlertDepeg, AlertExecutable} {
+		if now[kind] && !prev[kind] {
+			out = append(out, Alert{Time: p.Time, Kind: kind, Asset: p.Asset, Price: *p})
+		}
+	}
+	if len(prev) > 0 && len(now) == 0 {
+		out = append(out, Alert{Time: p.Time, Kind: AlertRecovered, Asset: p.Asset, Price: *p})
+	}
+	m.active[p.Asset] = now
+	return out
+}
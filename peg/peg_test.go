@@ -0,0 +1,81 @@
+package peg
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+)
+
+const uid = "peg@example.com"
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+func coin(asset string, secs int, bid, bidQty, offer string) backtest.Snapshot {
+	return backtest.Snapshot{
+		Time:      time.Date(2019, 6, 1, 12, 0, secs, 0, time.UTC),
+		Asset:     asset,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Kraken, Price: bid, Amount: bidQty}},
+			Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Kraken, Price: offer, Amount: "1000000"}},
+		},
+	}
+}
+
+func TestPegAlerts(t *testing.T) {
+	bt := backtest.New([]backtest.Snapshot{
+		coin(routefire.Usdt, 0, "0.999", "1000000", "1.001"),
+		// Usdc bids only 50000, half the size priced.
+		coin(routefire.Usdc, 0, "0.9995", "50000", "1.0005"),
+		coin(routefire.Usdt, 10, "0.985", "1000000", "0.987"),
+		coin(routefire.Usdt, 20, "0.999", "1000000", "1.001"),
+	}, backtest.Config{})
+	bt.Step()
+	bt.Step()
+	m := New(bt, uid, bt, Config{Assets: []string{routefire.Usdt, routefire.Usdc, routefire.Dai}})
+	var alerts []Alert
+	m.OnAlert = func(a Alert) { alerts = append(alerts, a) }
+	m.Run()
+
+	want := []struct{ asset, kind string }{
+		{routefire.Usdc, AlertExecutable},
+		{routefire.Dai, AlertUnpriced},
+		{routefire.Usdt, AlertDepeg},
+		{routefire.Usdt, AlertExecutable},
+		{routefire.Usdt, AlertRecovered},
+	}
+	if len(alerts) != len(want) {
+		t.Fatalf("expected %d alerts, got %+v", len(want), alerts)
+	}
+	for i, w := range want {
+		if alerts[i].Asset != w.asset || alerts[i].Kind != w.kind {
+			t.Errorf("alert %d: got %s %s, want %s %s", i, alerts[i].Asset, alerts[i].Kind, w.asset, w.kind)
+		}
+	}
+	depeg := alerts[2].Price
+	approx(t, "deviation", depeg.Deviation, -0.014)
+	approx(t, "sell price", depeg.SellPrice, 0.985)
+	if !alerts[2].Time.Equal(time.Date(2019, 6, 1, 12, 0, 10, 0, time.UTC)) {
+		t.Errorf("unexpected alert time %v", alerts[2].Time)
+	}
+
+	usdc := m.Price(routefire.Usdc)
+	if !usdc.Thin || usdc.Err != nil {
+		t.Errorf("expected a thin usdc book, got %+v", usdc)
+	}
+	approx(t, "usdc mid", usdc.Mid, 1)
+	if got := m.Alerting(routefire.Usdc); len(got) != 1 || got[0] != AlertExecutable {
+		t.Errorf("expected usdc still alerting, got %v", got)
+	}
+	if len(m.Alerting(routefire.Usdt)) != 0 || len(m.Prices()) != 3 {
+		t.Errorf("unexpected state %v %v", m.Alerting(routefire.Usdt), m.Prices())
+	}
+}
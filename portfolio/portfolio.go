@@ -0,0 +1,388 @@
+// Package portfolio aggregates balances across venues and assets. A Portfolio
+// fans out balance queries concurrently (GetBalances per asset, or BalanceDMA
+// per venue and asset for DMA accounts), totals each asset across venues and
+// values everything in a quote currency from the consolidated books. Results
+// are cached for a refresh interval.
+package portfolio
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+var (
+	ErrNoAssets = errors.New("portfolio: no assets configured")
+	ErrNoVenues = errors.New("portfolio: DMA balances need the venues to query")
+	ErrNoPrice  = errors.New("portfolio: no price for asset")
+)
+
+const epsilon = 1e-12
+
+// Type Config configures a Portfolio.
+type Config struct {
+	// Assets are the assets held.
+	Assets []string
+	// Venues are the venues queried. With GetBalances, nil keeps every venue
+	// reported; with BalanceDMA it is required.
+	Venues []string
+	// Quote is the currency holdings are valued in. Defaults to usd.
+	Quote string
+	// Bridges are the assets used to price an asset that has no book against
+	// Quote, through asset/bridge and bridge/Quote. Defaults to btc.
+	Bridges []string
+	// RefreshInterval is how long a snapshot is served from the cache.
+	// Defaults to 30 seconds.
+	RefreshInterval time.Duration
+	// Concurrency limits the requests in flight. Defaults to 8.
+	Concurrency int
+}
+
+// Type Holding is the balance of one asset at one venue.
+type Holding struct {
+	Venue  string  `json:"venue"`
+	Asset  string  `json:"asset"`
+	Amount float64 `json:"amount"`
+	Value  float64 `json:"value"`
+}
+
+// Type Total is the balance of one asset across venues. Weight is its share
+// of the portfolio's value.
+type Total struct {
+	Asset  string             `json:"asset"`
+	Amount float64            `json:"amount"`
+	Price  float64            `json:"price"`
+	Value  float64            `json:"value"`
+	Weight float64            `json:"weight"`
+	Venues map[string]float64 `json:"venues"`
+}
+
+// Type Snapshot is the portfolio at a point in time, valued in Quote. Assets
+// that could not be priced are listed in Unpriced and valued at zero.
+type Snapshot struct {
+	Time     time.Time          `json:"time"`
+	Quote    string             `json:"quote"`
+	Holdings []Holding          `json:"holdings"`
+	Totals   []Total            `json:"totals"`
+	ByVenue  map[string]float64 `json:"by_venue"`
+	Value    float64            `json:"value"`
+	Unpriced []string           `json:"unpriced,omitempty"`
+}
+
+// Function Total returns the total for an asset, which is zero if it is not
+// held.
+func (s *Snapshot) Total(asset string) Total {
+	asset = strings.ToLower(asset)
+	for _, t := range s.Totals {
+		if t.Asset == asset {
+			return t
+		}
+	}
+	return Total{Asset: asset}
+}
+
+// Type Portfolio reads and values balances for a user.
+type Portfolio struct {
+	dma    routefire.DMA
+	api    routefire.API
+	userId string
+	cfg    Config
+
+	lock   sync.Mutex
+	cached *Snapshot
+
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Function New creates a portfolio reading balances with GetBalances.
+func New(api routefire.API, userId string, cfg Config) *Portfolio {
+	p := NewDMA(api, userId, cfg)
+	p.api = api
+	return p
+}
+
+// Function NewDMA creates a portfolio reading balances with BalanceDMA, for
+// each configured venue and asset.
+func NewDMA(api routefire.DMA, userId string, cfg Config) *Portfolio {
+	if cfg.Quote == "" {
+		cfg.Quote = routefire.Usd
+	}
+	cfg.Quote = strings.ToLower(cfg.Quote)
+	if cfg.Bridges == nil {
+		cfg.Bridges = []string{routefire.Btc}
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = 30 * time.Second
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 8
+	}
+	return &Portfolio{dma: api, userId: userId, cfg: cfg, Now: time.Now}
+}
+
+// Function Config returns the portfolio's configuration, with defaults
+// applied.
+func (p *Portfolio) Config() Config {
+	return p.cfg
+}
+
+// Function Snapshot returns the cached snapshot if it is younger than the
+// refresh interval, and a fresh one otherwise.
+func (p *Portfolio) Snapshot() (*Snapshot, error) {
+	p.lock.Lock()
+	cached := p.cached
+	p.lock.Unlock()
+	if cached != nil && p.Now().Sub(cached.Time) < p.cfg.RefreshInterval {
+		return cached, nil
+	}
+	return p.Refresh()
+}
+
+// Function Invalidate drops the cached snapshot, for example after trading.
+func (p *Portfolio) Invalidate() {
+	p.lock.Lock()
+	p.cached = nil
+	p.lock.Unlock()
+}
+
+// Function Refresh queries every balance and price and caches the result. If
+// any query fails, the snapshot built from the rest is returned with the first
+// error, and is not cached.
+func (p *Portfolio) Refresh() (*Snapshot, error) {
+	if len(p.cfg.Assets) == 0 {
+		return nil, ErrNoAssets
+	}
+	holdings, err := p.balances()
+	prices, unpriced := p.prices(holdings)
+
+	s := &Snapshot{Time: p.Now(), Quote: p.cfg.Quote, ByVenue: map[string]float64{}, Unpriced: unpriced}
+	totals := map[string]*Total{}
+	for _, h := range holdings {
+		h.Value = h.Amount * prices[h.Asset]
+		s.Holdings = append(s.Holdings, h)
+		s.ByVenue[h.Venue] += h.Value
+		s.Value += h.Value
+		t, ok := totals[h.Asset]
+		if !ok {
+			t = &Total{Asset: h.Asset, Price: prices[h.Asset], Venues: map[string]float64{}}
+			totals[h.Asset] = t
+		}
+		t.Amount += h.Amount
+		t.Value += h.Value
+		t.Venues[h.Venue] += h.Amount
+	}
+	for _, t := range totals {
+		if s.Value > 0 {
+			t.Weight = t.Value / s.Value
+		}
+		s.Totals = append(s.Totals, *t)
+	}
+	sort.Slice(s.Totals, func(i, j int) bool { return s.Totals[i].Asset < s.Totals[j].Asset })
+
+	if err != nil {
+		return s, err
+	}
+	p.lock.Lock()
+	p.cached = s
+	p.lock.Unlock()
+	return s, nil
+}
+
+// balances fetches the non-zero balances, sorted by venue then asset.
+func (p *Portfolio) balances() ([]Holding, error) {
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		out      []Holding
+		firstErr error
+		sem      = make(chan struct{}, p.cfg.Concurrency)
+	)
+	record := func(hs []Holding, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for _, h := range hs {
+			if h.Amount > epsilon || h.Amount < -epsilon {
+				out = append(out, h)
+			}
+		}
+	}
+	fetch := func(f func() ([]Holding, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			record(f())
+		}()
+	}
+
+	if p.api != nil {
+		venues := map[string]bool{}
+		for _, v := range p.cfg.Venues {
+			venues[strings.ToUpper(v)] = true
+		}
+		for _, asset := range p.cfg.Assets {
+			asset := strings.ToLower(asset)
+			fetch(func() ([]Holding, error) { return p.assetBalances(asset, venues) })
+		}
+	} else {
+		if len(p.cfg.Venues) == 0 {
+			return nil, ErrNoVenues
+		}
+		for _, venue := range p.cfg.Venues {
+			for _, asset := range p.cfg.Assets {
+				venue, asset := strings.ToUpper(venue), strings.ToLower(asset)
+				fetch(func() ([]Holding, error) { return p.venueBalance(venue, asset) })
+			}
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Venue != out[j].Venue {
+			return out[i].Venue < out[j].Venue
+		}
+		return out[i].Asset < out[j].Asset
+	})
+	return out, firstErr
+}
+
+// assetBalances reads an asset's balance on every venue with GetBalances,
+// keeping the given venues, or all if none are given.
+func (p *Portfolio) assetBalances(asset string, venues map[string]bool) ([]Holding, error) {
+	resp, err := p.api.GetBalances(p.userId, asset)
+	if err != nil {
+		return nil, err
+	}
+	var out []Holding
+	for venue, amount := range resp {
+		venue = strings.ToUpper(venue)
+		if len(venues) > 0 && !venues[venue] {
+			continue
+		}
+		amt, err := strconv.ParseFloat(amount, 64)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, Holding{Venue: venue, Asset: asset, Amount: amt})
+	}
+	return out, nil
+}
+
+// venueBalance reads an asset's balance on one venue with BalanceDMA.
+func (p *Portfolio) venueBalance(venue, asset string) ([]Holding, error) {
+	b, err := p.dma.BalanceDMA(p.userId, venue, asset)
+	if err == nil {
+		err = routefire.FirstDmaError(b.Errors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.Amount == "" {
+		return nil, nil
+	}
+	amt, err := strconv.ParseFloat(b.Amount, 64)
+	if err != nil {
+		return nil, err
+	}
+	return []Holding{{Venue: venue, Asset: asset, Amount: amt}}, nil
+}
+
+// prices values each asset held in the quote currency, concurrently. It
+// returns the prices and the assets that could not be priced.
+func (p *Portfolio) prices(holdings []Holding) (map[string]float64, []string) {
+	prices := map[string]float64{}
+	var assets []string
+	for _, h := range holdings {
+		if _, ok := prices[h.Asset]; !ok {
+			prices[h.Asset] = 0
+			assets = append(assets, h.Asset)
+		}
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var unpriced []string
+	sem := make(chan struct{}, p.cfg.Concurrency)
+	for _, asset := range assets {
+		wg.Add(1)
+		go func(asset string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			px, err := p.Price(asset)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				unpriced = append(unpriced, asset)
+				return
+			}
+			prices[asset] = px
+		}(asset)
+	}
+	wg.Wait()
+	sort.Strings(unpriced)
+	return prices, unpriced
+}
+
+// Function Price returns the mid price of an asset in the quote currency. It
+// uses the asset/quote book, the inverse of the quote/asset book, or the
+// product of the prices through a bridge asset, in that order.
+func (p *Portfolio) Price(asset string) (float64, error) {
+	asset = strings.ToLower(asset)
+	if asset == p.cfg.Quote {
+		return 1, nil
+	}
+	if px, err := p.pairPrice(asset, p.cfg.Quote); err == nil {
+		return px, nil
+	}
+	for _, bridge := range p.cfg.Bridges {
+		bridge = strings.ToLower(bridge)
+		if bridge == asset || bridge == p.cfg.Quote {
+			continue
+		}
+		leg, err := p.pairPrice(asset, bridge)
+		if err != nil {
+			continue
+		}
+		if px, err := p.pairPrice(bridge, p.cfg.Quote); err == nil {
+			return leg * px, nil
+		}
+	}
+	return 0, ErrNoPrice
+}
+
+// pairPrice returns the mid price of asset in quote from either book.
+func (p *Portfolio) pairPrice(asset, quote string) (float64, error) {
+	if mid, err := p.mid(asset, quote); err == nil {
+		return mid, nil
+	}
+	mid, err := p.mid(quote, asset)
+	if err != nil {
+		return 0, err
+	}
+	return 1 / mid, nil
+}
+
+func (p *Portfolio) mid(asset, baseAsset string) (float64, error) {
+	ob, err := p.dma.GetConsolidatedOrderBookDMA(p.userId, asset, baseAsset)
+	if err == nil {
+		err = routefire.FirstDmaError(ob.Errors)
+	}
+	if err != nil {
+		return 0, err
+	}
+	mid, err := ob.Data.MidPrice()
+	if err == nil && mid <= 0 {
+		err = ErrNoPrice
+	}
+	return mid, err
+}
@@ -0,0 +1,130 @@
+package portfolio
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+const uid = "portfolio@example.com"
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+// market serves fixed balances and books, counting balance requests.
+type market struct {
+	routefire.API
+	balances map[string]map[string]string // By venue, then asset
+	mids     map[string]float64           // By asset/base
+	lock     sync.Mutex
+	calls    int
+}
+
+func (m *market) count() {
+	m.lock.Lock()
+	m.calls++
+	m.lock.Unlock()
+}
+
+func (m *market) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	m.count()
+	return &routefire.DmaBalanceResponse{VenueId: venue, Asset: assetId, Amount: m.balances[venue][assetId]}, nil
+}
+
+func (m *market) GetBalances(uid, asset string) (map[string]string, error) {
+	m.count()
+	out := map[string]string{}
+	for venue, bs := range m.balances {
+		if amt, ok := bs[asset]; ok {
+			out[strings.ToLower(venue)] = amt
+		}
+	}
+	return out, nil
+}
+
+func (m *market) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	mid, ok := m.mids[asset+"/"+baseAsset]
+	if !ok {
+		return &routefire.DmaOrderBookResponse{}, nil
+	}
+	return &routefire.DmaOrderBookResponse{Data: routefire.DmaOrderBook{
+		Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: routefire.FormatFloat(mid * 0.99), Amount: "1"}},
+		Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: routefire.FormatFloat(mid * 1.01), Amount: "1"}},
+	}}, nil
+}
+
+func testMarket() *market {
+	return &market{
+		balances: map[string]map[string]string{
+			routefire.Gemini: {routefire.Btc: "1", routefire.Usd: "5000", routefire.Eth: "0"},
+			routefire.Kraken: {routefire.Btc: "0.5", routefire.Eth: "10", routefire.Xlm: "1000"},
+		},
+		mids: map[string]float64{
+			"btc/usd": 10000,
+			"usd/eth": 0.005, // Priced through the inverse book: eth at 200
+			"xlm/btc": 0.00001,
+		},
+	}
+}
+
+func TestAggregateAndValue(t *testing.T) {
+	m := testMarket()
+	p := NewDMA(m, uid, Config{
+		Venues: []string{routefire.Gemini, routefire.Kraken},
+		Assets: []string{routefire.Btc, routefire.Eth, routefire.Usd, routefire.Xlm, routefire.Ltc},
+	})
+	s, err := p.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.calls != 10 || len(s.Holdings) != 5 || len(s.Unpriced) != 0 {
+		t.Errorf("unexpected snapshot %+v after %d calls", s, m.calls)
+	}
+	btc := s.Total(routefire.Btc)
+	approx(t, "btc amount", btc.Amount, 1.5)
+	approx(t, "btc value", btc.Value, 15000)
+	approx(t, "btc on kraken", btc.Venues[routefire.Kraken], 0.5)
+	approx(t, "eth price", s.Total(routefire.Eth).Price, 200)
+	approx(t, "xlm value", s.Total(routefire.Xlm).Value, 100)
+	approx(t, "total", s.Value, 15000+2000+5000+100)
+	approx(t, "kraken", s.ByVenue[routefire.Kraken], 5000+2000+100)
+	approx(t, "usd weight", s.Total(routefire.Usd).Weight, 5000/22100.0)
+}
+
+func TestCachedSnapshot(t *testing.T) {
+	m := testMarket()
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := New(m, uid, Config{Assets: []string{routefire.Btc, routefire.Usd}, Venues: []string{routefire.Kraken}, RefreshInterval: time.Minute})
+	p.Now = func() time.Time { return now }
+
+	s, err := p.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetBalances is one call per asset; venues not configured are dropped.
+	if m.calls != 2 || len(s.Holdings) != 1 || s.Value != 5000 {
+		t.Errorf("unexpected snapshot %+v after %d calls", s, m.calls)
+	}
+	now = now.Add(30 * time.Second)
+	if again, _ := p.Snapshot(); again != s || m.calls != 2 {
+		t.Errorf("expected the cached snapshot")
+	}
+	now = now.Add(time.Minute)
+	if again, _ := p.Snapshot(); again == s || m.calls != 4 {
+		t.Errorf("expected a refresh")
+	}
+
+	p = New(m, uid, Config{Assets: []string{routefire.Zrx}, Quote: routefire.Eur})
+	m.balances[routefire.Gemini][routefire.Zrx] = "1"
+	if s, err := p.Refresh(); err != nil || len(s.Unpriced) != 1 || s.Value != 0 {
+		t.Errorf("zrx should be unpriced, got %+v %v", s, err)
+	}
+}
@@ -0,0 +1,307 @@
+package portfolio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/router"
+	"github.com/routefire/go-routefire/strategy"
+)
+
+var (
+	ErrInvalidTargets = errors.New("portfolio: target weights must be non-negative, sum to 1 and name configured assets")
+	ErrUnpriced       = errors.New("portfolio: cannot rebalance with unpriced holdings")
+)
+
+// Type Trade is one order of a rebalance: Side Quantity of Asset against the
+// quote currency. Price and Value are at the mid when the plan was made.
+type Trade struct {
+	Asset    string
+	Side     string
+	Quantity float64
+	Price    float64
+	Value    float64
+	Weight   float64 // Weight before the trade
+	Target   float64
+
+	// Set by Execute: the routed DMA order, or the algorithm order's id.
+	Parent  *router.Parent
+	OrderId string
+	Err     error
+}
+
+// Type Plan is the set of trades that brings a portfolio to its target
+// weights. Sells come before buys, and Execute waits for the sells to finish
+// before placing the buys, so buys can be funded by the proceeds.
+type Plan struct {
+	Snapshot *Snapshot
+	Trades   []*Trade
+}
+
+// Function String formats the plan, one trade per line, for a dry run.
+func (p *Plan) String() string {
+	if len(p.Trades) == 0 {
+		return "no trades: all weights are within their bands"
+	}
+	var b strings.Builder
+	q := p.Snapshot.Quote
+	for _, t := range p.Trades {
+		fmt.Fprintf(&b, "%-4s %s %s/%s @ %s (%s %s): weight %.2f%% -> %.2f%%\n",
+			t.Side, routefire.FormatFloat(t.Quantity), t.Asset, q, routefire.FormatFloat(t.Price),
+			routefire.FormatFloat(t.Value), q, t.Weight*100, t.Target*100)
+	}
+	return b.String()
+}
+
+// Type Rebalancer trades a portfolio towards target weights.
+type Rebalancer struct {
+	p       *Portfolio
+	targets map[string]float64
+
+	// Band is how far, in absolute weight, an asset may drift from its target
+	// before it is traded. Defaults to 0.02.
+	Band float64
+	// MinTrade is the smallest trade, by value in the quote currency, worth
+	// making.
+	MinTrade float64
+	// Slippage bounds the execution price, as a fraction away from the mid.
+	// Defaults to 0.005.
+	Slippage float64
+	// Algo, if set, executes trades as algorithm orders with these params and
+	// an iwould limit. Otherwise trades are routed across venues as DMA orders,
+	// within each venue's balance.
+	Algo       string
+	AlgoParams map[string]string
+	// Router options for DMA execution, such as fees and minimum sizes. The
+	// router always checks venue balances.
+	Router func(*router.Router)
+	// SettleTimeout is how long Execute waits for the sells to finish before
+	// placing the buys, which are then limited to the balances available.
+	// Defaults to one minute.
+	SettleTimeout time.Duration
+	// SettleInterval is the time between status requests while waiting.
+	// Defaults to one second.
+	SettleInterval time.Duration
+	// Clock paces the wait. If nil the system clock is used.
+	Clock strategy.Clock
+}
+
+// Function NewRebalancer creates a rebalancer towards targets, the weight of
+// each asset by value. Held assets without a target are sold; the quote
+// currency's target is the cash left after trading.
+func NewRebalancer(p *Portfolio, targets map[string]float64) (*Rebalancer, error) {
+	configured := map[string]bool{}
+	for _, a := range p.cfg.Assets {
+		configured[strings.ToLower(a)] = true
+	}
+	norm := map[string]float64{}
+	sum := 0.0
+	for asset, w := range targets {
+		asset = strings.ToLower(asset)
+		if w < 0 || !configured[asset] {
+			return nil, ErrInvalidTargets
+		}
+		norm[asset] += w
+		sum += w
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		return nil, ErrInvalidTargets
+	}
+	return &Rebalancer{p: p, targets: norm, Band: 0.02, Slippage: 0.005, SettleTimeout: time.Minute, SettleInterval: time.Second}, nil
+}
+
+// Function Plan reads the current holdings and computes the trades needed to
+// bring every asset that has drifted beyond the band back to its target. It
+// places no orders, so it serves as a dry run.
+func (r *Rebalancer) Plan() (*Plan, error) {
+	s, err := r.p.Refresh()
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Unpriced) > 0 {
+		return nil, ErrUnpriced
+	}
+	plan := &Plan{Snapshot: s}
+	if s.Value <= 0 {
+		return plan, nil
+	}
+
+	assets := map[string]bool{}
+	for _, t := range s.Totals {
+		assets[t.Asset] = true
+	}
+	for asset := range r.targets {
+		assets[asset] = true
+	}
+	for asset := range assets {
+		if asset == s.Quote {
+			continue
+		}
+		total := s.Total(asset)
+		target := r.targets[asset]
+		if math.Abs(total.Weight-target) <= r.Band {
+			continue
+		}
+		price := total.Price
+		if price <= 0 {
+			if price, err = r.p.Price(asset); err != nil {
+				return nil, err
+			}
+		}
+		diff := target*s.Value - total.Value
+		if math.Abs(diff) < r.MinTrade || math.Abs(diff) < epsilon {
+			continue
+		}
+		t := &Trade{Asset: asset, Side: routefire.SideBuy, Price: price, Value: math.Abs(diff), Weight: total.Weight, Target: target}
+		if diff < 0 {
+			t.Side = routefire.SideSell
+		}
+		t.Quantity = t.Value / price
+		if t.Side == routefire.SideSell && t.Quantity > total.Amount {
+			t.Quantity = total.Amount
+		}
+		if t.Quantity = routefire.RoundQuantity(asset, t.Quantity); t.Quantity <= 0 {
+			continue
+		}
+		plan.Trades = append(plan.Trades, t)
+	}
+	sort.Slice(plan.Trades, func(i, j int) bool {
+		a, b := plan.Trades[i], plan.Trades[j]
+		if a.Side != b.Side {
+			return a.Side == routefire.SideSell
+		}
+		return a.Asset < b.Asset
+	})
+	return plan, nil
+}
+
+// Function Execute places the trades of a plan. The sells are placed first
+// and, if there are buys, waited for up to SettleTimeout so that their proceeds
+// can fund the buys. A trade that cannot be placed records its error and does
+// not stop the others; the first error is returned. The portfolio's cached
+// snapshot is invalidated.
+func (r *Rebalancer) Execute(plan *Plan) error {
+	defer r.p.Invalidate()
+	var first error
+	place := func(t *Trade) {
+		if r.Algo != "" {
+			t.Err = r.submitAlgo(plan.Snapshot.Quote, t)
+		} else {
+			t.Err = r.route(plan.Snapshot.Quote, t)
+		}
+		if t.Err != nil && first == nil {
+			first = t.Err
+		}
+	}
+	var sells, buys []*Trade
+	for _, t := range plan.Trades {
+		if t.Side == routefire.SideBuy {
+			buys = append(buys, t)
+			continue
+		}
+		if place(t); t.Err == nil {
+			sells = append(sells, t)
+		}
+	}
+	if len(buys) > 0 && len(sells) > 0 {
+		r.settle(sells)
+	}
+	for _, t := range buys {
+		place(t)
+	}
+	return first
+}
+
+// settle waits, up to SettleTimeout, for the sells to stop working.
+func (r *Rebalancer) settle(sells []*Trade) {
+	clock := r.Clock
+	if clock == nil {
+		clock = strategy.RealClock(nil)
+	}
+	deadline := clock.Now().Add(r.SettleTimeout)
+	for {
+		working := false
+		for _, t := range sells {
+			if r.working(t) {
+				working = true
+			}
+		}
+		if !working || !clock.Now().Before(deadline) || !clock.Wait(r.SettleInterval) {
+			return
+		}
+	}
+}
+
+// working reports whether a placed trade may still fill. A trade whose status
+// cannot be read counts as working.
+func (r *Rebalancer) working(t *Trade) bool {
+	if t.Parent != nil {
+		return t.Parent.Refresh().Open > 0
+	}
+	st, err := r.p.api.GetOrderStatus(r.p.userId, t.OrderId)
+	return err != nil || routefire.IsOpenStatus(st.Status)
+}
+
+// Function Rebalance plans the trades and, unless dryRun is set, executes
+// them.
+func (r *Rebalancer) Rebalance(dryRun bool) (*Plan, error) {
+	plan, err := r.Plan()
+	if err != nil || dryRun {
+		return plan, err
+	}
+	return plan, r.Execute(plan)
+}
+
+// limit is the worst price accepted for a trade.
+func (r *Rebalancer) limit(t *Trade) float64 {
+	if t.Side == routefire.SideBuy {
+		return t.Price * (1 + r.Slippage)
+	}
+	return t.Price * (1 - r.Slippage)
+}
+
+func (r *Rebalancer) route(quote string, t *Trade) error {
+	rt := router.New(r.p.dma, r.p.userId)
+	if r.Router != nil {
+		r.Router(rt)
+	}
+	rt.CheckBalances = true
+	parent, err := rt.Route(t.Asset, quote, t.Side, t.Quantity, r.limit(t))
+	if err != nil {
+		return err
+	}
+	t.Parent = parent
+	if st := parent.Status(); st.Failed > 0 {
+		return fmt.Errorf("portfolio: %d of %d child orders for %s failed", st.Failed, len(parent.Children), t.Asset)
+	}
+	return nil
+}
+
+func (r *Rebalancer) submitAlgo(quote string, t *Trade) error {
+	if r.p.api == nil {
+		return routefire.ErrNoAlgoAPI
+	}
+	buyAsset, sellAsset := t.Asset, quote
+	if t.Side == routefire.SideSell {
+		buyAsset, sellAsset = quote, t.Asset
+	}
+	params := make(map[string]string, len(r.AlgoParams)+1)
+	for k, v := range r.AlgoParams {
+		params[k] = v
+	}
+	params["iwould"] = routefire.FormatFloat(r.limit(t))
+	resp, err := r.p.api.SubmitOrder(r.p.userId, buyAsset, sellAsset, routefire.FormatQuantity(t.Asset, t.Quantity), "", r.Algo, params)
+	if err != nil {
+		return err
+	}
+	if resp.OrderId == "" {
+		return fmt.Errorf("portfolio: %s order for %s not accepted", r.Algo, t.Asset)
+	}
+	t.OrderId = resp.OrderId
+	return nil
+}
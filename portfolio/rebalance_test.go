@@ -0,0 +1,158 @@
+package portfolio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+)
+
+func TestRebalanceDMA(t *testing.T) {
+	bt := backtest.New([]backtest.Snapshot{{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "9990", Amount: "10"}},
+			Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "10010", Amount: "10"}},
+		},
+	}}, backtest.Config{Balances: map[string]map[string]float64{
+		routefire.Gemini: {routefire.Btc: 1, routefire.Usd: 2500},
+	}})
+	bt.Step()
+
+	p := NewDMA(bt, uid, Config{Venues: []string{routefire.Gemini}, Assets: []string{routefire.Btc, routefire.Usd}})
+	r, err := NewRebalancer(p, map[string]float64{routefire.Btc: 0.5, routefire.Usd: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := r.Rebalance(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 12500 in total, 80% in btc: sell 3750 of btc.
+	if len(plan.Trades) != 1 {
+		t.Fatalf("expected one trade, got %s", plan)
+	}
+	sell := plan.Trades[0]
+	if sell.Side != routefire.SideSell || sell.Asset != routefire.Btc || sell.Parent == nil {
+		t.Fatalf("unexpected trade %+v", sell)
+	}
+	approx(t, "quantity", sell.Quantity, 0.375)
+	approx(t, "weight", sell.Weight, 0.8)
+	if st := sell.Parent.Refresh(); st.Status != routefire.StatusFilled {
+		t.Errorf("expected the sale to fill, got %+v", st)
+	}
+
+	after, err := p.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	approx(t, "btc after", after.Total(routefire.Btc).Amount, 0.625)
+	if plan, _ := r.Plan(); len(plan.Trades) != 0 || !strings.HasPrefix(plan.String(), "no trades") {
+		t.Errorf("the portfolio should be within its bands, got %s", plan)
+	}
+}
+
+func TestRebalancePlan(t *testing.T) {
+	m := testMarket()
+	p := New(m, uid, Config{Assets: []string{routefire.Btc, routefire.Eth, routefire.Usd, routefire.Xlm}})
+	if _, err := NewRebalancer(p, map[string]float64{routefire.Btc: 0.6, routefire.Usd: 0.6}); err != ErrInvalidTargets {
+		t.Errorf("expected ErrInvalidTargets, got %v", err)
+	}
+	if _, err := NewRebalancer(p, map[string]float64{routefire.Ltc: 1}); err != ErrInvalidTargets {
+		t.Errorf("expected ErrInvalidTargets for an unconfigured asset, got %v", err)
+	}
+
+	// 22100 in total: btc 15000, usd 5000, eth 2000, xlm 100.
+	r, err := NewRebalancer(p, map[string]float64{routefire.Btc: 0.5, routefire.Eth: 0.2, routefire.Usd: 0.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MinTrade = 500
+	plan, err := r.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Xlm is to be sold, but is worth less than MinTrade.
+	if len(plan.Trades) != 2 || plan.Trades[0].Side != routefire.SideSell || plan.Trades[1].Asset != routefire.Eth {
+		t.Fatalf("unexpected plan %s", plan)
+	}
+	approx(t, "btc sale", plan.Trades[0].Value, 15000-11050)
+	approx(t, "eth purchase", plan.Trades[1].Quantity, (4420-2000)/200.0)
+	if lines := strings.Split(strings.TrimSpace(plan.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "SELL 0.395 btc/usd") {
+		t.Errorf("unexpected dry run output %q", plan.String())
+	}
+
+	// Quantities are rounded down to the asset's precision.
+	r, _ = NewRebalancer(p, map[string]float64{routefire.Btc: 1.0 / 3, routefire.Eth: 0.2, routefire.Usd: 1 - 1.0/3 - 0.2})
+	r.MinTrade = 500
+	if plan, err = r.Plan(); err != nil || plan.Trades[0].Quantity != 0.76333333 {
+		t.Errorf("expected to sell 0.76333333 btc, got %s %v", plan, err)
+	}
+}
+
+// algoMarket fills each algorithm order after it has been reported open twice.
+type algoMarket struct {
+	*market
+	reject bool
+	polls  map[string]int
+	log    []string
+}
+
+func (m *algoMarket) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if m.reject {
+		return &routefire.SubmitOrderResponse{}, nil
+	}
+	id := buyAsset + "/" + sellAsset
+	m.log = append(m.log, "submit "+id)
+	return &routefire.SubmitOrderResponse{OrderId: id}, nil
+}
+
+func (m *algoMarket) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	m.log = append(m.log, "status "+orderId)
+	if m.polls[orderId]++; m.polls[orderId] <= 2 {
+		return &routefire.OrderStatusResponse{Status: routefire.StatusOpen}, nil
+	}
+	return &routefire.OrderStatusResponse{Status: routefire.StatusFilled}, nil
+}
+
+type stepClock struct {
+	now   time.Time
+	waits int
+}
+
+func (c *stepClock) Now() time.Time { return c.now }
+
+func (c *stepClock) Wait(d time.Duration) bool {
+	c.now = c.now.Add(d)
+	c.waits++
+	return true
+}
+
+func TestRebalanceWaitsForSells(t *testing.T) {
+	m := &algoMarket{market: testMarket(), polls: map[string]int{}}
+	p := New(m, uid, Config{Assets: []string{routefire.Btc, routefire.Eth, routefire.Usd, routefire.Xlm}})
+	r, err := NewRebalancer(p, map[string]float64{routefire.Btc: 0.5, routefire.Eth: 0.2, routefire.Usd: 0.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MinTrade = 500
+	r.Algo = "rfxw"
+	clock := &stepClock{now: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)}
+	r.Clock = clock
+	if _, err := r.Rebalance(false); err != nil {
+		t.Fatal(err)
+	}
+	want := "submit usd/btc,status usd/btc,status usd/btc,status usd/btc,submit eth/usd"
+	if got := strings.Join(m.log, ","); got != want || clock.waits != 2 {
+		t.Errorf("the buy should wait for the sale to fill, got %s after %d waits", got, clock.waits)
+	}
+
+	m.reject = true
+	if _, err := r.Rebalance(false); err == nil {
+		t.Errorf("an order returned without an id should fail")
+	}
+}
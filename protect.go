@@ -0,0 +1,105 @@
+package routefire
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrPriceWithoutIwould is returned by SubmitOrderProtected for algorithm
+// orders given a price but no iwould limit. Algorithms largely ignore the
+// price argument, so such an order would have no price protection.
+var ErrPriceWithoutIwould = errors.New("routefire: algorithm order has a price but no iwould limit; pass the limit as the iwould algo param")
+
+// Reference prices for PriceProtection.
+const (
+	// IwouldFromBook uses the best price in the consolidated DMA book: the best
+	// offer for a buy, the best bid for a sell.
+	IwouldFromBook = "book"
+	// IwouldFromStats uses the sweep cost for the order's quantity reported by
+	// GetOrderBookStats.
+	IwouldFromStats = "stats"
+)
+
+// Type PriceProtection derives an iwould limit for an algorithm order from the
+// current market, allowing ToleranceBps basis points of slippage from the
+// reference price.
+type PriceProtection struct {
+	Source       string // IwouldFromBook (the default) or IwouldFromStats
+	ToleranceBps float64
+}
+
+// Function IwouldPrice returns the iwould limit for an algorithm order to buy
+// buyAsset with sellAsset: the reference price moved by the tolerance against
+// the order. Prices are in the order's base asset, as chosen by AlgoOrderPair.
+func IwouldPrice(api API, userId, buyAsset, sellAsset, quantity string, p PriceProtection) (float64, error) {
+	asset, baseAsset, side := AlgoOrderPair(buyAsset, sellAsset)
+	var ref float64
+	switch p.Source {
+	case IwouldFromStats:
+		stats, err := api.GetOrderBookStats(userId, buyAsset, sellAsset, quantity)
+		if err != nil {
+			return 0, err
+		}
+		if stats.IsoCost <= 0 {
+			return 0, ErrEmptyBook
+		}
+		ref = stats.IsoCost
+	case IwouldFromBook, "":
+		ob, err := api.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+		if err == nil {
+			err = FirstDmaError(ob.Errors)
+		}
+		if err != nil {
+			return 0, err
+		}
+		var best DmaOrderBookEntry
+		if side == SideBuy {
+			best, err = ob.Data.BestOffer()
+		} else {
+			best, err = ob.Data.BestBid()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if ref, _, err = best.Floats(); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, errors.New("routefire: unknown price protection source " + strconv.Quote(p.Source))
+	}
+	if side == SideBuy {
+		return ref * (1 + p.ToleranceBps/10000), nil
+	}
+	return ref * (1 - p.ToleranceBps/10000), nil
+}
+
+// Function SubmitOrderProtected submits an algorithm order with price
+// protection. Orders with a price but no iwould algo param are rejected with
+// ErrPriceWithoutIwould. If protection is given and the caller has not set
+// iwould, it is derived from the market with IwouldPrice. An iwould set by the
+// caller is always kept.
+func SubmitOrderProtected(api API, userId, buyAsset, sellAsset, quantity, price, algo string, algoParams map[string]string, protection *PriceProtection) (*SubmitOrderResponse, error) {
+	_, hasIwould := algoParams["iwould"]
+	if price != "" && !hasIwould {
+		return nil, ErrPriceWithoutIwould
+	}
+	if protection != nil && !hasIwould {
+		limit, err := IwouldPrice(api, userId, buyAsset, sellAsset, quantity, *protection)
+		if err != nil {
+			return nil, err
+		}
+		params := make(map[string]string, len(algoParams)+1)
+		for k, v := range algoParams {
+			params[k] = v
+		}
+		params["iwould"] = FormatFloat(limit)
+		algoParams = params
+	}
+	return api.SubmitOrder(userId, buyAsset, sellAsset, quantity, price, algo, algoParams)
+}
+
+// Function SubmitOrderProtected submits an algorithm order with price
+// protection; see the package function SubmitOrderProtected.
+func (api *Client) SubmitOrderProtected(userId, buyAsset, sellAsset, quantity, price, algo string, algoParams map[string]string, protection *PriceProtection) (*SubmitOrderResponse, error) {
+	return SubmitOrderProtected(api, userId, buyAsset, sellAsset, quantity, price, algo, algoParams, protection)
+}
@@ -0,0 +1,76 @@
+package routefire
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// marketAPI serves a fixed market and records algorithm orders.
+type marketAPI struct {
+	API
+	params map[string]string
+}
+
+func (m *marketAPI) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*DmaOrderBookResponse, error) {
+	return &DmaOrderBookResponse{Data: DmaOrderBook{
+		Bids:   []DmaOrderBookEntry{{Venue: Gemini, Price: "7990", Amount: "1"}},
+		Offers: []DmaOrderBookEntry{{Venue: Gemini, Price: "8000", Amount: "1"}},
+	}}, nil
+}
+
+func (m *marketAPI) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*InquiryResponse, error) {
+	return &InquiryResponse{IsoCost: 8010}, nil
+}
+
+func (m *marketAPI) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*SubmitOrderResponse, error) {
+	m.params = algoParams
+	return &SubmitOrderResponse{OrderId: "algo-1"}, nil
+}
+
+func iwould(t *testing.T, m *marketAPI) float64 {
+	t.Helper()
+	v, err := strconv.ParseFloat(m.params["iwould"], 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSubmitOrderProtected(t *testing.T) {
+	m := &marketAPI{}
+	params := map[string]string{"target_seconds": "100"}
+
+	// Buying btc with usd is protected above the best offer.
+	if _, err := SubmitOrderProtected(m, uid, Btc, Usd, "1", "", "rfxw", params, &PriceProtection{ToleranceBps: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if got := iwould(t, m); math.Abs(got-8040) > 1e-9 || m.params["target_seconds"] != "100" {
+		t.Errorf("unexpected params %v", m.params)
+	}
+	if _, ok := params["iwould"]; ok {
+		t.Errorf("caller's params should not be modified")
+	}
+
+	// Buying usd with btc sells btc, protected below the best bid.
+	SubmitOrderProtected(m, uid, Usd, Btc, "1", "", "rfxw", nil, &PriceProtection{ToleranceBps: 100})
+	if got := iwould(t, m); math.Abs(got-7910.1) > 1e-9 {
+		t.Errorf("unexpected sell iwould %f", got)
+	}
+
+	SubmitOrderProtected(m, uid, Btc, Usd, "1", "", "rfxw", nil, &PriceProtection{Source: IwouldFromStats, ToleranceBps: 10})
+	if got := iwould(t, m); math.Abs(got-8018.01) > 1e-9 {
+		t.Errorf("unexpected stats iwould %f", got)
+	}
+
+	// An explicit iwould wins.
+	SubmitOrderProtected(m, uid, Btc, Usd, "1", "8100", "rfxw", map[string]string{"iwould": "8100"}, &PriceProtection{})
+	if m.params["iwould"] != "8100" {
+		t.Errorf("explicit iwould replaced: %v", m.params)
+	}
+
+	m.params = nil
+	if _, err := SubmitOrderProtected(m, uid, Btc, Usd, "1", "8100", "rfxw", nil, nil); err != ErrPriceWithoutIwould || m.params != nil {
+		t.Errorf("expected ErrPriceWithoutIwould without a submission, got %v", err)
+	}
+}
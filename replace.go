@@ -0,0 +1,138 @@
+package routefire
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrReplaceUnconfirmed is returned by ReplaceOrderDMA when the original
+	// order could not be confirmed closed, so no replacement was submitted.
+	ErrReplaceUnconfirmed = errors.New("routefire: original order not confirmed closed, replacement not submitted")
+	// ErrReplaceFilled is returned by ReplaceOrderDMA when the original order
+	// had already filled the new quantity, so no replacement was needed.
+	ErrReplaceFilled = errors.New("routefire: original order already filled, replacement not submitted")
+)
+
+// ReplaceConfirmAttempts and ReplaceConfirmInterval control how many times, and
+// how often, ReplaceOrderDMA requests the status of a cancelled order while
+// waiting for the venue to confirm that it is closed.
+var (
+	ReplaceConfirmAttempts = 10
+	ReplaceConfirmInterval = 250 * time.Millisecond
+)
+
+// Type DmaOrder is a handle on a DMA order, carrying the details needed to
+// replace it.
+type DmaOrder struct {
+	UserId       string
+	Venue        string
+	VenueOrderId string
+	Asset        string
+	BaseAsset    string
+	Side         string
+	Quantity     float64 // Total quantity, including any filled before a replacement
+	Price        string
+	OrderParams  map[string]string
+
+	// For a replacement: the order it replaced, and the quantity that order
+	// and its predecessors had filled.
+	Replaces    *DmaOrder
+	PriorFilled float64
+}
+
+// Function SubmitDMA submits a DMA order and returns a handle on it.
+func SubmitDMA(api DMA, userId, venue, asset, baseAsset, side, quantity, price string, orderParams map[string]string) (*DmaOrder, error) {
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := api.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	if err == nil {
+		err = FirstDmaError(resp.Errors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DmaOrder{
+		UserId:       userId,
+		Venue:        venue,
+		VenueOrderId: resp.VenueOrderId,
+		Asset:        asset,
+		BaseAsset:    baseAsset,
+		Side:         side,
+		Quantity:     qty,
+		Price:        price,
+		OrderParams:  orderParams,
+	}, nil
+}
+
+// Function ReplaceOrderDMA amends the quantity and price of a DMA order. Venues
+// do not support amending orders, so the order is cancelled, its final filled
+// amount is confirmed with OrderStatusDMA, and only the quantity still unfilled
+// is submitted at the new price. This avoids the double fills that result from
+// resubmitting the full quantity when a fill races the cancel.
+//
+// Quantity is the new total quantity, including what the original order has
+// filled. The unfilled quantity is rounded down to the asset's precision. If
+// nothing is left to submit, ErrReplaceFilled is returned; if the original
+// order cannot be confirmed closed, ErrReplaceUnconfirmed is returned. In both
+// cases nothing is submitted.
+func ReplaceOrderDMA(api DMA, o *DmaOrder, quantity, price string) (*DmaOrder, error) {
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return nil, err
+	}
+	// The cancel may fail because the order has just filled or closed; the
+	// status request below is what decides.
+	api.CancelOrderDMA(o.UserId, o.Venue, o.VenueOrderId)
+
+	filled, err := confirmClosed(api, o)
+	if err != nil {
+		return nil, err
+	}
+	filled += o.PriorFilled
+	remaining := RoundQuantity(o.Asset, qty-filled)
+	if remaining <= 0 {
+		return nil, ErrReplaceFilled
+	}
+
+	next, err := SubmitDMA(api, o.UserId, o.Venue, o.Asset, o.BaseAsset, o.Side, FormatQuantity(o.Asset, remaining), price, o.OrderParams)
+	if err != nil {
+		return nil, fmt.Errorf("routefire: original order closed with %s filled, but replacement failed: %v", FormatFloat(filled), err)
+	}
+	next.Quantity = qty
+	next.Replaces = o
+	next.PriorFilled = filled
+	return next, nil
+}
+
+// confirmClosed polls an order's status until it is no longer working and
+// returns its filled amount.
+func confirmClosed(api DMA, o *DmaOrder) (float64, error) {
+	for attempt := 0; attempt < ReplaceConfirmAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ReplaceConfirmInterval)
+		}
+		st, err := api.OrderStatusDMA(o.UserId, o.Venue, o.VenueOrderId)
+		if err != nil || len(st.Errors) > 0 {
+			continue
+		}
+		if IsOpenStatus(st.Status) {
+			continue
+		}
+		if st.FilledAmount == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(st.FilledAmount, 64)
+	}
+	return 0, ErrReplaceUnconfirmed
+}
+
+// Function ReplaceOrderDMA amends a DMA order; see the package function
+// ReplaceOrderDMA.
+func (api *Client) ReplaceOrderDMA(o *DmaOrder, quantity, price string) (*DmaOrder, error) {
+	return ReplaceOrderDMA(api, o, quantity, price)
+}
@@ -0,0 +1,75 @@
+package routefire
+
+import (
+	"testing"
+)
+
+// raceDMA simulates an order that fills while it is being cancelled: the venue
+// reports it working for the first status request after the cancel, then
+// filled by filledOnCancel. Like some venues, it does not echo the venue of a
+// submitted order.
+type raceDMA struct {
+	DMA
+	filledOnCancel string
+	statusCalls    int
+	submitted      []string
+	venues         []string
+}
+
+func (d *raceDMA) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*PlaceDmaOrderResponse, error) {
+	d.submitted = append(d.submitted, quantity+"@"+price)
+	return &PlaceDmaOrderResponse{VenueOrderId: "next"}, nil
+}
+
+func (d *raceDMA) CancelOrderDMA(userId, venue, venueOrdId string) (*CancelDmaOrderResponse, error) {
+	d.venues = append(d.venues, venue)
+	return &CancelDmaOrderResponse{Errors: []DmaError{{"order already closed"}}}, nil
+}
+
+func (d *raceDMA) OrderStatusDMA(userId, venue, venueOrdId string) (*DmaOrderStatusResponse, error) {
+	d.statusCalls++
+	if d.statusCalls == 1 {
+		return &DmaOrderStatusResponse{Status: StatusPartiallyFilled, FilledAmount: "0.5"}, nil
+	}
+	return &DmaOrderStatusResponse{Status: StatusFilled, FilledAmount: d.filledOnCancel}, nil
+}
+
+func TestReplaceOrderDMA(t *testing.T) {
+	ReplaceConfirmInterval = 0
+	orig := &DmaOrder{UserId: uid, Venue: Gemini, VenueOrderId: "orig", Asset: Btc, BaseAsset: Usd, Side: SideBuy, Quantity: 2, Price: "100"}
+
+	api := &raceDMA{filledOnCancel: "1.5"}
+	next, err := ReplaceOrderDMA(api, orig, "2", "101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.submitted) != 1 || api.submitted[0] != "0.5@101" {
+		t.Errorf("only the unfilled quantity should be resubmitted, got %v", api.submitted)
+	}
+	if next.Replaces != orig || next.PriorFilled != 1.5 || next.Quantity != 2 || next.VenueOrderId != "next" || next.Venue != Gemini {
+		t.Errorf("unexpected replacement %+v", next)
+	}
+
+	// The unfilled quantity is rounded to the asset's precision.
+	api = &raceDMA{filledOnCancel: "0.1"}
+	if _, err := ReplaceOrderDMA(api, orig, "0.3", "101"); err != nil || len(api.submitted) != 1 || api.submitted[0] != "0.2@101" {
+		t.Errorf("expected 0.2 resubmitted, got %v %v", err, api.submitted)
+	}
+
+	// Replacing the replacement accounts for what both orders filled.
+	api = &raceDMA{filledOnCancel: "0.5"}
+	if _, err := ReplaceOrderDMA(api, next, "2", "102"); err != ErrReplaceFilled || len(api.submitted) != 0 {
+		t.Errorf("expected ErrReplaceFilled without a submission, got %v %v", err, api.submitted)
+	}
+	if len(api.venues) != 1 || api.venues[0] != Gemini {
+		t.Errorf("the replacement should be cancelled on its submitted venue, got %v", api.venues)
+	}
+
+	attempts := ReplaceConfirmAttempts
+	defer func() { ReplaceConfirmAttempts = attempts }()
+	ReplaceConfirmAttempts = 1
+	api = &raceDMA{filledOnCancel: "0"}
+	if _, err := ReplaceOrderDMA(api, orig, "2", "101"); err != ErrReplaceUnconfirmed || len(api.submitted) != 0 {
+		t.Errorf("expected ErrReplaceUnconfirmed without a submission, got %v %v", err, api.submitted)
+	}
+}
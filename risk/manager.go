@@ -0,0 +1,466 @@
+package risk
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+// Type Manager wraps a Routefire API, checking every order against its limits
+// before submission. It implements routefire.API, so it can be used in place of
+// the client it wraps.
+//
+// Positions and open orders are tracked from orders submitted, status requests
+// and cancellations made through the Manager. Positions start at zero unless
+// seeded with SetPosition.
+type Manager struct {
+	dma routefire.DMA
+	api routefire.API
+
+	lock      sync.Mutex
+	limits    Limits
+	positions map[string]float64
+	open      map[string]*trackedOrder
+	seq       int
+	day       time.Time
+	turnover  float64
+
+	// Now returns the current time, for audit events and the turnover day.
+	// It defaults to time.Now; a backtest can supply its own clock.
+	Now func() time.Time
+	// OnAudit, if set, is called with every audit event.
+	OnAudit func(Event)
+	// OnBreach, if set, is called with every rejection.
+	OnBreach func(*Rejection)
+}
+
+type trackedOrder struct {
+	order  Order
+	filled float64
+}
+
+// Function New wraps api with the given limits.
+func New(api routefire.API, limits Limits) *Manager {
+	m := NewDMA(api, limits)
+	m.api = api
+	return m
+}
+
+// Function NewDMA wraps a DMA-only API, such as a backtest engine. Algorithm
+// orders made through the Manager fail with routefire.ErrNoAlgoAPI.
+func NewDMA(api routefire.DMA, limits Limits) *Manager {
+	return &Manager{
+		dma:       api,
+		limits:    limits,
+		positions: map[string]float64{},
+		open:      map[string]*trackedOrder{},
+		Now:       time.Now,
+	}
+}
+
+// Function SetLimits replaces the limits. Open orders and turnover are kept.
+func (m *Manager) SetLimits(limits Limits) {
+	m.lock.Lock()
+	m.limits = limits
+	m.lock.Unlock()
+}
+
+// Function SetPosition sets the net position in an asset, e.g. from balances.
+func (m *Manager) SetPosition(asset string, quantity float64) {
+	m.lock.Lock()
+	m.positions[strings.ToLower(asset)] = quantity
+	m.lock.Unlock()
+}
+
+// Function Position returns the net position in an asset.
+func (m *Manager) Position(asset string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.positions[strings.ToLower(asset)]
+}
+
+// Function OpenOrders returns the number of orders open through the Manager,
+// including orders being submitted.
+func (m *Manager) OpenOrders() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.open)
+}
+
+// Function Turnover returns the value of orders submitted during the current
+// UTC day.
+func (m *Manager) Turnover() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollDay()
+	return m.turnover
+}
+
+// Function Check vets an order against the limits without submitting it. On
+// success, the order is returned with its notional value filled in.
+func (m *Manager) Check(userId string, o Order) (Order, error) {
+	o, err := m.prepare(userId, o)
+	if err != nil {
+		return o, err
+	}
+	m.lock.Lock()
+	err = m.check(o)
+	m.lock.Unlock()
+	return o, err
+}
+
+// prepare normalizes an order and values it, fetching the consolidated mid if
+// it is needed for the notional value or the price collar. Orders that cannot be
+// valued are rejected only if a limit depends on their value.
+func (m *Manager) prepare(userId string, o Order) (Order, error) {
+	o.Asset, o.BaseAsset, o.Side = strings.ToLower(o.Asset), strings.ToLower(o.BaseAsset), strings.ToUpper(o.Side)
+	if !finite(o.Quantity) || !finite(o.Price) || o.Quantity <= 0 || o.Price < 0 || (o.Side != routefire.SideBuy && o.Side != routefire.SideSell) {
+		return o, &Rejection{Rule: RuleInvalidOrder, Message: fmt.Sprintf("%s %g at %g", o.Side, o.Quantity, o.Price), Order: o}
+	}
+	m.lock.Lock()
+	limits := m.limits
+	m.lock.Unlock()
+
+	mid := 0.0
+	if limits.PriceCollar > 0 || o.Price == 0 {
+		ob, err := m.dma.GetConsolidatedOrderBookDMA(userId, o.Asset, o.BaseAsset)
+		if err == nil {
+			err = routefire.FirstDmaError(ob.Errors)
+		}
+		if err == nil {
+			mid, err = ob.Data.MidPrice()
+		}
+		switch {
+		case err == nil:
+		case limits.PriceCollar > 0:
+			return o, &Rejection{Rule: RulePriceCollar, Message: "no reference price: " + err.Error(), Order: o}
+		case limits.MaxOrderNotional > 0 || limits.MaxDailyTurnover > 0:
+			return o, &Rejection{Rule: RuleInvalidOrder, Message: "cannot value order: " + err.Error(), Order: o}
+		}
+	}
+	if limits.PriceCollar > 0 && o.Price > 0 {
+		if dev := math.Abs(o.Price-mid) / mid; dev > limits.PriceCollar {
+			return o, &Rejection{Rule: RulePriceCollar, Message: fmt.Sprintf("price %g is %.2f%% from mid %g, limit %.2f%%", o.Price, dev*100, mid, limits.PriceCollar*100), Order: o}
+		}
+	}
+	if o.Price > 0 {
+		o.Notional = o.Quantity * o.Price
+	} else {
+		o.Notional = o.Quantity * mid
+	}
+	return o, nil
+}
+
+// check applies the limits that depend on the Manager's state. The caller
+// holds the lock.
+func (m *Manager) check(o Order) error {
+	l := m.limits
+	reject := func(rule, format string, args ...interface{}) error {
+		return &Rejection{Rule: rule, Message: fmt.Sprintf(format, args...), Order: o}
+	}
+	if l.MaxOrderNotional > 0 && o.Notional > l.MaxOrderNotional {
+		return reject(RuleMaxNotional, "notional %g exceeds limit %g", o.Notional, l.MaxOrderNotional)
+	}
+	if max, ok := maxPosition(l.MaxPosition, o.Asset); ok {
+		long, short := m.exposure(o.Asset)
+		if o.Side == routefire.SideBuy && long+o.Quantity > max {
+			return reject(RuleMaxPosition, "%s position could reach %g, limit %g", o.Asset, long+o.Quantity, max)
+		}
+		if o.Side == routefire.SideSell && short-o.Quantity < -max {
+			return reject(RuleMaxPosition, "%s position could reach %g, limit %g", o.Asset, short-o.Quantity, -max)
+		}
+	}
+	if l.MaxOpenOrders > 0 && len(m.open) >= l.MaxOpenOrders {
+		return reject(RuleMaxOpenOrders, "%d open orders, limit %d", len(m.open), l.MaxOpenOrders)
+	}
+	if l.MaxDailyTurnover > 0 {
+		m.rollDay()
+		if m.turnover+o.Notional > l.MaxDailyTurnover {
+			return reject(RuleMaxDailyTurnover, "turnover would reach %g, limit %g", m.turnover+o.Notional, l.MaxDailyTurnover)
+		}
+	}
+	return nil
+}
+
+// exposure returns the position in an asset if every open buy, or every open
+// sell, were to fill.
+func (m *Manager) exposure(asset string) (long, short float64) {
+	long, short = m.positions[asset], m.positions[asset]
+	for _, t := range m.open {
+		if t.order.Asset != asset {
+			continue
+		}
+		if rem := t.order.Quantity - t.filled; t.order.Side == routefire.SideBuy {
+			long += rem
+		} else {
+			short -= rem
+		}
+	}
+	return long, short
+}
+
+func (m *Manager) rollDay() {
+	y, mo, d := m.Now().UTC().Date()
+	if day := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC); !day.Equal(m.day) {
+		m.day, m.turnover = day, 0
+	}
+}
+
+// admit checks an order and, if it passes, reserves an open order slot and its
+// turnover under a pending key until the submission completes.
+func (m *Manager) admit(userId string, o Order) (Order, string, error) {
+	o, err := m.prepare(userId, o)
+	if err == nil {
+		m.lock.Lock()
+		if err = m.check(o); err == nil {
+			m.rollDay()
+			m.turnover += o.Notional
+			m.seq++
+			key := fmt.Sprintf("pending-%d", m.seq)
+			m.open[key] = &trackedOrder{order: o}
+			m.lock.Unlock()
+			m.emit(Event{Kind: EventAccepted, Order: o})
+			return o, key, nil
+		}
+		m.lock.Unlock()
+	}
+	if r, ok := IsRejection(err); ok {
+		m.emit(Event{Kind: EventRejected, Order: o, Rule: r.Rule, Err: r})
+		if m.OnBreach != nil {
+			m.OnBreach(r)
+		}
+	}
+	return o, "", err
+}
+
+// settle resolves a pending order once its submission completes. Failed
+// submissions release their open order slot and turnover.
+func (m *Manager) settle(pending, key, orderId string, err error) {
+	m.lock.Lock()
+	t := m.open[pending]
+	delete(m.open, pending)
+	if err != nil {
+		m.turnover -= t.order.Notional
+	} else {
+		m.open[key] = t
+	}
+	m.lock.Unlock()
+	m.emit(Event{Kind: EventSubmitted, OrderId: orderId, Order: t.order, Err: err})
+}
+
+// observe books fills reported by an order status and closes the order once
+// it is no longer working.
+func (m *Manager) observe(key, filled, status string) {
+	qty, _ := strconv.ParseFloat(filled, 64)
+	var events []Event
+	m.lock.Lock()
+	t, ok := m.open[key]
+	if ok {
+		if delta := qty - t.filled; delta > 0 {
+			t.filled = qty
+			if t.order.Side == routefire.SideSell {
+				delta = -delta
+			}
+			m.positions[t.order.Asset] += delta
+			events = append(events, Event{Kind: EventFilled, OrderId: orderIdOf(key), Order: t.order})
+		}
+		if !routefire.IsOpenStatus(status) {
+			delete(m.open, key)
+			events = append(events, Event{Kind: EventClosed, OrderId: orderIdOf(key), Order: t.order})
+		}
+	}
+	m.lock.Unlock()
+	for _, e := range events {
+		m.emit(e)
+	}
+}
+
+func (m *Manager) emit(e Event) {
+	if m.OnAudit == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = m.Now()
+	}
+	m.OnAudit(e)
+}
+
+func finite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// maxPosition looks up the position limit of asset, which is lower case, in
+// limits keyed by asset in any case.
+func maxPosition(limits map[string]float64, asset string) (float64, bool) {
+	if max, ok := limits[asset]; ok {
+		return max, true
+	}
+	for a, max := range limits {
+		if strings.ToLower(a) == asset {
+			return max, true
+		}
+	}
+	return 0, false
+}
+
+func dmaKey(venue, venueOrdId string) string {
+	return "dma:" + strings.ToUpper(venue) + ":" + venueOrdId
+}
+
+func algoKey(orderId string) string {
+	return "algo:" + orderId
+}
+
+func orderIdOf(key string) string {
+	return key[strings.LastIndex(key, ":")+1:]
+}
+
+func parsePrice(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+//
+//  Checked order calls
+//
+
+func (m *Manager) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	o := Order{Kind: "dma", Venue: strings.ToUpper(venue), Asset: asset, BaseAsset: baseAsset, Side: side}
+	var err error
+	if o.Quantity, err = strconv.ParseFloat(quantity, 64); err != nil {
+		o.Quantity = -1
+	}
+	if o.Price, err = parsePrice(price); err != nil {
+		o.Price = -1
+	}
+	o, pending, err := m.admit(userId, o)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.dma.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	failed, id := err, ""
+	if err == nil {
+		failed, id = routefire.FirstDmaError(resp.Errors), resp.VenueOrderId
+	}
+	m.settle(pending, dmaKey(venue, id), id, failed)
+	return resp, err
+}
+
+func (m *Manager) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if m.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	asset, baseAsset, side := routefire.AlgoOrderPair(buyAsset, sellAsset)
+	o := Order{Kind: "algo", Asset: asset, BaseAsset: baseAsset, Side: side}
+	var err error
+	if o.Quantity, err = strconv.ParseFloat(quantity, 64); err != nil {
+		o.Quantity = -1
+	}
+	limit := algoParams["iwould"]
+	if limit == "" {
+		limit = price
+	}
+	if o.Price, err = parsePrice(limit); err != nil {
+		o.Price = -1
+	}
+	o, pending, err := m.admit(userId, o)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.api.SubmitOrder(userId, buyAsset, sellAsset, quantity, price, algo, algoParams)
+	failed, id := err, ""
+	if err == nil {
+		id = resp.OrderId
+		if id == "" {
+			failed = errNotAccepted
+		}
+	}
+	m.settle(pending, algoKey(id), id, failed)
+	return resp, err
+}
+
+//
+//  Tracked status calls
+//
+
+func (m *Manager) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	st, err := m.dma.OrderStatusDMA(userId, venue, venueOrdId)
+	if err == nil && len(st.Errors) == 0 {
+		m.observe(dmaKey(venue, venueOrdId), st.FilledAmount, st.Status)
+	}
+	return st, err
+}
+
+// Function CancelOrderDMA cancels an order and stops tracking it. Fills that
+// race the cancellation are only booked if its status is requested first.
+func (m *Manager) CancelOrderDMA(userId, venue, venueOrdId string) (*routefire.CancelDmaOrderResponse, error) {
+	resp, err := m.dma.CancelOrderDMA(userId, venue, venueOrdId)
+	if err == nil && len(resp.Errors) == 0 {
+		m.observe(dmaKey(venue, venueOrdId), "", routefire.StatusCancelled)
+	}
+	return resp, err
+}
+
+func (m *Manager) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	if m.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	st, err := m.api.GetOrderStatus(userId, orderId)
+	if err == nil {
+		m.observe(algoKey(orderId), st.Filled, st.Status)
+	}
+	return st, err
+}
+
+func (m *Manager) CancelOrder(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	if m.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	st, err := m.api.CancelOrder(userId, orderId)
+	if err == nil {
+		m.observe(algoKey(orderId), st.Filled, routefire.StatusCancelled)
+	}
+	return st, err
+}
+
+//
+//  Forwarded calls
+//
+
+func (m *Manager) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	return m.dma.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+}
+
+func (m *Manager) BalanceDMA(userId, venue, assetId string) (*routefire.DmaBalanceResponse, error) {
+	return m.dma.BalanceDMA(userId, venue, assetId)
+}
+
+func (m *Manager) GetBalances(uid, asset string) (map[string]string, error) {
+	if m.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	return m.api.GetBalances(uid, asset)
+}
+
+func (m *Manager) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*routefire.InquiryResponse, error) {
+	if m.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	return m.api.GetOrderBookStats(uid, buyAsset, sellAsset, quantity)
+}
+
+func (m *Manager) GetConsolidatedOrderBook(uid, buyAsset, sellAsset string) (*routefire.OrderBookResponse, error) {
+	if m.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	return m.api.GetConsolidatedOrderBook(uid, buyAsset, sellAsset)
+}
+
+var _ routefire.API = (*Manager)(nil)
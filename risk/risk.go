@@ -0,0 +1,90 @@
+// Package risk provides a pre-trade risk layer. A Manager wraps a Routefire API
+// and vets every SubmitOrder and SubmitOrderDMA call against configurable
+// limits, rejecting orders with a typed *Rejection and emitting audit events
+// for every decision.
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	errNotAccepted = errors.New("risk: order not accepted")
+)
+
+// Rules reported in a Rejection.
+const (
+	RuleInvalidOrder     = "invalid_order"
+	RuleMaxNotional      = "max_order_notional"
+	RuleMaxPosition      = "max_position"
+	RulePriceCollar      = "price_collar"
+	RuleMaxOpenOrders    = "max_open_orders"
+	RuleMaxDailyTurnover = "max_daily_turnover"
+)
+
+// Type Limits configures the Manager. Zero values disable a limit.
+type Limits struct {
+	// MaxOrderNotional is the largest order value, in the order's base asset.
+	MaxOrderNotional float64
+	// MaxPosition is the largest absolute net position per asset, across
+	// venues, counting open orders as if they filled.
+	MaxPosition map[string]float64
+	// PriceCollar is the largest fractional distance of an order's price from
+	// the consolidated mid, e.g. 0.05 for 5%.
+	PriceCollar float64
+	// MaxOpenOrders is the largest number of orders open at once.
+	MaxOpenOrders int
+	// MaxDailyTurnover is the largest total value of orders submitted per UTC
+	// day, in the orders' base assets.
+	MaxDailyTurnover float64
+}
+
+// Type Order describes an order presented to the risk checks.
+type Order struct {
+	Kind      string // "dma" or "algo"
+	Venue     string // Empty for algorithm orders
+	Asset     string
+	BaseAsset string
+	Side      string
+	Quantity  float64
+	Price     float64 // Limit price; for algorithm orders, the iwould limit if any
+	Notional  float64 // Quantity valued at Price, or at the consolidated mid
+}
+
+// Type Rejection is returned when an order breaches a limit.
+type Rejection struct {
+	Rule    string
+	Message string
+	Order   Order
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("risk: order rejected (%s): %s", r.Rule, r.Message)
+}
+
+// Function IsRejection reports whether err is a risk rejection, returning it if so.
+func IsRejection(err error) (*Rejection, bool) {
+	r, ok := err.(*Rejection)
+	return r, ok
+}
+
+// Audit event kinds.
+const (
+	EventAccepted  = "accepted"
+	EventRejected  = "rejected"
+	EventSubmitted = "submitted"
+	EventFilled    = "filled"
+	EventClosed    = "closed"
+)
+
+// Type Event is an audit record of a risk decision or order lifecycle change.
+type Event struct {
+	Time    time.Time
+	Kind    string
+	OrderId string
+	Order   Order
+	Rule    string // For rejections
+	Err     error  // Rejection, or submission error
+}
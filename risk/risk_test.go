@@ -0,0 +1,121 @@
+package risk
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+)
+
+const uid = "risk@example.com"
+
+func testEngine() *backtest.Engine {
+	bt := backtest.New([]backtest.Snapshot{{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "99", Amount: "5"}},
+			Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "101", Amount: "5"}},
+		},
+	}}, backtest.Config{})
+	bt.Step()
+	return bt
+}
+
+func rule(err error) string {
+	if r, ok := IsRejection(err); ok {
+		return r.Rule
+	}
+	return ""
+}
+
+func TestLimits(t *testing.T) {
+	bt := testEngine()
+	m := NewDMA(bt, Limits{
+		MaxOrderNotional: 250,
+		MaxPosition:      map[string]float64{routefire.Btc: 3},
+		PriceCollar:      0.05,
+		MaxOpenOrders:    2,
+		MaxDailyTurnover: 450,
+	})
+	m.Now = bt.Now
+	var events []Event
+	var breaches int
+	m.OnAudit = func(e Event) { events = append(events, e) }
+	m.OnBreach = func(*Rejection) { breaches++ }
+
+	submit := func(side, qty, px string) error {
+		_, err := m.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, side, qty, px, nil)
+		return err
+	}
+	cases := []struct {
+		side, qty, px, rule string
+	}{
+		{routefire.SideBuy, "0", "100", RuleInvalidOrder},
+		{routefire.SideBuy, "3", "100", RuleMaxNotional},
+		{routefire.SideBuy, "1", "90", RulePriceCollar},
+		{routefire.SideBuy, "2", "96", ""}, // Rests
+		{routefire.SideBuy, "1.5", "96", RuleMaxPosition},
+		{routefire.SideSell, "2", "104", ""}, // Rests
+		{routefire.SideSell, "0.1", "104", RuleMaxOpenOrders},
+	}
+	for _, c := range cases {
+		if got := rule(submit(c.side, c.qty, c.px)); got != c.rule {
+			t.Errorf("%s %s at %s: got rule %q, want %q", c.side, c.qty, c.px, got, c.rule)
+		}
+	}
+	if breaches != 5 || m.OpenOrders() != 2 || m.Turnover() != 400 {
+		t.Errorf("unexpected state: %d breaches, %d open, turnover %g", breaches, m.OpenOrders(), m.Turnover())
+	}
+
+	// Cancelling frees an open order slot, but turnover is spent for the day.
+	open := bt.Exchange().Orders()
+	if _, err := m.CancelOrderDMA(uid, open[0].Venue, open[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := rule(submit(routefire.SideBuy, "1", "101")); got != RuleMaxDailyTurnover {
+		t.Errorf("expected a turnover rejection, got %q", got)
+	}
+	if err := submit(routefire.SideBuy, "0.4", "101"); err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last.Kind != EventSubmitted || math.Abs(last.Order.Notional-40.4) > 1e-9 {
+		t.Errorf("unexpected last event %+v", last)
+	}
+}
+
+func TestPositionFromStatus(t *testing.T) {
+	bt := testEngine()
+	// Limits may name assets in any case.
+	m := NewDMA(bt, Limits{MaxPosition: map[string]float64{"BTC": 1}})
+	resp, err := m.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "101", nil)
+	if err != nil || len(resp.Errors) > 0 {
+		t.Fatal(err, resp.Errors)
+	}
+	if _, err := m.OrderStatusDMA(uid, resp.VenueId, resp.VenueOrderId); err != nil {
+		t.Fatal(err)
+	}
+	if m.Position(routefire.Btc) != 1 || m.OpenOrders() != 0 {
+		t.Errorf("expected a closed position of 1, got %g with %d open", m.Position(routefire.Btc), m.OpenOrders())
+	}
+	if _, err := m.Check(uid, Order{Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy, Quantity: 0.1}); rule(err) != RuleMaxPosition {
+		t.Errorf("expected a position rejection, got %v", err)
+	}
+	if o, err := m.Check(uid, Order{Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideSell, Quantity: 2}); err != nil || o.Notional != 200 {
+		t.Errorf("a sale back to -1 should pass, valued at the mid: %+v %v", o, err)
+	}
+	for _, px := range []string{"NaN", "Inf", "-Inf"} {
+		if _, err := m.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideSell, "0.1", px, nil); rule(err) != RuleInvalidOrder {
+			t.Errorf("expected a price of %s to be rejected, got %v", px, err)
+		}
+	}
+	if _, err := m.Check(uid, Order{Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideSell, Quantity: math.Inf(1)}); rule(err) != RuleInvalidOrder {
+		t.Errorf("expected an infinite quantity to be rejected, got %v", err)
+	}
+	if _, err := m.SubmitOrder(uid, routefire.Btc, routefire.Usd, "1", "", "rfxw", nil); err != routefire.ErrNoAlgoAPI {
+		t.Errorf("expected ErrNoAlgoAPI, got %v", err)
+	}
+}
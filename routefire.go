@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -50,11 +51,14 @@ type Client struct {
 	password    string
 	accessToken string
 	client      *http.Client
+
+	authLock sync.Mutex
+	onAuth   func(error)
 }
 
 // Function New creates a new Routefire client from username/password credentials.
 func New(uid, password string) (*Client, error) {
-	z := &Client{uid, password, "", webHttpClient}
+	z := &Client{username: uid, password: password, client: webHttpClient}
 	if err := z.refreshToken(); err != nil {
 		return nil, err
 	}
@@ -382,8 +386,22 @@ func (api *Client) queryPublic(command string, values map[string]interface{}) ([
 	return resp, err
 }
 
+// Function OnAuth sets a function to be called with the result of every
+// authentication token refresh, e.g. to monitor connectivity.
+func (api *Client) OnAuth(fn func(err error)) {
+	api.authLock.Lock()
+	api.onAuth = fn
+	api.authLock.Unlock()
+}
+
 func (api *Client) refreshToken() error {
 	token, err := api.authenticate(api.username, api.password)
+	api.authLock.Lock()
+	fn := api.onAuth
+	api.authLock.Unlock()
+	if fn != nil {
+		fn(err)
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,169 @@
+package router
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/routefire/go-routefire"
+)
+
+// Type Parent is a routed order: the plan and the state of its child orders.
+type Parent struct {
+	*Plan
+	router *Router
+	lock   sync.Mutex
+}
+
+// Type Status is the aggregate status of a parent order.
+type Status struct {
+	Status   string  // One of the routefire.Status constants
+	Filled   float64 // Total filled across children
+	AvgPrice float64 // Average child price, weighted by fills
+	Open     int     // Children still working
+	Failed   int     // Children that could not be submitted or queried
+}
+
+// Function Route plans a parent order and submits its children.
+func (r *Router) Route(asset, baseAsset, side string, quantity, limit float64) (*Parent, error) {
+	p, err := r.Plan(asset, baseAsset, side, quantity, limit)
+	if err != nil {
+		return nil, err
+	}
+	return r.Submit(p), nil
+}
+
+// Function Submit submits the children of a plan concurrently. Children that
+// could not be submitted are marked with StatusError and their error.
+func (r *Router) Submit(p *Plan) *Parent {
+	parent := &Parent{Plan: p, router: r}
+	parent.each(func(c *Child) {
+		resp, err := r.api.SubmitOrderDMA(r.userId, c.Venue, p.Asset, p.BaseAsset, p.Side,
+			routefire.FormatQuantity(p.Asset, c.Quantity), routefire.FormatFloat(c.Price), r.OrderParams)
+		if err == nil {
+			err = routefire.FirstDmaError(resp.Errors)
+		}
+		if err == nil && resp.VenueOrderId == "" {
+			err = ErrNotAccepted
+		}
+		parent.lock.Lock()
+		defer parent.lock.Unlock()
+		if err != nil {
+			c.Status, c.Err = routefire.StatusError, err
+			return
+		}
+		c.OrderId, c.Status = resp.VenueOrderId, routefire.StatusOpen
+	})
+	return parent
+}
+
+// each calls fn concurrently for every child and waits for all of them.
+func (p *Parent) each(fn func(c *Child)) {
+	var wg sync.WaitGroup
+	for _, c := range p.Children {
+		wg.Add(1)
+		go func(c *Child) {
+			defer wg.Done()
+			fn(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (p *Parent) working(c *Child) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return c.OrderId != "" && routefire.IsOpenStatus(c.Status)
+}
+
+// Function Refresh requests the status of every working child concurrently
+// and returns the aggregate status.
+func (p *Parent) Refresh() Status {
+	r := p.router
+	p.each(func(c *Child) {
+		if !p.working(c) {
+			return
+		}
+		st, err := r.api.OrderStatusDMA(r.userId, c.Venue, c.OrderId)
+		if err == nil {
+			err = routefire.FirstDmaError(st.Errors)
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if c.Err = err; err != nil {
+			return
+		}
+		if filled, err := strconv.ParseFloat(st.FilledAmount, 64); err == nil {
+			c.Filled = filled
+		}
+		if st.Status != "" {
+			c.Status = st.Status
+		}
+	})
+	return p.Status()
+}
+
+// Function Cancel cancels every working child concurrently and returns the
+// first error. Fills that raced the cancels are picked up by Refresh.
+func (p *Parent) Cancel() error {
+	r := p.router
+	var lock sync.Mutex
+	var firstErr error
+	p.each(func(c *Child) {
+		if !p.working(c) {
+			return
+		}
+		resp, err := r.api.CancelOrderDMA(r.userId, c.Venue, c.OrderId)
+		if err == nil {
+			err = routefire.FirstDmaError(resp.Errors)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	})
+	return firstErr
+}
+
+// Function Status returns the aggregate status from the last refresh. The
+// parent is filled once the full parent quantity has filled, open while any
+// child is working, and otherwise partially filled, cancelled, or in error if
+// no child could be placed.
+func (p *Parent) Status() Status {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var s Status
+	notional := 0.0
+	for _, c := range p.Children {
+		s.Filled += c.Filled
+		notional += c.Filled * c.Price
+		switch {
+		case c.Err != nil && c.OrderId == "":
+			s.Failed++
+		case routefire.IsOpenStatus(c.Status):
+			s.Open++
+			if c.Err != nil {
+				s.Failed++
+			}
+		}
+	}
+	if s.Filled > 0 {
+		s.AvgPrice = notional / s.Filled
+	}
+	switch {
+	case s.Filled >= p.Quantity-1e-12:
+		s.Status = routefire.StatusFilled
+	case s.Open > 0:
+		s.Status = routefire.StatusOpen
+		if s.Filled > 0 {
+			s.Status = routefire.StatusPartiallyFilled
+		}
+	case s.Failed == len(p.Children):
+		s.Status = routefire.StatusError
+	case s.Filled > 0:
+		s.Status = routefire.StatusPartiallyFilled
+	default:
+		s.Status = routefire.StatusCancelled
+	}
+	return s
+}
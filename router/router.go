@@ -0,0 +1,249 @@
+// Package router provides a client-side smart order router. A Router splits a
+// parent order into child DMA orders across venues by walking the consolidated
+// order book in order of net-of-fee price, within each venue's balance and
+// minimum order size, submits the children concurrently and aggregates their
+// fills into one parent status.
+package router
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/fees"
+)
+
+var (
+	ErrInvalidOrder = errors.New("router: invalid order")
+	ErrNoLiquidity  = errors.New("router: no liquidity within limits")
+	ErrNotAccepted  = errors.New("router: order not accepted")
+)
+
+// Type Router routes orders across venues through the DMA API.
+type Router struct {
+	api    routefire.DMA
+	userId string
+
+	// Fees ranks book levels by net-of-fee price. Nil ranks by quoted price.
+	Fees *fees.Schedule
+	// MinSize is the smallest order quantity accepted by each venue. Venues
+	// whose allocation would be smaller are left out.
+	MinSize map[string]float64
+	// CheckBalances caps each venue's allocation at what its balance, from
+	// BalanceDMA, can fund: the base asset for buys, the asset for sells.
+	CheckBalances bool
+	// OrderParams are passed with every child order.
+	OrderParams map[string]string
+}
+
+// Function New creates a router submitting orders for userId.
+func New(api routefire.DMA, userId string) *Router {
+	return &Router{api: api, userId: userId}
+}
+
+// Type Child is a child order on one venue. Price is the worst book price the
+// child takes, which it is submitted at.
+type Child struct {
+	Venue    string
+	Quantity float64
+	Price    float64
+	OrderId  string
+	Filled   float64
+	Status   string
+	Err      error
+}
+
+// Type Plan is the allocation of a parent order across venues.
+type Plan struct {
+	Asset       string
+	BaseAsset   string
+	Side        string
+	Quantity    float64
+	Limit       float64 // Zero for no limit
+	Children    []*Child
+	Unallocated float64 // Quantity that could not be placed within limits
+}
+
+// Function Plan allocates a parent order across venues without submitting it.
+// For a buy, levels priced above limit are not taken, and for a sell, levels
+// priced below it; a zero limit takes any price.
+func (r *Router) Plan(asset, baseAsset, side string, quantity, limit float64) (*Plan, error) {
+	side = strings.ToUpper(side)
+	if quantity <= 0 || limit < 0 || (side != routefire.SideBuy && side != routefire.SideSell) {
+		return nil, ErrInvalidOrder
+	}
+	ob, err := r.api.GetConsolidatedOrderBookDMA(r.userId, asset, baseAsset)
+	if err == nil {
+		err = routefire.FirstDmaError(ob.Errors)
+	}
+	if err != nil {
+		return nil, err
+	}
+	levels := r.levels(&ob.Data, side, limit)
+
+	var capacity map[string]float64
+	if r.CheckBalances {
+		if capacity, err = r.balances(levels, asset, baseAsset, side); err != nil {
+			return nil, err
+		}
+	}
+
+	// Allocate, leaving out venues whose allocation falls below their minimum
+	// size until the allocation is stable.
+	excluded := map[string]bool{}
+	for {
+		p := allocate(levels, side, capacity, excluded, quantity)
+		p.Asset, p.BaseAsset, p.Side, p.Limit = asset, baseAsset, side, limit
+		p.round()
+		small := false
+		for _, c := range p.Children {
+			if c.Quantity < r.MinSize[c.Venue] {
+				excluded[c.Venue], small = true, true
+			}
+		}
+		if !small {
+			if len(p.Children) == 0 {
+				return p, ErrNoLiquidity
+			}
+			return p, nil
+		}
+	}
+}
+
+type level struct {
+	venue string
+	price float64
+	qty   float64
+	net   float64
+}
+
+// levels returns the book levels a parent order may take, best net price first.
+func (r *Router) levels(ob *routefire.DmaOrderBook, side string, limit float64) []level {
+	var out []level
+	for _, e := range ob.SweepLevels(side) {
+		px, qty, err := e.Floats()
+		if err != nil || qty <= 0 {
+			continue
+		}
+		if limit > 0 && ((side == routefire.SideBuy && px > limit) || (side == routefire.SideSell && px < limit)) {
+			continue
+		}
+		out = append(out, level{strings.ToUpper(e.Venue), px, qty, r.Fees.NetPrice(e.Venue, side, px)})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if side == routefire.SideSell {
+			return out[i].net > out[j].net
+		}
+		return out[i].net < out[j].net
+	})
+	return out
+}
+
+// balances fetches, concurrently, the balance funding the order on each venue
+// in levels.
+func (r *Router) balances(levels []level, asset, baseAsset, side string) (map[string]float64, error) {
+	funding := asset
+	if side == routefire.SideBuy {
+		funding = baseAsset
+	}
+	out := map[string]float64{}
+	var venues []string
+	for _, l := range levels {
+		if _, ok := out[l.venue]; !ok {
+			out[l.venue] = 0
+			venues = append(venues, l.venue)
+		}
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	for _, venue := range venues {
+		wg.Add(1)
+		go func(venue string) {
+			defer wg.Done()
+			b, err := r.api.BalanceDMA(r.userId, venue, funding)
+			amt := 0.0
+			if err == nil {
+				if err = routefire.FirstDmaError(b.Errors); err == nil {
+					amt, err = strconv.ParseFloat(b.Amount, 64)
+				}
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			out[venue] = amt
+		}(venue)
+	}
+	wg.Wait()
+	return out, firstErr
+}
+
+// allocate walks levels best first, filling up to quantity. Capacity, if not
+// nil, is the funding balance per venue: the base asset for buys, spent at the
+// level's net-of-fee price, or the asset for sells.
+func allocate(levels []level, side string, capacity map[string]float64, excluded map[string]bool, quantity float64) *Plan {
+	p := &Plan{Quantity: quantity}
+	children := map[string]*Child{}
+	var remaining map[string]float64
+	if capacity != nil {
+		remaining = map[string]float64{}
+		for v, amt := range capacity {
+			remaining[v] = amt
+		}
+	}
+	left := quantity
+	for _, l := range levels {
+		if left <= 0 {
+			break
+		}
+		if excluded[l.venue] {
+			continue
+		}
+		take := math.Min(left, l.qty)
+		if remaining != nil {
+			afford := remaining[l.venue]
+			if side == routefire.SideBuy {
+				afford /= l.net
+			}
+			if take = math.Min(take, afford); take <= 0 {
+				continue
+			}
+			if side == routefire.SideBuy {
+				remaining[l.venue] -= take * l.net
+			} else {
+				remaining[l.venue] -= take
+			}
+		}
+		c, ok := children[l.venue]
+		if !ok {
+			c = &Child{Venue: l.venue}
+			children[l.venue] = c
+			p.Children = append(p.Children, c)
+		}
+		c.Quantity += take
+		c.Price = l.price
+		left -= take
+	}
+	p.Unallocated = math.Max(left, 0)
+	return p
+}
+
+// round rounds child quantities down to the asset's precision, adding what is
+// rounded off to Unallocated and dropping children left with nothing.
+func (p *Plan) round() {
+	children := p.Children[:0]
+	for _, c := range p.Children {
+		q := routefire.RoundQuantity(p.Asset, c.Quantity)
+		p.Unallocated += c.Quantity - q
+		if c.Quantity = q; q > 0 {
+			children = append(children, c)
+		}
+	}
+	p.Children = children
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/fees"
+)
+
+const uid = "router@example.com"
+
+var testFees = &fees.Schedule{Venues: map[string][]fees.Tier{
+	routefire.Gemini: {{Taker: 0.01}},
+	routefire.Kraken: {{Taker: 0.001}},
+}}
+
+func testEngine(balances map[string]map[string]float64) *backtest.Engine {
+	bt := backtest.New([]backtest.Snapshot{{
+		Time:      time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC),
+		Asset:     routefire.Btc,
+		BaseAsset: routefire.Usd,
+		Book: routefire.DmaOrderBook{
+			Bids: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: "99", Amount: "5"}},
+			Offers: []routefire.DmaOrderBookEntry{
+				{Venue: routefire.Gemini, Price: "100", Amount: "1"},
+				{Venue: routefire.Kraken, Price: "100.2", Amount: "2"},
+				{Venue: routefire.Gemini, Price: "101", Amount: "5"},
+			},
+		},
+	}}, backtest.Config{Fees: testFees, Balances: balances})
+	bt.Step()
+	return bt
+}
+
+func allocation(p *Plan) map[string]float64 {
+	out := map[string]float64{}
+	for _, c := range p.Children {
+		out[c.Venue] = c.Quantity
+	}
+	return out
+}
+
+func TestRouteByNetPrice(t *testing.T) {
+	r := New(testEngine(nil), uid)
+	r.Fees = testFees
+	parent, err := r.Route(routefire.Btc, routefire.Usd, routefire.SideBuy, 3, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Kraken's 100.2 offer is cheaper than Gemini's 100 after fees.
+	if len(parent.Children) != 2 || parent.Children[0].Venue != routefire.Kraken || parent.Children[1].Price != 100 {
+		t.Errorf("unexpected children %+v %+v", parent.Children[0], parent.Children[1])
+	}
+	st := parent.Refresh()
+	if st.Status != routefire.StatusFilled || st.Filled != 3 || math.Abs(st.AvgPrice-(200.4+100)/3) > 1e-9 {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
+
+func TestMinSizeAndBalances(t *testing.T) {
+	r := New(testEngine(nil), uid)
+	r.Fees = testFees
+	r.MinSize = map[string]float64{routefire.Gemini: 1.5}
+	p, err := r.Plan(routefire.Btc, routefire.Usd, routefire.SideBuy, 3, 100.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := allocation(p); len(a) != 1 || a[routefire.Kraken] != 2 || p.Unallocated != 1 {
+		t.Errorf("Gemini's 1 within the limit is under its minimum size, got %v", a)
+	}
+
+	r = New(testEngine(map[string]map[string]float64{
+		routefire.Gemini: {routefire.Usd: 1000},
+		routefire.Kraken: {routefire.Usd: 100.2 * 1.001},
+	}), uid)
+	r.Fees = testFees
+	r.CheckBalances = true
+	p, err = r.Plan(routefire.Btc, routefire.Usd, routefire.SideBuy, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := allocation(p); math.Abs(a[routefire.Kraken]-1) > 1e-9 || math.Abs(a[routefire.Gemini]-2) > 1e-9 {
+		t.Errorf("Kraken can only fund 1, got %v", a)
+	}
+
+	// Balance-capped quantities are rounded down to the asset's precision.
+	r = New(testEngine(map[string]map[string]float64{routefire.Kraken: {routefire.Usd: 50}}), uid)
+	r.Fees = testFees
+	r.CheckBalances = true
+	p, err = r.Plan(routefire.Btc, routefire.Usd, routefire.SideBuy, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := allocation(p)[routefire.Kraken]; q != 0.49850349 || math.Abs(q+p.Unallocated-1) > 1e-9 {
+		t.Errorf("expected 0.49850349 on Kraken, got %v with %v unallocated", q, p.Unallocated)
+	}
+
+	if _, err := r.Plan(routefire.Btc, routefire.Usd, routefire.SideBuy, 1, 50); err != ErrNoLiquidity {
+		t.Errorf("expected ErrNoLiquidity, got %v", err)
+	}
+}
+
+func TestCancelRestingChildren(t *testing.T) {
+	r := New(testEngine(nil), uid)
+	p := &Plan{Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy, Quantity: 2, Children: []*Child{
+		{Venue: routefire.Gemini, Quantity: 1, Price: 100},
+		{Venue: routefire.Kraken, Quantity: 1, Price: 95},
+	}}
+	parent := r.Submit(p)
+	if st := parent.Refresh(); st.Status != routefire.StatusPartiallyFilled || st.Open != 1 {
+		t.Errorf("unexpected status %+v", st)
+	}
+	if err := parent.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if st := parent.Refresh(); st.Status != routefire.StatusPartiallyFilled || st.Open != 0 || st.Filled != 1 {
+		t.Errorf("unexpected status after cancel %+v", st)
+	}
+}
+
+// silentVenue accepts Kraken orders without returning an id.
+type silentVenue struct {
+	routefire.DMA
+}
+
+func (v silentVenue) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	if venue == routefire.Kraken {
+		return &routefire.PlaceDmaOrderResponse{VenueId: venue}, nil
+	}
+	return v.DMA.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+}
+
+func TestUnacceptedChild(t *testing.T) {
+	r := New(silentVenue{testEngine(nil)}, uid)
+	p := &Plan{Asset: routefire.Btc, BaseAsset: routefire.Usd, Side: routefire.SideBuy, Quantity: 2, Children: []*Child{
+		{Venue: routefire.Gemini, Quantity: 1, Price: 100},
+		{Venue: routefire.Kraken, Quantity: 1, Price: 100.2},
+	}}
+	parent := r.Submit(p)
+	if c := parent.Children[1]; c.Status != routefire.StatusError || c.Err != ErrNotAccepted {
+		t.Errorf("expected the Kraken child to fail, got %+v", c)
+	}
+	if st := parent.Refresh(); st.Open != 0 || st.Failed != 1 || st.Filled != 1 {
+		t.Errorf("the parent should complete without the failed child, got %+v", st)
+	}
+}
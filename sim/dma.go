@@ -0,0 +1,81 @@
+package sim
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+// The functions in this file translate between the string-typed DMA API and the
+// simulator. Venue-level rejections are reported in the Errors field of the
+// response, as the live service does, rather than as Go errors.
+
+// Function SubmitDMA places an order from DMA request arguments.
+func (x *Exchange) SubmitDMA(now time.Time, venue, asset, baseAsset, side, quantity, price string) *routefire.PlaceDmaOrderResponse {
+	resp := &routefire.PlaceDmaOrderResponse{VenueId: venue}
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		resp.Errors = dmaErrors(ErrInvalidOrder)
+		return resp
+	}
+	px, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		resp.Errors = dmaErrors(ErrInvalidOrder)
+		return resp
+	}
+	o, err := x.Submit(now, venue, asset, baseAsset, side, qty, px)
+	if err != nil {
+		resp.Errors = dmaErrors(err)
+		return resp
+	}
+	resp.VenueOrderId = o.ID
+	return resp
+}
+
+// Function StatusDMA returns the DMA status response for an order.
+func (x *Exchange) StatusDMA(venue, venueOrdId string) *routefire.DmaOrderStatusResponse {
+	resp := &routefire.DmaOrderStatusResponse{VenueId: venue, VenueOrderId: venueOrdId}
+	o, err := x.Order(venue, venueOrdId)
+	if err != nil {
+		resp.Errors = dmaErrors(err)
+		return resp
+	}
+	resp.Status = o.Status
+	resp.FilledAmount = routefire.FormatFloat(o.Filled)
+	return resp
+}
+
+// Function CancelDMA cancels an order and returns the DMA cancel response.
+func (x *Exchange) CancelDMA(venue, venueOrdId string) *routefire.CancelDmaOrderResponse {
+	resp := &routefire.CancelDmaOrderResponse{VenueId: venue, VenueOrderId: venueOrdId}
+	if _, err := x.Cancel(venue, venueOrdId); err != nil {
+		resp.Errors = dmaErrors(err)
+	}
+	return resp
+}
+
+// Function BalanceDMA returns the DMA balance response for an asset at a venue.
+func (x *Exchange) BalanceDMA(venue, assetId string) *routefire.DmaBalanceResponse {
+	return &routefire.DmaBalanceResponse{
+		VenueId: venue,
+		Asset:   assetId,
+		Amount:  routefire.FormatFloat(x.Balance(venue, assetId)),
+	}
+}
+
+// Function BookDMA returns the DMA order book response for a pair.
+func (x *Exchange) BookDMA(asset, baseAsset string) *routefire.DmaOrderBookResponse {
+	resp := &routefire.DmaOrderBookResponse{}
+	ob, ok := x.Book(asset, baseAsset)
+	if !ok {
+		resp.Errors = []routefire.DmaError{{Message: "sim: no order book for " + pairKey(asset, baseAsset)}}
+		return resp
+	}
+	resp.Data = *ob
+	return resp
+}
+
+func dmaErrors(err error) []routefire.DmaError {
+	return []routefire.DmaError{{Message: err.Error()}}
+}
@@ -0,0 +1,507 @@
+// Package sim implements a simulated trading venue that matches DMA orders
+// against consolidated order book snapshots. It is the matching engine behind
+// the backtesting and paper trading clients.
+//
+// Orders are limit orders. When an order becomes active (after the configured
+// latency) it takes liquidity resting at its venue at or through its limit, at
+// the resting prices. Any remainder rests and is filled at its limit price, as a
+// maker, when a later snapshot shows the opposite side trading through it.
+// Liquidity consumed by simulated fills stays consumed for as long as its price
+// level remains in later snapshots, so polling an unchanged book does not fill
+// the same liquidity twice.
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+const epsilon = 1e-12
+
+var (
+	ErrUnknownOrder        = errors.New("sim: unknown order")
+	ErrInvalidOrder        = errors.New("sim: invalid order")
+	ErrInsufficientBalance = errors.New("sim: insufficient balance")
+	ErrOrderClosed         = errors.New("sim: order is not open")
+)
+
+// Type FeeModel computes the fee, in the base asset, charged on a fill with the
+// given base-asset notional value.
+type FeeModel interface {
+	Fee(venue string, maker bool, notional float64) float64
+}
+
+// Type FlatFees charges a single proportional fee rate per venue for both maker
+// and taker fills, e.g. FlatFees{routefire.Gemini: 0.0035}.
+type FlatFees map[string]float64
+
+func (f FlatFees) Fee(venue string, maker bool, notional float64) float64 {
+	return f[venue] * notional
+}
+
+// Type Order is a simulated DMA order.
+type Order struct {
+	ID        string
+	Venue     string
+	Asset     string
+	BaseAsset string
+	Side      string
+	Quantity  float64
+	Price     float64
+	Filled    float64
+	Notional  float64 // Base-asset value of all fills, before fees
+	Fees      float64
+	Status    string
+	Submitted time.Time
+	ActiveAt  time.Time
+	active    bool
+}
+
+// Function Remaining returns the unfilled quantity of the order.
+func (o *Order) Remaining() float64 {
+	if r := o.Quantity - o.Filled; r > epsilon {
+		return r
+	}
+	return 0
+}
+
+// Function AvgPrice returns the average fill price of the order.
+func (o *Order) AvgPrice() float64 {
+	if o.Filled <= epsilon {
+		return 0
+	}
+	return o.Notional / o.Filled
+}
+
+// Function IsOpen reports whether the order can still be filled.
+func (o *Order) IsOpen() bool {
+	return o.Status == routefire.StatusOpen || o.Status == routefire.StatusPartiallyFilled
+}
+
+// Type Fill is a single simulated execution.
+type Fill struct {
+	OrderID   string
+	Venue     string
+	Asset     string
+	BaseAsset string
+	Side      string
+	Quantity  float64
+	Price     float64
+	Fee       float64
+	Maker     bool
+	Time      time.Time
+}
+
+// Type Exchange is a set of simulated venues sharing consolidated order books.
+// It is safe for concurrent use.
+type Exchange struct {
+	// Latency is the delay between submission and the order reaching the venue.
+	Latency time.Duration
+	// Fees is the fee model applied to fills; nil means no fees.
+	Fees FeeModel
+	// EnforceBalances rejects orders that cannot be funded from venue balances.
+	EnforceBalances bool
+	// OnFill, if set, is called (with the exchange lock held) for every fill.
+	OnFill func(Fill)
+
+	lock     sync.Mutex
+	seq      int
+	orders   []*Order
+	byKey    map[string]*Order
+	books    map[string]*routefire.DmaOrderBook
+	consumed map[string]map[string]float64
+	balances map[string]map[string]float64
+	fills    []Fill
+}
+
+// Function NewExchange creates an empty simulated exchange.
+func NewExchange() *Exchange {
+	return &Exchange{
+		byKey:    map[string]*Order{},
+		books:    map[string]*routefire.DmaOrderBook{},
+		consumed: map[string]map[string]float64{},
+		balances: map[string]map[string]float64{},
+	}
+}
+
+// Function SetBalance sets the balance of an asset at a venue.
+func (x *Exchange) SetBalance(venue, asset string, amount float64) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.adjust(venue, asset, amount-x.balance(venue, asset))
+}
+
+// Function Balance returns the balance of an asset at a venue.
+func (x *Exchange) Balance(venue, asset string) float64 {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.balance(venue, asset)
+}
+
+// Function Balances returns a copy of all balances, keyed by venue then asset.
+func (x *Exchange) Balances() map[string]map[string]float64 {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	out := map[string]map[string]float64{}
+	for v, m := range x.balances {
+		out[v] = map[string]float64{}
+		for a, amt := range m {
+			out[v][a] = amt
+		}
+	}
+	return out
+}
+
+// Function Submit places a limit order. It becomes active, and may fill against
+// the current book, once Latency has elapsed after now.
+func (x *Exchange) Submit(now time.Time, venue, asset, baseAsset, side string, quantity, price float64) (Order, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	side = strings.ToUpper(side)
+	if quantity <= 0 || price <= 0 || (side != routefire.SideBuy && side != routefire.SideSell) {
+		return Order{}, ErrInvalidOrder
+	}
+	if x.EnforceBalances {
+		asset0, need := x.requirement(venue, asset, baseAsset, side, quantity, price)
+		if x.balance(venue, asset0)-x.reserved(venue, asset0) < need-epsilon {
+			return Order{}, ErrInsufficientBalance
+		}
+	}
+
+	x.seq++
+	o := &Order{
+		ID:        fmt.Sprintf("SIM-%06d", x.seq),
+		Venue:     venue,
+		Asset:     strings.ToLower(asset),
+		BaseAsset: strings.ToLower(baseAsset),
+		Side:      side,
+		Quantity:  quantity,
+		Price:     price,
+		Status:    routefire.StatusOpen,
+		Submitted: now,
+		ActiveAt:  now.Add(x.Latency),
+	}
+	x.orders = append(x.orders, o)
+	x.byKey[orderKey(venue, o.ID)] = o
+	if !o.ActiveAt.After(now) {
+		x.activate(now, o)
+	}
+	return *o, nil
+}
+
+// Function Cancel cancels an open order.
+func (x *Exchange) Cancel(venue, id string) (Order, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	o, ok := x.byKey[orderKey(venue, id)]
+	if !ok {
+		return Order{}, ErrUnknownOrder
+	}
+	if !o.IsOpen() {
+		return *o, ErrOrderClosed
+	}
+	o.Status = routefire.StatusCancelled
+	return *o, nil
+}
+
+// Function Order returns the current state of an order.
+func (x *Exchange) Order(venue, id string) (Order, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	o, ok := x.byKey[orderKey(venue, id)]
+	if !ok {
+		return Order{}, ErrUnknownOrder
+	}
+	return *o, nil
+}
+
+// Function Orders returns all orders in submission order.
+func (x *Exchange) Orders() []Order {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	out := make([]Order, 0, len(x.orders))
+	for _, o := range x.orders {
+		out = append(out, *o)
+	}
+	return out
+}
+
+// Function Fills returns all fills in execution order.
+func (x *Exchange) Fills() []Fill {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return append([]Fill(nil), x.fills...)
+}
+
+// Function UpdateBook replaces the consolidated book for a pair and fills any
+// resting orders that the new book trades through.
+func (x *Exchange) UpdateBook(now time.Time, asset, baseAsset string, ob *routefire.DmaOrderBook) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	pair := pairKey(asset, baseAsset)
+	x.activatePending(now)
+	x.books[pair] = ob
+	x.consumed[pair] = carryConsumed(x.consumed[pair], ob)
+	for _, o := range x.orders {
+		if o.active && o.IsOpen() && pairKey(o.Asset, o.BaseAsset) == pair {
+			x.match(now, o, false)
+		}
+	}
+}
+
+// Function Advance moves the simulated clock to now, activating orders whose
+// latency has elapsed.
+func (x *Exchange) Advance(now time.Time) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.activatePending(now)
+}
+
+// Function Book returns the current book for a pair, less any liquidity already
+// consumed by simulated fills.
+func (x *Exchange) Book(asset, baseAsset string) (*routefire.DmaOrderBook, bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	pair := pairKey(asset, baseAsset)
+	ob, ok := x.books[pair]
+	if !ok {
+		return nil, false
+	}
+	used := x.consumed[pair]
+	out := &routefire.DmaOrderBook{
+		Bids:   remainingLevels(ob.Bids, routefire.SideSell, used),
+		Offers: remainingLevels(ob.Offers, routefire.SideBuy, used),
+	}
+	return out, true
+}
+
+func (x *Exchange) activatePending(now time.Time) {
+	for _, o := range x.orders {
+		if !o.active && o.IsOpen() && !o.ActiveAt.After(now) {
+			x.activate(now, o)
+		}
+	}
+}
+
+func (x *Exchange) activate(now time.Time, o *Order) {
+	o.active = true
+	x.match(now, o, true)
+}
+
+// match fills o against its venue's liquidity in the current book. Taker fills
+// execute at the resting prices; maker fills execute at the order's limit.
+func (x *Exchange) match(now time.Time, o *Order, taker bool) {
+	pair := pairKey(o.Asset, o.BaseAsset)
+	ob, ok := x.books[pair]
+	if !ok {
+		return
+	}
+	side := ob.Offers
+	if o.Side == routefire.SideSell {
+		side = ob.Bids
+	}
+
+	type level struct {
+		key      string
+		price    float64
+		quantity float64
+	}
+	var levels []level
+	index := map[string]int{}
+	for _, e := range side {
+		if !strings.EqualFold(e.Venue, o.Venue) {
+			continue
+		}
+		px, qty, err := e.Floats()
+		if err != nil {
+			continue
+		}
+		if (o.Side == routefire.SideBuy && px <= o.Price) || (o.Side == routefire.SideSell && px >= o.Price) {
+			key := levelKey(e.Venue, o.Side, px)
+			if i, ok := index[key]; ok {
+				levels[i].quantity += qty
+			} else {
+				index[key] = len(levels)
+				levels = append(levels, level{key, px, qty})
+			}
+		}
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		if o.Side == routefire.SideBuy {
+			return levels[i].price < levels[j].price
+		}
+		return levels[i].price > levels[j].price
+	})
+
+	used := x.consumed[pair]
+	for _, l := range levels {
+		rem := o.Remaining()
+		if rem <= 0 {
+			break
+		}
+		avail := l.quantity - used[l.key]
+		if avail <= epsilon {
+			continue
+		}
+		q := avail
+		if rem < q {
+			q = rem
+		}
+		used[l.key] += q
+		px := l.price
+		if !taker {
+			px = o.Price
+		}
+		x.fill(now, o, q, px, !taker)
+	}
+	if o.Remaining() <= 0 {
+		o.Status = routefire.StatusFilled
+	} else if o.Filled > epsilon {
+		o.Status = routefire.StatusPartiallyFilled
+	}
+}
+
+func (x *Exchange) fill(now time.Time, o *Order, qty, px float64, maker bool) {
+	notional := qty * px
+	fee := 0.0
+	if x.Fees != nil {
+		fee = x.Fees.Fee(o.Venue, maker, notional)
+	}
+	o.Filled += qty
+	o.Notional += notional
+	o.Fees += fee
+	if o.Side == routefire.SideBuy {
+		x.adjust(o.Venue, o.Asset, qty)
+		x.adjust(o.Venue, o.BaseAsset, -notional-fee)
+	} else {
+		x.adjust(o.Venue, o.Asset, -qty)
+		x.adjust(o.Venue, o.BaseAsset, notional-fee)
+	}
+	f := Fill{
+		OrderID:   o.ID,
+		Venue:     o.Venue,
+		Asset:     o.Asset,
+		BaseAsset: o.BaseAsset,
+		Side:      o.Side,
+		Quantity:  qty,
+		Price:     px,
+		Fee:       fee,
+		Maker:     maker,
+		Time:      now,
+	}
+	x.fills = append(x.fills, f)
+	if x.OnFill != nil {
+		x.OnFill(f)
+	}
+}
+
+// requirement returns the asset and amount needed at a venue to fund an order.
+func (x *Exchange) requirement(venue, asset, baseAsset, side string, quantity, price float64) (string, float64) {
+	if side == routefire.SideSell {
+		return strings.ToLower(asset), quantity
+	}
+	notional := quantity * price
+	fee := 0.0
+	if x.Fees != nil {
+		fee = x.Fees.Fee(venue, false, notional)
+	}
+	return strings.ToLower(baseAsset), notional + fee
+}
+
+// reserved returns the amount of an asset committed to open orders at a venue.
+func (x *Exchange) reserved(venue, asset string) float64 {
+	total := 0.0
+	for _, o := range x.orders {
+		if o.IsOpen() && strings.EqualFold(o.Venue, venue) {
+			if a, amt := x.requirement(venue, o.Asset, o.BaseAsset, o.Side, o.Remaining(), o.Price); a == asset {
+				total += amt
+			}
+		}
+	}
+	return total
+}
+
+func (x *Exchange) balance(venue, asset string) float64 {
+	return x.balances[venue][strings.ToLower(asset)]
+}
+
+func (x *Exchange) adjust(venue, asset string, delta float64) {
+	m, ok := x.balances[venue]
+	if !ok {
+		m = map[string]float64{}
+		x.balances[venue] = m
+	}
+	m[strings.ToLower(asset)] += delta
+}
+
+// carryConsumed keeps the consumed amounts of price levels still present in a
+// new book, capped at the quantity now available at each level.
+func carryConsumed(used map[string]float64, ob *routefire.DmaOrderBook) map[string]float64 {
+	out := map[string]float64{}
+	if len(used) == 0 {
+		return out
+	}
+	avail := map[string]float64{}
+	for _, l := range []struct {
+		entries   []routefire.DmaOrderBookEntry
+		takerSide string
+	}{{ob.Offers, routefire.SideBuy}, {ob.Bids, routefire.SideSell}} {
+		for _, e := range l.entries {
+			if px, qty, err := e.Floats(); err == nil {
+				avail[levelKey(e.Venue, l.takerSide, px)] += qty
+			}
+		}
+	}
+	for key, u := range used {
+		if a := avail[key]; a > epsilon {
+			out[key] = math.Min(u, a)
+		}
+	}
+	return out
+}
+
+func remainingLevels(side []routefire.DmaOrderBookEntry, takerSide string, used map[string]float64) []routefire.DmaOrderBookEntry {
+	left := map[string]float64{}
+	for k, v := range used {
+		left[k] = v
+	}
+	out := make([]routefire.DmaOrderBookEntry, 0, len(side))
+	for _, e := range side {
+		px, qty, err := e.Floats()
+		if err != nil {
+			continue
+		}
+		key := levelKey(e.Venue, takerSide, px)
+		u := math.Min(left[key], qty)
+		left[key] -= u
+		if qty-u <= epsilon {
+			continue
+		}
+		if u > 0 {
+			e.Amount = routefire.FormatFloat(qty - u)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func pairKey(asset, baseAsset string) string {
+	return strings.ToLower(asset) + "/" + strings.ToLower(baseAsset)
+}
+
+func orderKey(venue, id string) string {
+	return strings.ToUpper(venue) + "|" + id
+}
+
+// levelKey identifies a price level at a venue by the side that takes it.
+func levelKey(venue, takerSide string, price float64) string {
+	return fmt.Sprintf("%s|%s|%g", strings.ToUpper(venue), takerSide, price)
+}
@@ -0,0 +1,344 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/indicators"
+)
+
+var (
+	ErrUnknownOrder = errors.New("strategy: unknown order")
+	ErrNotAccepted  = errors.New("strategy: order not accepted")
+)
+
+// Type Clock drives the engine. Wait blocks for d (or simulates doing so) and
+// reports whether the engine should keep running. backtest.Engine implements
+// Clock; RealClock returns one backed by the system clock.
+type Clock interface {
+	Now() time.Time
+	Wait(d time.Duration) bool
+}
+
+type realClock struct {
+	stop <-chan struct{}
+}
+
+// Function RealClock returns a Clock backed by the system clock. Wait returns
+// false once stop is closed.
+func RealClock(stop <-chan struct{}) Clock {
+	return realClock{stop}
+}
+
+func (c realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c realClock) Wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c.stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// Type RiskCheck vets an order before it is submitted. A non-nil error rejects it.
+type RiskCheck func(ctx *Context, req OrderRequest) error
+
+// Type Config holds engine settings.
+type Config struct {
+	UserId string
+	// Pairs are the markets whose consolidated books are polled every tick.
+	Pairs []Pair
+	// Interval is the time between ticks.
+	Interval time.Duration
+	// BarPeriod, if positive, builds bars of mid prices for OnBar.
+	BarPeriod time.Duration
+	// RiskChecks are applied, in order, to every order request.
+	RiskChecks []RiskCheck
+	// Logger receives engine diagnostics; nil uses the standard logger.
+	Logger *log.Logger
+}
+
+// Type Engine runs a Strategy.
+type Engine struct {
+	api      routefire.DMA
+	clock    Clock
+	strategy Strategy
+	cfg      Config
+	ctx      *Context
+	bars     map[Pair]*indicators.BarBuilder
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Function New creates an engine running s against api, driven by clock. If
+// clock is nil the system clock is used.
+func New(api routefire.DMA, clock Clock, s Strategy, cfg Config) *Engine {
+	e := &Engine{
+		api:      api,
+		strategy: s,
+		cfg:      cfg,
+		bars:     map[Pair]*indicators.BarBuilder{},
+		stop:     make(chan struct{}),
+	}
+	if clock == nil {
+		clock = RealClock(e.stop)
+	}
+	e.clock = clock
+	if cfg.BarPeriod > 0 {
+		for _, p := range cfg.Pairs {
+			e.bars[p] = indicators.NewBarBuilder(cfg.BarPeriod)
+		}
+	}
+	e.ctx = &Context{engine: e, books: map[Pair]*routefire.DmaOrderBook{}, orders: map[string]*Order{}}
+	return e
+}
+
+// Function Context returns the context passed to strategy callbacks.
+func (e *Engine) Context() *Context {
+	return e.ctx
+}
+
+// Function Stop ends the run after the current tick. It is safe to call from
+// any goroutine.
+func (e *Engine) Stop() {
+	e.stopOnce.Do(func() { close(e.stop) })
+}
+
+func (e *Engine) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Function Run runs the strategy until Stop is called or the clock ends (e.g.
+// a backtest runs out of data).
+func (e *Engine) Run() error {
+	if err := e.strategy.OnStart(e.ctx); err != nil {
+		return err
+	}
+	defer e.strategy.OnStop(e.ctx)
+
+	wait := time.Duration(0)
+	for !e.stopped() && e.clock.Wait(wait) {
+		e.tick()
+		wait = e.cfg.Interval
+	}
+	return nil
+}
+
+func (e *Engine) tick() {
+	now := e.clock.Now()
+	for _, p := range e.cfg.Pairs {
+		ob, err := e.api.GetConsolidatedOrderBookDMA(e.cfg.UserId, p.Asset, p.BaseAsset)
+		if err == nil {
+			err = routefire.FirstDmaError(ob.Errors)
+		}
+		if err != nil {
+			e.logf("book %s: %s", p, err)
+			continue
+		}
+		book := ob.Data
+		e.ctx.books[p] = &book
+		e.strategy.OnBook(e.ctx, p, &book)
+		if bb, ok := e.bars[p]; ok {
+			if bar, done := bb.AddBook(now, &book); done {
+				e.strategy.OnBar(e.ctx, p, bar)
+			}
+		}
+	}
+	e.pollOrders(now)
+}
+
+// pollOrders refreshes every open order, reporting fills and status changes,
+// and cancels orders whose TTL has expired.
+func (e *Engine) pollOrders(now time.Time) {
+	for _, o := range e.ctx.openOrders() {
+		st, err := e.api.OrderStatusDMA(e.cfg.UserId, o.Venue, o.ID)
+		if err == nil {
+			err = routefire.FirstDmaError(st.Errors)
+		}
+		if err != nil {
+			e.logf("status %s@%s: %s", o.ID, o.Venue, err)
+			continue
+		}
+		e.update(now, o, st.Status, st.FilledAmount)
+
+		if o.IsOpen() && o.TTL > 0 && now.Sub(o.Submitted) >= o.TTL {
+			if err := e.ctx.Cancel(o.ID); err != nil {
+				e.logf("cancel expired %s@%s: %s", o.ID, o.Venue, err)
+			}
+		}
+	}
+}
+
+func (e *Engine) update(now time.Time, o *Order, status, filledAmount string) {
+	if filled, err := strconv.ParseFloat(filledAmount, 64); err == nil && filled > o.Filled {
+		delta := filled - o.Filled
+		o.Filled = filled
+		e.strategy.OnFill(e.ctx, Fill{Order: *o, Quantity: delta, Price: o.Price, Time: now})
+	}
+	if status != "" && status != o.Status {
+		o.Status = status
+		e.strategy.OnOrderUpdate(e.ctx, *o)
+	}
+}
+
+func (e *Engine) logf(format string, args ...interface{}) {
+	if e.cfg.Logger != nil {
+		e.cfg.Logger.Printf(format, args...)
+	} else {
+		log.Printf("STRATEGY> "+format, args...)
+	}
+}
+
+// Type Context gives strategy callbacks access to market data and order
+// management. It must only be used from within callbacks.
+type Context struct {
+	engine *Engine
+	books  map[Pair]*routefire.DmaOrderBook
+	orders map[string]*Order // Every order submitted, by ID
+	open   []*Order          // Orders that may still fill, in submission order
+}
+
+// Function Now returns the engine's current time (simulated in a backtest).
+func (c *Context) Now() time.Time {
+	return c.engine.clock.Now()
+}
+
+// Function UserId returns the configured user ID.
+func (c *Context) UserId() string {
+	return c.engine.cfg.UserId
+}
+
+// Function API returns the underlying DMA API, for calls the context does not wrap.
+func (c *Context) API() routefire.DMA {
+	return c.engine.api
+}
+
+// Function Book returns the most recent consolidated book for a pair.
+func (c *Context) Book(p Pair) (*routefire.DmaOrderBook, bool) {
+	ob, ok := c.books[p]
+	return ob, ok
+}
+
+// Function Logf logs a message through the engine's logger.
+func (c *Context) Logf(format string, args ...interface{}) {
+	c.engine.logf(format, args...)
+}
+
+// Function Submit applies the configured risk checks and submits a DMA order.
+func (c *Context) Submit(req OrderRequest) (*Order, error) {
+	for _, check := range c.engine.cfg.RiskChecks {
+		if err := check(c, req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.engine.api.SubmitOrderDMA(c.UserId(), req.Venue, req.Pair.Asset, req.Pair.BaseAsset, req.Side,
+		routefire.FormatFloat(req.Quantity), routefire.FormatFloat(req.Price), map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	if err := routefire.FirstDmaError(resp.Errors); err != nil {
+		return nil, err
+	}
+	if resp.VenueOrderId == "" {
+		return nil, ErrNotAccepted
+	}
+	o := &Order{
+		OrderRequest: req,
+		ID:           resp.VenueOrderId,
+		Status:       routefire.StatusOpen,
+		Submitted:    c.Now(),
+	}
+	c.orders[o.ID] = o
+	c.open = append(c.open, o)
+	cp := *o
+	return &cp, nil
+}
+
+// Function Buy submits a buy order.
+func (c *Context) Buy(venue string, p Pair, quantity, price float64) (*Order, error) {
+	return c.Submit(OrderRequest{Venue: venue, Pair: p, Side: routefire.SideBuy, Quantity: quantity, Price: price})
+}
+
+// Function Sell submits a sell order.
+func (c *Context) Sell(venue string, p Pair, quantity, price float64) (*Order, error) {
+	return c.Submit(OrderRequest{Venue: venue, Pair: p, Side: routefire.SideSell, Quantity: quantity, Price: price})
+}
+
+// Function Cancel cancels a tracked order and reports its final fills and status.
+func (c *Context) Cancel(id string) error {
+	o := c.order(id)
+	if o == nil {
+		return ErrUnknownOrder
+	}
+	resp, err := c.engine.api.CancelOrderDMA(c.UserId(), o.Venue, o.ID)
+	if err != nil {
+		return err
+	}
+	if err := routefire.FirstDmaError(resp.Errors); err != nil {
+		return fmt.Errorf("strategy: cancel %s: %s", o.ID, err)
+	}
+
+	// Pick up any fills that raced with the cancel before closing the order.
+	now := c.Now()
+	status := routefire.StatusCancelled
+	if st, err := c.engine.api.OrderStatusDMA(c.UserId(), o.Venue, o.ID); err == nil && len(st.Errors) == 0 {
+		c.engine.update(now, o, "", st.FilledAmount)
+		if st.Status == routefire.StatusFilled || st.Status == routefire.StatusComplete {
+			status = st.Status
+		}
+	}
+	c.engine.update(now, o, status, "")
+	return nil
+}
+
+// Function Order returns a tracked order by ID.
+func (c *Context) Order(id string) (Order, bool) {
+	if o := c.order(id); o != nil {
+		return *o, true
+	}
+	return Order{}, false
+}
+
+// Function OpenOrders returns all tracked orders that may still fill.
+func (c *Context) OpenOrders() []Order {
+	var out []Order
+	for _, o := range c.openOrders() {
+		out = append(out, *o)
+	}
+	return out
+}
+
+func (c *Context) order(id string) *Order {
+	return c.orders[id]
+}
+
+// openOrders returns the orders that may still fill, dropping closed orders
+// from the open set so that each tick only polls live orders.
+func (c *Context) openOrders() []*Order {
+	open := c.open[:0]
+	for _, o := range c.open {
+		if o.IsOpen() {
+			open = append(open, o)
+		}
+	}
+	for i := len(open); i < len(c.open); i++ {
+		c.open[i] = nil
+	}
+	c.open = open
+	return append([]*Order(nil), open...)
+}
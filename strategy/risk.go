@@ -0,0 +1,33 @@
+package strategy
+
+import "fmt"
+
+// Function MaxQuantity rejects orders for more than max units of the asset.
+func MaxQuantity(max float64) RiskCheck {
+	return func(ctx *Context, req OrderRequest) error {
+		if req.Quantity > max {
+			return fmt.Errorf("strategy: quantity %g exceeds limit %g", req.Quantity, max)
+		}
+		return nil
+	}
+}
+
+// Function MaxNotional rejects orders worth more than max in the base asset.
+func MaxNotional(max float64) RiskCheck {
+	return func(ctx *Context, req OrderRequest) error {
+		if n := req.Quantity * req.Price; n > max {
+			return fmt.Errorf("strategy: notional %g exceeds limit %g", n, max)
+		}
+		return nil
+	}
+}
+
+// Function MaxOpenOrders rejects orders while max orders are already open.
+func MaxOpenOrders(max int) RiskCheck {
+	return func(ctx *Context, req OrderRequest) error {
+		if n := len(ctx.openOrders()); n >= max {
+			return fmt.Errorf("strategy: %d open orders, limit %d", n, max)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+// Package strategy provides a runtime for trading strategies. A Strategy
+// receives market data and order events through callbacks; the Engine polls
+// consolidated books, builds bars, tracks and polls orders, applies risk checks
+// and cancels orders that outlive their time-to-live.
+//
+// The engine runs against any routefire.DMA implementation, so the same
+// strategy runs live (routefire.Client), paper traded (paper.Client) or in a
+// backtest (backtest.Engine, which also serves as the engine's Clock).
+//
+// All callbacks are invoked from the engine's goroutine, one at a time, so
+// strategies need no locking of their own.
+package strategy
+
+import (
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/indicators"
+)
+
+// Type Strategy is implemented by trading strategies. Embed Base to implement
+// only the callbacks a strategy needs.
+type Strategy interface {
+	// OnStart is called once before the first tick. Returning an error aborts the run.
+	OnStart(ctx *Context) error
+	// OnBook is called with each new consolidated book, in the order the pairs
+	// were configured.
+	OnBook(ctx *Context, pair Pair, book *routefire.DmaOrderBook)
+	// OnBar is called each time a bar of mid prices completes for a pair.
+	OnBar(ctx *Context, pair Pair, bar indicators.Bar)
+	// OnFill is called when a tracked order's filled quantity increases.
+	OnFill(ctx *Context, fill Fill)
+	// OnOrderUpdate is called when a tracked order's status changes.
+	OnOrderUpdate(ctx *Context, order Order)
+	// OnStop is called once when the run ends.
+	OnStop(ctx *Context)
+}
+
+// Type Base provides no-op implementations of every Strategy callback.
+type Base struct{}
+
+func (Base) OnStart(ctx *Context) error                                   { return nil }
+func (Base) OnBook(ctx *Context, pair Pair, book *routefire.DmaOrderBook) {}
+func (Base) OnBar(ctx *Context, pair Pair, bar indicators.Bar)            {}
+func (Base) OnFill(ctx *Context, fill Fill)                               {}
+func (Base) OnOrderUpdate(ctx *Context, order Order)                      {}
+func (Base) OnStop(ctx *Context)                                          {}
+
+// Type Pair identifies a market: an asset quoted in a base asset.
+type Pair struct {
+	Asset     string
+	BaseAsset string
+}
+
+func (p Pair) String() string {
+	return p.Asset + "/" + p.BaseAsset
+}
+
+// Type OrderRequest describes a DMA limit order to submit.
+type OrderRequest struct {
+	Venue    string
+	Pair     Pair
+	Side     string
+	Quantity float64
+	Price    float64
+	// TTL, if positive, is how long the order may stay open before the engine
+	// cancels it.
+	TTL time.Duration
+	// Tag is an optional label carried on the resulting Order.
+	Tag string
+}
+
+// Type Order is a DMA order tracked by the engine.
+type Order struct {
+	OrderRequest
+	ID        string
+	Filled    float64
+	Status    string
+	Submitted time.Time
+}
+
+// Function IsOpen reports whether the order may still fill.
+func (o *Order) IsOpen() bool {
+	return routefire.IsOpenStatus(o.Status)
+}
+
+// Type Fill is an increase in an order's filled quantity. The DMA status API
+// reports filled quantity only, so Price is the order's limit price.
+type Fill struct {
+	Order    Order
+	Quantity float64
+	Price    float64
+	Time     time.Time
+}
@@ -0,0 +1,140 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+	"github.com/routefire/go-routefire/indicators"
+)
+
+var (
+	t0     = time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	btcUsd = Pair{routefire.Btc, routefire.Usd}
+)
+
+func snapshots(prices ...string) []backtest.Snapshot {
+	var out []backtest.Snapshot
+	for i, px := range prices {
+		out = append(out, backtest.Snapshot{
+			Time:      t0.Add(time.Duration(i) * 30 * time.Second),
+			Asset:     routefire.Btc,
+			BaseAsset: routefire.Usd,
+			Book: routefire.DmaOrderBook{
+				Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: px, Amount: "1"}},
+				Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: px, Amount: "1"}},
+			},
+		})
+	}
+	return out
+}
+
+type recorder struct {
+	Base
+	books   int
+	bars    []indicators.Bar
+	fills   []Fill
+	updates []Order
+	stopped bool
+	submit  func(ctx *Context)
+}
+
+func (r *recorder) OnBook(ctx *Context, p Pair, ob *routefire.DmaOrderBook) {
+	r.books++
+	if r.submit != nil {
+		r.submit(ctx)
+		r.submit = nil
+	}
+}
+
+func (r *recorder) OnBar(ctx *Context, p Pair, bar indicators.Bar) { r.bars = append(r.bars, bar) }
+func (r *recorder) OnFill(ctx *Context, f Fill)                    { r.fills = append(r.fills, f) }
+func (r *recorder) OnOrderUpdate(ctx *Context, o Order)            { r.updates = append(r.updates, o) }
+func (r *recorder) OnStop(ctx *Context)                            { r.stopped = true }
+
+func TestBacktestRun(t *testing.T) {
+	bt := backtest.New(snapshots("100", "101", "99", "102", "103"), backtest.Config{})
+	r := &recorder{}
+	r.submit = func(ctx *Context) {
+		if _, err := ctx.Buy(routefire.Gemini, btcUsd, 1, 99.5); err != nil {
+			t.Fatalf("submit failed: %s", err)
+		}
+	}
+	e := New(bt, bt, r, Config{Pairs: []Pair{btcUsd}, Interval: 30 * time.Second, BarPeriod: time.Minute})
+	if err := e.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.books != 5 || !r.stopped {
+		t.Errorf("expected 5 books and a stop, got %d %v", r.books, r.stopped)
+	}
+	if len(r.bars) != 2 || r.bars[0].Open != 100 || r.bars[0].Close != 101 {
+		t.Errorf("unexpected bars %+v", r.bars)
+	}
+	if len(r.fills) != 1 || r.fills[0].Quantity != 1 {
+		t.Fatalf("expected one fill, got %+v", r.fills)
+	}
+	if len(r.updates) != 1 || r.updates[0].Status != routefire.StatusFilled {
+		t.Errorf("unexpected updates %+v", r.updates)
+	}
+	if len(e.Context().OpenOrders()) != 0 {
+		t.Errorf("no orders should remain open")
+	}
+}
+
+func TestRiskChecksAndTTL(t *testing.T) {
+	bt := backtest.New(snapshots("100", "101", "102", "103"), backtest.Config{})
+	r := &recorder{}
+	var rejected error
+	r.submit = func(ctx *Context) {
+		_, rejected = ctx.Buy(routefire.Gemini, btcUsd, 5, 90)
+		if _, err := ctx.Submit(OrderRequest{Venue: routefire.Gemini, Pair: btcUsd, Side: routefire.SideBuy, Quantity: 1, Price: 90, TTL: time.Minute}); err != nil {
+			t.Fatalf("submit failed: %s", err)
+		}
+	}
+	e := New(bt, bt, r, Config{
+		Pairs:      []Pair{btcUsd},
+		Interval:   30 * time.Second,
+		RiskChecks: []RiskCheck{MaxQuantity(2), MaxOpenOrders(1)},
+	})
+	e.Run()
+
+	if rejected == nil {
+		t.Errorf("oversized order should be rejected")
+	}
+	if len(r.updates) != 1 || r.updates[0].Status != routefire.StatusCancelled {
+		t.Fatalf("expected the order to be cancelled on expiry, got %+v", r.updates)
+	}
+	if st, _ := bt.OrderStatusDMA("", routefire.Gemini, r.updates[0].ID); st.Status != routefire.StatusCancelled {
+		t.Errorf("order should be cancelled at the venue, got %+v", st)
+	}
+	// The cancelled order is no longer polled, but can still be looked up.
+	if len(e.ctx.openOrders()) != 0 || len(e.ctx.open) != 0 {
+		t.Errorf("closed orders should leave the open set")
+	}
+	if o, ok := e.ctx.Order(r.updates[0].ID); !ok || o.Status != routefire.StatusCancelled {
+		t.Errorf("expected the cancelled order to be found, got %+v", o)
+	}
+}
+
+// silentVenue accepts orders without returning an id.
+type silentVenue struct {
+	*backtest.Engine
+}
+
+func (v silentVenue) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	return &routefire.PlaceDmaOrderResponse{VenueId: venue}, nil
+}
+
+func TestUnacceptedOrder(t *testing.T) {
+	bt := backtest.New(snapshots("100", "101"), backtest.Config{})
+	r := &recorder{}
+	var err error
+	r.submit = func(ctx *Context) { _, err = ctx.Buy(routefire.Gemini, btcUsd, 1, 90) }
+	e := New(silentVenue{bt}, bt, r, Config{Pairs: []Pair{btcUsd}, Interval: 30 * time.Second})
+	e.Run()
+	if err != ErrNotAccepted || len(e.ctx.orders) != 0 {
+		t.Errorf("expected ErrNotAccepted without tracking the order, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package tca
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+// Type Result is an execution with its measures. TimeToFill is in seconds and
+// is set only for orders that filled completely.
+type Result struct {
+	Execution
+	Filled       float64 `json:"filled"`
+	AvgPrice     float64 `json:"avg_price"`
+	FillRate     float64 `json:"fill_rate"`
+	SlippageBps  float64 `json:"slippage_bps"`
+	Shortfall    float64 `json:"shortfall"`
+	ShortfallBps float64 `json:"shortfall_bps"`
+	TimeToFill   float64 `json:"time_to_fill_s,omitempty"`
+}
+
+// Type Summary aggregates the results of a group of orders. Slippage is
+// weighted by filled value at the arrival mid, shortfall by order value at the
+// arrival mid. FillRate is the mean fill rate of the orders and TimeToFill the
+// mean time to fill, in seconds, of those that filled completely. Shortfall is
+// summed across base assets, so it is only meaningful when the orders share a
+// base asset.
+type Summary struct {
+	Group        string  `json:"group"`
+	Orders       int     `json:"orders"`
+	Completed    int     `json:"completed"`
+	FillRate     float64 `json:"fill_rate"`
+	SlippageBps  float64 `json:"slippage_bps"`
+	Shortfall    float64 `json:"shortfall"`
+	ShortfallBps float64 `json:"shortfall_bps"`
+	TimeToFill   float64 `json:"time_to_fill_s"`
+}
+
+// Type Report is a transaction cost analysis of the recorded orders. DMA
+// orders are summarized by venue; all orders are summarized by algorithm,
+// with DMA orders under AlgoDMA.
+type Report struct {
+	Time    time.Time `json:"time"`
+	Results []Result  `json:"results"`
+	ByVenue []Summary `json:"by_venue"`
+	ByAlgo  []Summary `json:"by_algo"`
+}
+
+// Function Report analyzes the recorded orders.
+func (r *Recorder) Report() *Report {
+	r.lock.Lock()
+	execs := make([]Execution, 0, len(r.orders))
+	for _, e := range r.orders {
+		c := *e
+		c.Fills = append([]Fill(nil), e.Fills...)
+		execs = append(execs, c)
+	}
+	r.lock.Unlock()
+	sort.SliceStable(execs, func(i, j int) bool {
+		if !execs[i].SubmittedAt.Equal(execs[j].SubmittedAt) {
+			return execs[i].SubmittedAt.Before(execs[j].SubmittedAt)
+		}
+		return execs[i].OrderId < execs[j].OrderId
+	})
+
+	rep := &Report{Time: r.Now()}
+	venues := map[string][]Result{}
+	algos := map[string][]Result{}
+	for _, e := range execs {
+		res := Result{
+			Execution:    e,
+			Filled:       e.Filled(),
+			AvgPrice:     e.AvgPrice(),
+			FillRate:     e.FillRate(),
+			SlippageBps:  e.SlippageBps(),
+			Shortfall:    e.Shortfall(),
+			ShortfallBps: e.ShortfallBps(),
+		}
+		if d, complete := e.TimeToFill(); complete {
+			res.TimeToFill = d.Seconds()
+		}
+		rep.Results = append(rep.Results, res)
+		if e.Algo == AlgoDMA {
+			venues[e.Venue] = append(venues[e.Venue], res)
+		}
+		algos[e.Algo] = append(algos[e.Algo], res)
+	}
+	rep.ByVenue = summarize(venues)
+	rep.ByAlgo = summarize(algos)
+	return rep
+}
+
+func summarize(groups map[string][]Result) []Summary {
+	out := make([]Summary, 0, len(groups))
+	for group, results := range groups {
+		s := Summary{Group: group, Orders: len(results)}
+		var filledValue, orderValue float64
+		for _, res := range results {
+			s.FillRate += res.FillRate
+			s.Shortfall += res.Shortfall
+			if res.ArrivalMid > 0 {
+				fv := res.Filled * res.ArrivalMid
+				s.SlippageBps += res.SlippageBps * fv
+				filledValue += fv
+				ov := res.Quantity * res.ArrivalMid
+				s.ShortfallBps += res.ShortfallBps * ov
+				orderValue += ov
+			}
+			if _, complete := res.Execution.TimeToFill(); complete {
+				s.Completed++
+				s.TimeToFill += res.TimeToFill
+			}
+		}
+		s.FillRate /= float64(len(results))
+		if filledValue > 0 {
+			s.SlippageBps /= filledValue
+		}
+		if orderValue > 0 {
+			s.ShortfallBps /= orderValue
+		}
+		if s.Completed > 0 {
+			s.TimeToFill /= float64(s.Completed)
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Group < out[j].Group })
+	return out
+}
+
+// Function WriteJSON writes the report as JSON.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// Function WriteCSV writes one row per order, with a header row.
+func (rep *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"order_id", "venue", "algo", "asset", "base_asset", "side", "submitted_at", "status",
+		"quantity", "filled", "fill_rate", "avg_price", "arrival_mid", "arrival_iso_cost",
+		"slippage_bps", "shortfall", "shortfall_bps", "time_to_fill_s"})
+	for _, res := range rep.Results {
+		cw.Write([]string{
+			res.OrderId, res.Venue, res.Algo, res.Asset, res.BaseAsset, res.Side,
+			res.SubmittedAt.UTC().Format(time.RFC3339), res.Status,
+			routefire.FormatFloat(res.Quantity), routefire.FormatFloat(res.Filled),
+			routefire.FormatFloat(res.FillRate), routefire.FormatFloat(res.AvgPrice),
+			routefire.FormatFloat(res.ArrivalMid), routefire.FormatFloat(res.ArrivalIsoCost),
+			routefire.FormatFloat(res.SlippageBps), routefire.FormatFloat(res.Shortfall),
+			routefire.FormatFloat(res.ShortfallBps), routefire.FormatFloat(res.TimeToFill),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Function WriteSummaryCSV writes one row per venue and per algorithm, with a
+// header row. The first column is "venue" or "algo".
+func (rep *Report) WriteSummaryCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"by", "group", "orders", "completed", "fill_rate", "slippage_bps",
+		"shortfall", "shortfall_bps", "time_to_fill_s"})
+	write := func(by string, summaries []Summary) {
+		for _, s := range summaries {
+			cw.Write([]string{
+				by, s.Group, strconv.Itoa(s.Orders), strconv.Itoa(s.Completed),
+				routefire.FormatFloat(s.FillRate), routefire.FormatFloat(s.SlippageBps),
+				routefire.FormatFloat(s.Shortfall), routefire.FormatFloat(s.ShortfallBps),
+				routefire.FormatFloat(s.TimeToFill),
+			})
+		}
+	}
+	write("venue", rep.ByVenue)
+	write("algo", rep.ByAlgo)
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,426 @@
+// Package tca measures execution quality. A Recorder captures the arrival mid
+// price (and, for clients implementing routefire.API, the IsoCost from
+// GetOrderBookStats) when an order is submitted, records its fills as its
+// status is polled, and reports slippage against arrival, implementation
+// shortfall, fill rate and time to fill, per order and aggregated per venue
+// and per algorithm.
+package tca
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/routefire/go-routefire"
+)
+
+const epsilon = 1e-12
+
+var (
+	ErrUnknownOrder = errors.New("tca: unknown order")
+	ErrNotAccepted  = errors.New("tca: order not accepted")
+)
+
+// AlgoDMA is the algorithm name under which DMA orders are reported.
+const AlgoDMA = "dma"
+
+// Type Fill is an increase in an order's filled quantity, observed at Time.
+type Fill struct {
+	Time     time.Time `json:"time"`
+	Quantity float64   `json:"quantity"`
+	Price    float64   `json:"price"`
+}
+
+// Type Execution is an order and its fills. Prices are in the base asset.
+// ArrivalIsoCost is zero when the stats were not available.
+type Execution struct {
+	OrderId        string    `json:"order_id"`
+	Venue          string    `json:"venue,omitempty"`
+	Algo           string    `json:"algo"`
+	Asset          string    `json:"asset"`
+	BaseAsset      string    `json:"base_asset"`
+	Side           string    `json:"side"`
+	Quantity       float64   `json:"quantity"`
+	Limit          float64   `json:"limit,omitempty"`
+	SubmittedAt    time.Time `json:"submitted_at"`
+	ArrivalMid     float64   `json:"arrival_mid"`
+	ArrivalIsoCost float64   `json:"arrival_iso_cost,omitempty"`
+	Status         string    `json:"status"`
+	Fills          []Fill    `json:"fills"`
+	// LastMid is the mid when the order's status was last polled, used to
+	// value its unfilled quantity.
+	LastMid float64 `json:"last_mid"`
+
+	userId string
+}
+
+func (e *Execution) copy() *Execution {
+	cp := *e
+	cp.Fills = append([]Fill(nil), e.Fills...)
+	return &cp
+}
+
+// Function Filled returns the quantity filled.
+func (e *Execution) Filled() float64 {
+	total := 0.0
+	for _, f := range e.Fills {
+		total += f.Quantity
+	}
+	return total
+}
+
+// Function AvgPrice returns the quantity-weighted average fill price, or zero
+// if nothing has filled.
+func (e *Execution) AvgPrice() float64 {
+	qty, notional := 0.0, 0.0
+	for _, f := range e.Fills {
+		qty += f.Quantity
+		notional += f.Quantity * f.Price
+	}
+	if qty < epsilon {
+		return 0
+	}
+	return notional / qty
+}
+
+// Function FillRate returns the fraction of the quantity filled.
+func (e *Execution) FillRate() float64 {
+	if e.Quantity <= 0 {
+		return 0
+	}
+	return e.Filled() / e.Quantity
+}
+
+// Function TimeToFill returns the time from submission to the last fill, and
+// whether the order has filled completely.
+func (e *Execution) TimeToFill() (time.Duration, bool) {
+	if len(e.Fills) == 0 {
+		return 0, false
+	}
+	return e.Fills[len(e.Fills)-1].Time.Sub(e.SubmittedAt), e.Quantity-e.Filled() < epsilon
+}
+
+// sign is 1 for buys and -1 for sells, so that positive costs are adverse.
+func (e *Execution) sign() float64 {
+	if e.Side == routefire.SideBuy {
+		return 1
+	}
+	return -1
+}
+
+// Function SlippageBps returns the average fill price's distance from the
+// arrival mid, in basis points. Positive values are costs: paying above the
+// mid on a buy, or selling below it.
+func (e *Execution) SlippageBps() float64 {
+	if e.ArrivalMid <= 0 || len(e.Fills) == 0 {
+		return 0
+	}
+	return e.sign() * (e.AvgPrice() - e.ArrivalMid) / e.ArrivalMid * 10000
+}
+
+// Function Shortfall returns the implementation shortfall in the base asset:
+// the cost of the fills against the arrival mid, plus the opportunity cost of
+// the unfilled quantity, valued at LastMid.
+func (e *Execution) Shortfall() float64 {
+	if e.ArrivalMid <= 0 {
+		return 0
+	}
+	filled := e.Filled()
+	cost := e.sign() * filled * (e.AvgPrice() - e.ArrivalMid)
+	if unfilled := e.Quantity - filled; unfilled > epsilon && e.LastMid > 0 {
+		cost += e.sign() * unfilled * (e.LastMid - e.ArrivalMid)
+	}
+	return cost
+}
+
+// Function ShortfallBps returns the implementation shortfall in basis points
+// of the order's value at the arrival mid.
+func (e *Execution) ShortfallBps() float64 {
+	if e.ArrivalMid <= 0 || e.Quantity <= 0 {
+		return 0
+	}
+	return e.Shortfall() / (e.Quantity * e.ArrivalMid) * 10000
+}
+
+// Function Done reports whether the order is no longer working.
+func (e *Execution) Done() bool {
+	return !routefire.IsOpenStatus(e.Status)
+}
+
+// Type Recorder records executions for orders submitted through it. Its
+// submit and status methods have the same signatures as routefire.API's.
+type Recorder struct {
+	dma routefire.DMA
+	api routefire.API
+
+	lock   sync.Mutex
+	orders map[string]*Execution
+
+	// Now returns the current time; it defaults to time.Now and can be set to
+	// a backtest clock.
+	Now func() time.Time
+}
+
+// Function New creates a recorder for DMA and algorithm orders.
+func New(api routefire.API) *Recorder {
+	r := NewDMA(api)
+	r.api = api
+	return r
+}
+
+// Function NewDMA creates a recorder for DMA orders only. Arrival IsoCost is
+// not captured.
+func NewDMA(api routefire.DMA) *Recorder {
+	return &Recorder{dma: api, orders: map[string]*Execution{}, Now: time.Now}
+}
+
+// Function SubmitOrderDMA captures the arrival prices, submits a DMA order and
+// records it.
+func (r *Recorder) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	e, err := r.arrival(userId, asset, baseAsset, strings.ToUpper(side), quantity, price)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.dma.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	if err != nil || len(resp.Errors) > 0 {
+		return resp, err
+	}
+	e.OrderId = resp.VenueOrderId
+	e.Venue = venue
+	e.Algo = AlgoDMA
+	r.add(dmaKey(venue, resp.VenueOrderId), e)
+	return resp, nil
+}
+
+// Function SubmitOrder captures the arrival prices, submits an algorithm order
+// and records it. The limit is taken from the iwould algo param. An order
+// returned without an id was not accepted; it is not recorded and
+// ErrNotAccepted is returned.
+func (r *Recorder) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if r.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	asset, baseAsset, side := routefire.AlgoOrderPair(buyAsset, sellAsset)
+	e, err := r.arrival(userId, asset, baseAsset, side, quantity, algoParams["iwould"])
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.api.SubmitOrder(userId, buyAsset, sellAsset, quantity, price, algo, algoParams)
+	if err != nil {
+		return resp, err
+	}
+	if resp.OrderId == "" {
+		return resp, ErrNotAccepted
+	}
+	e.OrderId = resp.OrderId
+	e.Algo = strings.ToLower(algo)
+	r.add(algoKey(resp.OrderId), e)
+	return resp, nil
+}
+
+// arrival builds an execution with the arrival mid and, if available, IsoCost.
+func (r *Recorder) arrival(userId, asset, baseAsset, side, quantity, limit string) (*Execution, error) {
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return nil, err
+	}
+	e := &Execution{
+		Asset:       asset,
+		BaseAsset:   baseAsset,
+		Side:        side,
+		Quantity:    qty,
+		SubmittedAt: r.Now(),
+		Status:      routefire.StatusOpen,
+		userId:      userId,
+	}
+	if limit != "" {
+		e.Limit, _ = strconv.ParseFloat(limit, 64)
+	}
+	e.ArrivalMid, _ = r.mid(userId, asset, baseAsset)
+	e.LastMid = e.ArrivalMid
+	if r.api != nil {
+		buyAsset, sellAsset := asset, baseAsset
+		if side != routefire.SideBuy {
+			buyAsset, sellAsset = baseAsset, asset
+		}
+		if stats, err := r.api.GetOrderBookStats(userId, buyAsset, sellAsset, quantity); err == nil {
+			e.ArrivalIsoCost = stats.IsoCost
+		}
+	}
+	return e, nil
+}
+
+func (r *Recorder) mid(userId, asset, baseAsset string) (float64, error) {
+	ob, err := r.dma.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+	if err == nil {
+		err = routefire.FirstDmaError(ob.Errors)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return ob.Data.MidPrice()
+}
+
+func (r *Recorder) add(key string, e *Execution) {
+	r.lock.Lock()
+	r.orders[key] = e
+	r.lock.Unlock()
+}
+
+func (r *Recorder) execution(key string) (*Execution, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	e, ok := r.orders[key]
+	if !ok {
+		return nil, ErrUnknownOrder
+	}
+	return e, nil
+}
+
+// Function OrderStatusDMA requests a recorded DMA order's status and records
+// any new fill. The status API does not report fill prices, so fills are
+// recorded at the order's limit price, or the mid if it has none.
+func (r *Recorder) OrderStatusDMA(userId, venue, venueOrdId string) (*routefire.DmaOrderStatusResponse, error) {
+	st, err := r.dma.OrderStatusDMA(userId, venue, venueOrdId)
+	if err != nil || len(st.Errors) > 0 {
+		return st, err
+	}
+	if e, err := r.execution(dmaKey(venue, venueOrdId)); err == nil {
+		r.update(e, st.Status, st.FilledAmount, e.Limit)
+	}
+	return st, nil
+}
+
+// Function GetOrderStatus requests a recorded algorithm order's status and
+// records any new fill at the current touch: the best offer for a buy, the
+// best bid for a sell.
+func (r *Recorder) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	if r.api == nil {
+		return nil, routefire.ErrNoAlgoAPI
+	}
+	st, err := r.api.GetOrderStatus(userId, orderId)
+	if err != nil {
+		return st, err
+	}
+	if e, err := r.execution(algoKey(orderId)); err == nil {
+		r.update(e, st.Status, st.Filled, r.touch(e))
+	}
+	return st, nil
+}
+
+// Function RecordFill records a fill reported by other means, such as a venue's
+// trade history, for an order whose status is not polled through the recorder.
+func (r *Recorder) RecordFill(orderId string, f Fill) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, e := range r.orders {
+		if e.OrderId == orderId {
+			e.Fills = append(e.Fills, f)
+			return nil
+		}
+	}
+	return ErrUnknownOrder
+}
+
+// Function Poll requests the status of every working order, recording fills.
+// It returns the first error encountered.
+func (r *Recorder) Poll() error {
+	var first error
+	for _, e := range r.Executions() {
+		if e.Done() {
+			continue
+		}
+		var err error
+		if e.Algo == AlgoDMA {
+			_, err = r.OrderStatusDMA(e.userId, e.Venue, e.OrderId)
+		} else {
+			_, err = r.GetOrderStatus(e.userId, e.OrderId)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (r *Recorder) touch(e *Execution) float64 {
+	ob, err := r.dma.GetConsolidatedOrderBookDMA(e.userId, e.Asset, e.BaseAsset)
+	if err != nil || len(ob.Errors) > 0 {
+		return 0
+	}
+	var best routefire.DmaOrderBookEntry
+	if e.Side == routefire.SideBuy {
+		best, err = ob.Data.BestOffer()
+	} else {
+		best, err = ob.Data.BestBid()
+	}
+	if err != nil {
+		return 0
+	}
+	px, _, _ := best.Floats()
+	return px
+}
+
+// update records the increase in the filled amount at price, falling back to
+// the mid, and refreshes LastMid.
+func (r *Recorder) update(e *Execution, status, filledAmount string, price float64) {
+	mid, err := r.mid(e.userId, e.Asset, e.BaseAsset)
+	filled, perr := strconv.ParseFloat(filledAmount, 64)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err == nil {
+		e.LastMid = mid
+	}
+	e.Status = status
+	if perr != nil {
+		return
+	}
+	delta := filled - e.Filled()
+	if delta < epsilon {
+		return
+	}
+	if price <= 0 {
+		price = e.LastMid
+	}
+	e.Fills = append(e.Fills, Fill{Time: r.Now(), Quantity: delta, Price: price})
+}
+
+// Function Execution returns a copy of the recorded order with the given id.
+func (r *Recorder) Execution(orderId string) (*Execution, error) {
+	for _, e := range r.Executions() {
+		if e.OrderId == orderId {
+			return e, nil
+		}
+	}
+	return nil, ErrUnknownOrder
+}
+
+// Function Executions returns copies of the recorded orders in submission
+// order.
+func (r *Recorder) Executions() []*Execution {
+	r.lock.Lock()
+	out := make([]*Execution, 0, len(r.orders))
+	for _, e := range r.orders {
+		out = append(out, e.copy())
+	}
+	r.lock.Unlock()
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].SubmittedAt.Equal(out[j].SubmittedAt) {
+			return out[i].SubmittedAt.Before(out[j].SubmittedAt)
+		}
+		return out[i].OrderId < out[j].OrderId
+	})
+	return out
+}
+
+func dmaKey(venue, venueOrderId string) string {
+	return "dma:" + strings.ToUpper(venue) + ":" + venueOrderId
+}
+
+func algoKey(orderId string) string {
+	return "algo:" + orderId
+}
@@ -0,0 +1,195 @@
+package tca
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/routefire/go-routefire"
+	"github.com/routefire/go-routefire/backtest"
+)
+
+const uid = "tca@example.com"
+
+func approx(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s: got %f, want %f", name, got, want)
+	}
+}
+
+// testEngine replays one snapshot per second with the given mids, each with a
+// bid one below and an offer one above on Gemini.
+func testEngine(mids ...float64) *backtest.Engine {
+	var snaps []backtest.Snapshot
+	for i, mid := range mids {
+		snaps = append(snaps, backtest.Snapshot{
+			Time:      time.Date(2019, 6, 1, 12, 0, i, 0, time.UTC),
+			Asset:     routefire.Btc,
+			BaseAsset: routefire.Usd,
+			Book: routefire.DmaOrderBook{
+				Bids:   []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: routefire.FormatFloat(mid - 1), Amount: "10"}},
+				Offers: []routefire.DmaOrderBookEntry{{Venue: routefire.Gemini, Price: routefire.FormatFloat(mid + 1), Amount: "10"}},
+			},
+		})
+	}
+	bt := backtest.New(snaps, backtest.Config{})
+	bt.Step()
+	return bt
+}
+
+func TestDMAReport(t *testing.T) {
+	bt := testEngine(100, 102)
+	r := NewDMA(bt)
+	r.Now = bt.Now
+
+	taker, err := r.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "101", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resting, err := r.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "2", "95", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Wait(time.Second)
+	if err := r.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := r.Report()
+	if len(rep.Results) != 2 || len(rep.ByVenue) != 1 || len(rep.ByAlgo) != 1 || rep.ByAlgo[0].Group != AlgoDMA {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+	filled, open := rep.Results[0], rep.Results[1]
+	if filled.OrderId != taker.VenueOrderId || open.OrderId != resting.VenueOrderId {
+		t.Fatalf("results out of order")
+	}
+	// Bought at 101 against an arrival mid of 100, filled a second later.
+	approx(t, "slippage", filled.SlippageBps, 100)
+	approx(t, "time to fill", filled.TimeToFill, 1)
+	// The resting order missed a move from 100 to 102 on 2 units.
+	approx(t, "fill rate", open.FillRate, 0)
+	approx(t, "shortfall", open.Shortfall, 4)
+	approx(t, "shortfall bps", open.ShortfallBps, 200)
+
+	gemini := rep.ByVenue[0]
+	if gemini.Group != routefire.Gemini || gemini.Orders != 2 || gemini.Completed != 1 {
+		t.Errorf("unexpected summary %+v", gemini)
+	}
+	approx(t, "venue fill rate", gemini.FillRate, 0.5)
+	approx(t, "venue slippage", gemini.SlippageBps, 100)
+	approx(t, "venue shortfall bps", gemini.ShortfallBps, (1+4)/300.0*10000)
+}
+
+// noVenue accepts orders without echoing their venue.
+type noVenue struct {
+	*backtest.Engine
+}
+
+func (v noVenue) SubmitOrderDMA(userId, venue, asset, baseAsset string, side string, quantity, price string, orderParams map[string]string) (*routefire.PlaceDmaOrderResponse, error) {
+	resp, err := v.Engine.SubmitOrderDMA(userId, venue, asset, baseAsset, side, quantity, price, orderParams)
+	if resp != nil {
+		resp.VenueId = ""
+	}
+	return resp, err
+}
+
+func TestDMASubmittedVenue(t *testing.T) {
+	bt := testEngine(100, 102)
+	r := NewDMA(noVenue{bt})
+	r.Now = bt.Now
+
+	resp, err := r.SubmitOrderDMA(uid, routefire.Gemini, routefire.Btc, routefire.Usd, routefire.SideBuy, "1", "101", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Wait(time.Second)
+	if err := r.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := r.Execution(resp.VenueOrderId)
+	if err != nil || e.Venue != routefire.Gemini || e.Filled() != 1 {
+		t.Fatalf("expected the order recorded and polled on %s, got %+v %v", routefire.Gemini, e, err)
+	}
+	// Executions are copies.
+	e.Fills = nil
+	if e, _ := r.Execution(resp.VenueOrderId); e.Filled() != 1 {
+		t.Errorf("changing a returned execution should not change the recorder")
+	}
+}
+
+// algoStub fills algorithm orders completely on their first status request.
+type algoStub struct {
+	routefire.API
+	bt *backtest.Engine
+}
+
+func (a algoStub) GetConsolidatedOrderBookDMA(userId, asset, baseAsset string) (*routefire.DmaOrderBookResponse, error) {
+	return a.bt.GetConsolidatedOrderBookDMA(userId, asset, baseAsset)
+}
+
+func (a algoStub) GetOrderBookStats(uid, buyAsset, sellAsset, quantity string) (*routefire.InquiryResponse, error) {
+	return &routefire.InquiryResponse{IsoCost: 98.5}, nil
+}
+
+func (a algoStub) SubmitOrder(userId string, buyAsset string, sellAsset string, quantity string, price string, algo string, algoParams map[string]string) (*routefire.SubmitOrderResponse, error) {
+	if quantity == "0" {
+		return &routefire.SubmitOrderResponse{}, nil
+	}
+	return &routefire.SubmitOrderResponse{OrderId: "ALGO-1"}, nil
+}
+
+func (a algoStub) GetOrderStatus(userId string, orderId string) (*routefire.OrderStatusResponse, error) {
+	return &routefire.OrderStatusResponse{Status: routefire.StatusFilled, Filled: "3"}, nil
+}
+
+func TestAlgoReportExports(t *testing.T) {
+	bt := testEngine(100)
+	r := New(algoStub{bt: bt})
+	r.Now = bt.Now
+
+	// Buying usd with btc sells btc; the fill is recorded at the best bid.
+	if _, err := r.SubmitOrder(uid, routefire.Usd, routefire.Btc, "3", "", "RFXW", map[string]string{"iwould": "98"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetOrderStatus(uid, "ALGO-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.SubmitOrder(uid, routefire.Usd, routefire.Btc, "0", "", "RFXW", nil); err != ErrNotAccepted {
+		t.Errorf("expected ErrNotAccepted, got %v", err)
+	}
+	rep := r.Report()
+	res := rep.Results[0]
+	if res.Algo != "rfxw" || res.Side != routefire.SideSell || res.Limit != 98 || res.ArrivalIsoCost != 98.5 || len(rep.ByVenue) != 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	approx(t, "slippage", res.SlippageBps, 100)
+
+	var js bytes.Buffer
+	if err := rep.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil || len(decoded.Results) != 1 || decoded.Results[0].Filled != 3 {
+		t.Errorf("unexpected JSON %s: %v", js.String(), err)
+	}
+
+	var buf bytes.Buffer
+	if err := rep.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 2 || rows[1][0] != "ALGO-1" || rows[1][14] != "100" {
+		t.Errorf("unexpected CSV %v: %v", rows, err)
+	}
+	buf.Reset()
+	if err := rep.WriteSummaryCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := csv.NewReader(&buf).ReadAll(); len(rows) != 2 || rows[1][0] != "algo" || rows[1][1] != "rfxw" {
+		t.Errorf("unexpected summary CSV %v", rows)
+	}
+}